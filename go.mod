@@ -3,12 +3,16 @@ module github.com/barelyhuman/alvu
 go 1.18
 
 require (
+	github.com/alecthomas/chroma v0.10.0
 	github.com/barelyhuman/go v0.2.2-0.20230713173609-2ee88bb52634
 	github.com/cjoudrey/gluahttp v0.0.0-20201111170219-25003d9adfa9
+	github.com/fsnotify/fsnotify v1.6.0
 	github.com/joho/godotenv v1.5.1
 	github.com/otiai10/copy v1.9.0
+	github.com/pelletier/go-toml/v2 v2.0.9
 	github.com/vadv/gopher-lua-libs v0.4.1
 	github.com/yuin/goldmark v1.5.4
+	github.com/yuin/goldmark-emoji v1.0.2
 	github.com/yuin/goldmark-highlighting v0.0.0-20220208100518-594be1970594
 	github.com/yuin/gopher-lua v1.1.0
 	golang.org/x/net v0.0.0-20200202094626-16171245cfb2
@@ -17,7 +21,6 @@ require (
 )
 
 require (
-	github.com/alecthomas/chroma v0.10.0 // indirect
 	github.com/dlclark/regexp2 v1.4.0 // indirect
 	golang.org/x/sys v0.0.0-20220908164124-27713097b956 // indirect
 	gopkg.in/yaml.v2 v2.3.0 // indirect
@@ -1,18 +1,50 @@
 package alvu
 
 import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	htmlTemplate "html/template"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
+	textTemplate "text/template"
+	"time"
+	"unicode"
 
+	"github.com/barelyhuman/go/env"
 	dotenv "github.com/joho/godotenv"
 	lua "github.com/yuin/gopher-lua"
+	luajson "layeh.com/gopher-json"
 )
 
 var api = map[string]lua.LGFunction{
-	"files":   GetFilesIndex,
-	"get_env": GetEnv,
+	"date":        FormatDateFn,
+	"files":       GetFilesIndex,
+	"get_env":     GetEnv,
+	"glob":        GlobFn,
+	"read_csv":    ReadCSVFn,
+	"paginate":    Paginate,
+	"pages":       GetPages,
+	"read_file":   ReadFileFn,
+	"render":      RenderFn,
+	"render_text": RenderTextFn,
+	"single_file": SingleFileFn,
+	"slugify":     SlugifyFn,
 }
 
+// pagesRegistryKey is where the pages set by alvu
+// are kept in the lua registry, rootRegistryKey
+// is the project directory and singleFileRegistryKey
+// is the path of the single file
+const (
+	pagesRegistryKey      = "alvu_pages"
+	rootRegistryKey       = "alvu_root"
+	singleFileRegistryKey = "alvu_single_file"
+)
+
 // Preload adds json to the given Lua state's package.preload table. After it
 // has been preloaded, it can be loaded using require:
 //
@@ -29,6 +61,38 @@ func Loader(L *lua.LState) int {
 	return 1
 }
 
+// PreloadEnv adds the env module to the given Lua state,
+// which reads the environment alvu was run with
+//
+//	local env = require("env")
+//	local api = env.get("API_URL", "http://localhost:8080")
+func PreloadEnv(L *lua.LState) {
+	L.PreloadModule("env", EnvLoader)
+}
+
+// EnvLoader is the loader of the env module
+func EnvLoader(L *lua.LState) int {
+	t := L.NewTable()
+	L.SetFuncs(t, map[string]lua.LGFunction{
+		"get": GetEnvVar,
+	})
+	L.Push(t)
+	return 1
+}
+
+// GetEnvVar lua env.get(name, default) returns the value of the
+// environment variable, or the default (nil if there's none)
+// when it's not set or empty
+func GetEnvVar(L *lua.LState) int {
+	name := L.CheckString(1)
+	if value := env.Get(name, ""); len(value) > 0 {
+		L.Push(lua.LString(value))
+		return 1
+	}
+	L.Push(L.Get(2))
+	return 1
+}
+
 // Decode lua json.decode(string) returns (table, err)
 func GetFilesIndex(L *lua.LState) int {
 	// path to get the index for
@@ -99,3 +163,545 @@ func LGetEnv(L *lua.LState, fromFile string, str string) lua.LString {
 	val := os.Getenv(str)
 	return lua.LString(val)
 }
+
+// Paginate lua alvu.paginate(table, size) returns a table of pages
+//
+// Each page is a table of the shape
+//
+//	{
+//		page = 1,
+//		total_pages = 3,
+//		has_prev = false,
+//		has_next = true,
+//		items = { ... },
+//	}
+//
+// an empty list still returns a single page with no items
+func Paginate(L *lua.LState) int {
+	items := L.CheckTable(1)
+	size := L.CheckInt(2)
+	if size < 1 {
+		L.ArgError(2, "page size should be greater than 0")
+		return 0
+	}
+
+	L.Push(LPaginate(L, items, size))
+	return 1
+}
+
+func LPaginate(L *lua.LState, items *lua.LTable, size int) *lua.LTable {
+	totalItems := items.Len()
+	totalPages := totalPageCount(totalItems, size)
+
+	pages := L.CreateTable(totalPages, 0)
+	for page := 1; page <= totalPages; page++ {
+		start := (page-1)*size + 1
+		end := start + size - 1
+		if end > totalItems {
+			end = totalItems
+		}
+
+		pageItems := L.CreateTable(end-start+1, 0)
+		for i := start; i <= end; i++ {
+			pageItems.Append(items.RawGetInt(i))
+		}
+
+		pageTable := L.CreateTable(0, 5)
+		pageTable.RawSetString("page", lua.LNumber(page))
+		pageTable.RawSetString("total_pages", lua.LNumber(totalPages))
+		pageTable.RawSetString("has_prev", lua.LBool(page > 1))
+		pageTable.RawSetString("has_next", lua.LBool(page < totalPages))
+		pageTable.RawSetString("items", pageItems)
+		pages.Append(pageTable)
+	}
+
+	return pages
+}
+
+func totalPageCount(totalItems int, size int) int {
+	if totalItems == 0 {
+		return 1
+	}
+	return (totalItems + size - 1) / size
+}
+
+// SetPages sets the list of pages returned by `alvu.pages()`,
+// the pages are passed as json
+func SetPages(L *lua.LState, pagesJSON []byte) error {
+	pages, err := luajson.Decode(L, pagesJSON)
+	if err != nil {
+		return err
+	}
+	L.G.Registry.RawSetString(pagesRegistryKey, pages)
+	return nil
+}
+
+// GetPages lua alvu.pages() returns the list of pages being built
+//
+// Each page is a table of the shape
+//
+//	{
+//		name = "blog/hello.md",
+//		url = "/blog/hello.html",
+//		meta = { ... },
+//	}
+func GetPages(L *lua.LState) int {
+	pages := L.G.Registry.RawGetString(pagesRegistryKey)
+	if pages == lua.LNil {
+		pages = L.NewTable()
+	}
+	L.Push(pages)
+	return 1
+}
+
+// SetRoot sets the project directory that `alvu.read_file`
+// and `alvu.glob` are relative to, the working directory
+// is used if it isn't set
+func SetRoot(L *lua.LState, root string) {
+	L.G.Registry.RawSetString(rootRegistryKey, lua.LString(root))
+}
+
+// SetSingleFile sets the path returned by `alvu.single_file()`
+func SetSingleFile(L *lua.LState, filePath string) {
+	L.G.Registry.RawSetString(singleFileRegistryKey, lua.LString(filePath))
+}
+
+// SingleFileFn lua alvu.single_file() returns the path of the
+// document written with `-single-file`, or nil if there is none,
+// it's set by the time `OnFinish` runs
+//
+//	function OnFinish()
+//		local book = alvu.single_file()
+//		if book then
+//			os.execute("pandoc " .. book .. " -o dist/book.epub")
+//		end
+//	end
+func SingleFileFn(L *lua.LState) int {
+	filePath, ok := L.G.Registry.RawGetString(singleFileRegistryKey).(lua.LString)
+	if !ok || len(filePath) == 0 {
+		L.Push(lua.LNil)
+		return 1
+	}
+	L.Push(filePath)
+	return 1
+}
+
+func getRoot(L *lua.LState) string {
+	if root, ok := L.G.Registry.RawGetString(rootRegistryKey).(lua.LString); ok && len(root) > 0 {
+		return string(root)
+	}
+	return "."
+}
+
+// ReadFileFn lua alvu.read_file(path) returns the content of the
+// file at the path relative to the project, or nil and an error
+// if it can't be read or is outside the project
+func ReadFileFn(L *lua.LState) int {
+	relPath := L.CheckString(1)
+
+	content, err := ReadProjectFile(getRoot(L), relPath)
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LString(content))
+	return 1
+}
+
+// GlobFn lua alvu.glob(pattern) returns a table of the files
+// matching the pattern relative to the project, eg:
+// `alvu.glob("data/*.json")`, or nil and an error
+func GlobFn(L *lua.LState) int {
+	pattern := L.CheckString(1)
+
+	matches, err := GlobProjectFiles(getRoot(L), pattern)
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	arr := L.CreateTable(len(matches), 0)
+	for _, match := range matches {
+		arr.Append(lua.LString(match))
+	}
+	L.Push(arr)
+	return 1
+}
+
+// ReadCSVFn lua alvu.read_csv(path, delimiter) reads the csv file
+// relative to the project into a table of rows keyed by the header,
+// eg: `alvu.read_csv("data/team.csv")`, the delimiter defaults to a
+// tab for .tsv files and a comma for the rest. Returns nil and an
+// error if the file can't be read or parsed
+func ReadCSVFn(L *lua.LState) int {
+	relPath := L.CheckString(1)
+	delimiter, err := CSVDelimiter(L.OptString(2, DefaultCSVDelimiter(relPath)))
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	content, err := ReadProjectFile(getRoot(L), relPath)
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	rows, err := ParseCSV(content, delimiter)
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(fmt.Sprintf("invalid csv file %v, error: %v", relPath, err)))
+		return 2
+	}
+
+	arr := L.CreateTable(len(rows), 0)
+	for _, row := range rows {
+		tbl := L.CreateTable(0, len(row))
+		for key, value := range row {
+			tbl.RawSetString(key, lua.LString(fmt.Sprint(value)))
+		}
+		arr.Append(tbl)
+	}
+	L.Push(arr)
+	return 1
+}
+
+// ReadProjectFile reads the file relative to the root, files
+// outside the root (symlinks included) can't be read
+func ReadProjectFile(root string, relPath string) ([]byte, error) {
+	filePath, err := projectPath(root, relPath)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := filepath.EvalSymlinks(filePath)
+	if err != nil {
+		return nil, err
+	}
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	if !isWithin(resolvedRoot, resolved) {
+		return nil, fmt.Errorf("`%v` is outside of the project", relPath)
+	}
+	return os.ReadFile(resolved)
+}
+
+// GlobProjectFiles returns the files matching the pattern
+// relative to the root, as slash separated relative paths
+func GlobProjectFiles(root string, pattern string) ([]string, error) {
+	globPath, err := projectPath(root, pattern)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := filepath.Glob(globPath)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	for _, match := range matches {
+		if info, err := os.Stat(match); err != nil || info.IsDir() {
+			continue
+		}
+		relPath, err := filepath.Rel(root, match)
+		if err != nil {
+			continue
+		}
+		files = append(files, filepath.ToSlash(relPath))
+	}
+	return files, nil
+}
+
+// projectPath joins the path to the root, the path has to be
+// relative and can't go outside of the root
+func projectPath(root string, relPath string) (string, error) {
+	if filepath.IsAbs(relPath) || strings.HasPrefix(relPath, "/") {
+		return "", fmt.Errorf("`%v` has to be relative to the project", relPath)
+	}
+	fullPath := filepath.Join(root, relPath)
+	if !isWithin(root, fullPath) {
+		return "", fmt.Errorf("`%v` is outside of the project", relPath)
+	}
+	return fullPath, nil
+}
+
+func isWithin(dir string, target string) bool {
+	rel, err := filepath.Rel(dir, target)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// ParseCSV reads the csv content into a list of rows keyed by
+// the header (the first row), the values are kept as strings and
+// every row has to have the same number of fields as the header
+func ParseCSV(content []byte, delimiter rune) ([]map[string]interface{}, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(content, []byte("\uFEFF"))))
+	reader.Comma = delimiter
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []map[string]interface{}{}
+	if len(records) == 0 {
+		return rows, nil
+	}
+	header := records[0]
+	for _, record := range records[1:] {
+		row := make(map[string]interface{}, len(header))
+		for index, key := range header {
+			if len(key) == 0 {
+				continue
+			}
+			row[key] = record[index]
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// DefaultCSVDelimiter is a tab for .tsv files and a comma for the rest
+func DefaultCSVDelimiter(filePath string) string {
+	if strings.EqualFold(filepath.Ext(filePath), ".tsv") {
+		return "\t"
+	}
+	return ","
+}
+
+// CSVDelimiter parses the delimiter, which is a single character
+// or `\t` / `tab` for tabs
+func CSVDelimiter(value string) (rune, error) {
+	if value == "\\t" || value == "tab" {
+		return '\t', nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 || runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' || runes[0] == unicode.ReplacementChar {
+		return 0, fmt.Errorf("invalid csv delimiter `%v`, use a single character like `,` `;` or `\\t`", value)
+	}
+	return runes[0], nil
+}
+
+// RenderFn lua alvu.render(template, data) renders the go template
+// with the table as the data, eg: `{{range .posts}}{{.title}}{{end}}`,
+// the values are escaped for where they're placed in the html, like
+// in the layouts. Returns nil and an error if the template is invalid
+func RenderFn(L *lua.LState) int {
+	return renderTemplate(L, true)
+}
+
+// RenderTextFn lua alvu.render_text(template, data) is the same as
+// alvu.render but nothing is escaped, for xml, json, markdown, etc
+func RenderTextFn(L *lua.LState) int {
+	return renderTemplate(L, false)
+}
+
+func renderTemplate(L *lua.LState, escape bool) int {
+	source := L.CheckString(1)
+
+	var data interface{}
+	if value := L.Get(2); value != lua.LNil {
+		encoded, err := luajson.Encode(value)
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		if err := json.Unmarshal(encoded, &data); err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+	}
+
+	rendered, err := RenderTemplate(source, data, escape)
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LString(rendered))
+	return 1
+}
+
+// templateFuncs are the helpers available to the
+// templates rendered from the hooks
+var templateFuncs = map[string]interface{}{
+	"date": func(format string, value interface{}) string {
+		formatted, _ := FormatDate(format, value)
+		return formatted
+	},
+	"safeHTML": func(content string) htmlTemplate.HTML {
+		return htmlTemplate.HTML(content)
+	},
+	"slugify": Slugify,
+}
+
+// RenderTemplate renders the go template with the data, with
+// html/template if the output is to be escaped and text/template
+// otherwise
+func RenderTemplate(source string, data interface{}, escape bool) (string, error) {
+	var rendered bytes.Buffer
+	if escape {
+		tmpl, err := htmlTemplate.New("hook").Funcs(templateFuncs).Parse(source)
+		if err != nil {
+			return "", err
+		}
+		if err := tmpl.Execute(&rendered, data); err != nil {
+			return "", err
+		}
+		return rendered.String(), nil
+	}
+
+	tmpl, err := textTemplate.New("hook").Funcs(templateFuncs).Parse(source)
+	if err != nil {
+		return "", err
+	}
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", err
+	}
+	return rendered.String(), nil
+}
+
+// SlugifyFn lua alvu.slugify(string) returns the slug of the string
+func SlugifyFn(L *lua.LState) int {
+	str := L.CheckString(1)
+	L.Push(lua.LString(Slugify(str)))
+	return 1
+}
+
+// FormatDateFn lua alvu.date(format, date) returns the date in
+// the given go layout, eg: `alvu.date("Jan 2, 2006", "2024-03-05")`
+//
+// a date that can't be parsed is returned as is along with an error
+func FormatDateFn(L *lua.LState) int {
+	format := L.CheckString(1)
+	value := L.CheckString(2)
+
+	formatted, ok := FormatDate(format, value)
+	L.Push(lua.LString(formatted))
+	if !ok {
+		L.Push(lua.LString(fmt.Sprintf("invalid date `%v`", value)))
+		return 2
+	}
+	return 1
+}
+
+// slugReplacements are the letters that don't decompose into
+// an ascii letter and a combining mark
+var slugReplacements = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'đ': "d", 'ð': "d",
+	'þ': "th", 'ł': "l", 'ı': "i",
+}
+
+// slugFolds maps the accented latin letters to the
+// letter without the accent
+var slugFolds = map[string]string{
+	"a": "àáâãäåāăą",
+	"c": "çćĉċč",
+	"d": "ď",
+	"e": "èéêëēĕėęě",
+	"g": "ĝğġģ",
+	"h": "ĥħ",
+	"i": "ìíîïĩīĭįİ",
+	"j": "ĵ",
+	"k": "ķ",
+	"l": "ĺļľŀ",
+	"n": "ñńņňŉ",
+	"o": "òóôõöōŏő",
+	"r": "ŕŗř",
+	"s": "śŝşšș",
+	"t": "ţťŧț",
+	"u": "ùúûüũūŭůűų",
+	"w": "ŵ",
+	"y": "ýÿŷ",
+	"z": "źżž",
+}
+
+var slugFoldTable = func() map[rune]string {
+	table := map[rune]string{}
+	for base, letters := range slugFolds {
+		for _, letter := range letters {
+			table[letter] = base
+		}
+	}
+	for letter, replacement := range slugReplacements {
+		table[letter] = replacement
+	}
+	return table
+}()
+
+// Slugify lowercases the text, removes the accents from latin
+// letters and replaces everything that's not a letter or a
+// number with a `-`, eg: `Héllo, World!` => `hello-world`
+func Slugify(text string) string {
+	var slug strings.Builder
+	pendingDash := false
+	for _, char := range strings.ToLower(text) {
+		if replacement, ok := slugFoldTable[char]; ok {
+			if pendingDash {
+				slug.WriteByte('-')
+				pendingDash = false
+			}
+			slug.WriteString(replacement)
+			continue
+		}
+		if unicode.Is(unicode.Mn, char) {
+			continue
+		}
+		if !unicode.IsLetter(char) && !unicode.IsDigit(char) {
+			pendingDash = slug.Len() > 0
+			continue
+		}
+		if pendingDash {
+			slug.WriteByte('-')
+			pendingDash = false
+		}
+		slug.WriteRune(char)
+	}
+	return slug.String()
+}
+
+var dateFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// ParseDate reads a date from a `time.Time` (yaml and toml
+// parse dates on their own) or from a string in one of
+// the common date formats
+func ParseDate(value interface{}) (time.Time, bool) {
+	if value == nil {
+		return time.Time{}, false
+	}
+
+	if date, ok := value.(time.Time); ok {
+		return date, true
+	}
+
+	dateString := strings.TrimSpace(fmt.Sprint(value))
+	for _, format := range dateFormats {
+		if date, err := time.Parse(format, dateString); err == nil {
+			return date, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// FormatDate formats the date in the given go layout, a
+// date that can't be parsed is returned as is
+func FormatDate(format string, value interface{}) (string, bool) {
+	date, ok := ParseDate(value)
+	if !ok {
+		if value == nil {
+			return "", false
+		}
+		return fmt.Sprint(value), false
+	}
+	return date.Format(format), true
+}
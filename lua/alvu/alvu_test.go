@@ -0,0 +1,275 @@
+package alvu
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// runLua runs the script with the alvu module preloaded
+// and the project root set to the given directory
+func runLua(t *testing.T, root string, script string) error {
+	t.Helper()
+	L := lua.NewState()
+	defer L.Close()
+	Preload(L)
+	SetRoot(L, root)
+	return L.DoString(script)
+}
+
+func TestPaginate(t *testing.T) {
+	err := runLua(t, t.TempDir(), `
+local alvu = require("alvu")
+
+local pages = alvu.paginate({"a", "b", "c", "d", "e"}, 2)
+assert(#pages == 3, "expected 3 pages, got " .. #pages)
+
+local first, middle, last = pages[1], pages[2], pages[3]
+assert(first.page == 1 and first.total_pages == 3)
+assert(not first.has_prev and first.has_next)
+assert(#first.items == 2 and first.items[1] == "a" and first.items[2] == "b")
+assert(middle.has_prev and middle.has_next)
+assert(middle.items[1] == "c" and middle.items[2] == "d")
+assert(last.has_prev and not last.has_next)
+assert(#last.items == 1 and last.items[1] == "e")
+
+local empty = alvu.paginate({}, 10)
+assert(#empty == 1 and #empty[1].items == 0 and not empty[1].has_next)
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPaginateRejectsInvalidSize(t *testing.T) {
+	err := runLua(t, t.TempDir(), `require("alvu").paginate({"a"}, 0)`)
+	if err == nil || !strings.Contains(err.Error(), "page size should be greater than 0") {
+		t.Fatalf("expected an error for the page size, got %v", err)
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := map[string]string{
+		"Héllo, World!":           "hello-world",
+		"  Leading and trailing ": "leading-and-trailing",
+		"a--b__c":                 "a-b-c",
+		"Straße Œuvre":            "strasse-oeuvre",
+		"\u00e9cole":              "ecole",
+		"e\u0301cole":             "ecole",
+		"İstanbul":                "istanbul",
+		"日本語 テキスト":                "日本語-テキスト",
+		"Go 1.18 Release":         "go-1-18-release",
+		"":                        "",
+		"!!!":                     "",
+	}
+	for text, expected := range tests {
+		if got := Slugify(text); got != expected {
+			t.Errorf("Slugify(%q) = %q, expected %q", text, got, expected)
+		}
+	}
+}
+
+func TestFormatDate(t *testing.T) {
+	tests := []struct {
+		value     interface{}
+		expected  string
+		parseable bool
+	}{
+		{"2024-03-05", "Mar 5, 2024", true},
+		{"2024-03-05T10:30:00Z", "Mar 5, 2024", true},
+		{"2024-03-05 10:30:00", "Mar 5, 2024", true},
+		{time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC), "Mar 5, 2024", true},
+		{"next tuesday", "next tuesday", false},
+		{nil, "", false},
+	}
+	for _, test := range tests {
+		got, ok := FormatDate("Jan 2, 2006", test.value)
+		if got != test.expected || ok != test.parseable {
+			t.Errorf("FormatDate(%v) = %q, %v, expected %q, %v", test.value, got, ok, test.expected, test.parseable)
+		}
+	}
+}
+
+func TestSlugifyAndDateFromLua(t *testing.T) {
+	err := runLua(t, t.TempDir(), `
+local alvu = require("alvu")
+
+assert(alvu.slugify("Héllo, World!") == "hello-world")
+
+local formatted, err = alvu.date("2006/01/02", "2024-03-05")
+assert(formatted == "2024/03/05" and err == nil)
+
+formatted, err = alvu.date("2006/01/02", "not a date")
+assert(formatted == "not a date", formatted)
+assert(err == "invalid date `+"`not a date`"+`", err)
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// projectDir creates a project with `data/a.json` and `data/b.json`, a
+// `secret.txt` next to the project, and symlinks in the project to
+// both a file in the project and the one outside of it
+func projectDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	root := filepath.Join(dir, "project")
+	files := map[string]string{
+		"project/data/a.json": `{"a": 1}`,
+		"project/data/b.json": `{"b": 2}`,
+		"secret.txt":          "secret",
+	}
+	for name, content := range files {
+		filePath := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(root, "escape.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "data", "a.json"), filepath.Join(root, "linked.json")); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestProjectPath(t *testing.T) {
+	root := filepath.Join("site", "project")
+	tests := []struct {
+		relPath  string
+		expected string
+		ok       bool
+	}{
+		{"data/a.json", filepath.Join(root, "data", "a.json"), true},
+		{"./data/../data/a.json", filepath.Join(root, "data", "a.json"), true},
+		{".", root, true},
+		{"..x/file", filepath.Join(root, "..x", "file"), true},
+		{"../x", "", false},
+		{"..", "", false},
+		{"data/../../x", "", false},
+		{"/etc/passwd", "", false},
+		{"/", "", false},
+	}
+	for _, test := range tests {
+		got, err := projectPath(root, test.relPath)
+		if got != test.expected || (err == nil) != test.ok {
+			t.Errorf("projectPath(%q) = %q, %v, expected %q, ok: %v", test.relPath, got, err, test.expected, test.ok)
+		}
+	}
+}
+
+func TestReadProjectFile(t *testing.T) {
+	root := projectDir(t)
+	tests := []struct {
+		relPath  string
+		expected string
+		err      string
+	}{
+		{"data/a.json", `{"a": 1}`, ""},
+		{"linked.json", `{"a": 1}`, ""},
+		{"../secret.txt", "", "is outside of the project"},
+		{"data/../../secret.txt", "", "is outside of the project"},
+		{filepath.Join(filepath.Dir(root), "secret.txt"), "", "has to be relative to the project"},
+		{"escape.txt", "", "is outside of the project"},
+		{"missing.txt", "", "no such file"},
+	}
+	for _, test := range tests {
+		content, err := ReadProjectFile(root, test.relPath)
+		if len(test.err) == 0 {
+			if err != nil || string(content) != test.expected {
+				t.Errorf("ReadProjectFile(%q) = %q, %v, expected %q", test.relPath, content, err, test.expected)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("ReadProjectFile(%q) expected an error with %q, got %q, %v", test.relPath, test.err, content, err)
+		}
+	}
+}
+
+func TestReadFileAndGlobFromLua(t *testing.T) {
+	root := projectDir(t)
+	err := runLua(t, root, `
+local alvu = require("alvu")
+
+local content, err = alvu.read_file("data/a.json")
+assert(content == '{"a": 1}' and err == nil, err)
+
+content, err = alvu.read_file("../secret.txt")
+assert(content == nil and string.find(err, "outside of the project"), err)
+
+content, err = alvu.read_file("escape.txt")
+assert(content == nil and string.find(err, "outside of the project"), err)
+
+local files, err = alvu.glob("data/*.json")
+assert(err == nil, err)
+assert(#files == 2 and files[1] == "data/a.json" and files[2] == "data/b.json")
+
+files, err = alvu.glob("../*.txt")
+assert(files == nil and string.find(err, "outside of the project"), err)
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRenderFromLua(t *testing.T) {
+	err := runLua(t, t.TempDir(), `
+local alvu = require("alvu")
+
+local posts = {
+    {title = "First & <best>", slug = "first"},
+    {title = "Second", slug = "second"},
+}
+local template = '<ul>{{range .}}<li><a href="/{{.slug}}">{{.title}}</a></li>{{end}}</ul>'
+
+local html, err = alvu.render(template, posts)
+assert(err == nil, err)
+local want = '<ul><li><a href="/first">First &amp; &lt;best&gt;</a></li><li><a href="/second">Second</a></li></ul>'
+assert(html == want, "got " .. tostring(html))
+
+local text, err = alvu.render_text("{{range .}}- {{.title}}\n{{end}}", posts)
+assert(err == nil, err)
+assert(text == "- First & <best>\n- Second\n", "got " .. tostring(text))
+
+local safe = alvu.render("{{safeHTML .}}", "<b>bold</b>")
+assert(safe == "<b>bold</b>", "got " .. tostring(safe))
+
+local none, err = alvu.render("{{range}", posts)
+assert(none == nil and err ~= nil, "expected an error for the invalid template")
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEnvGet(t *testing.T) {
+	t.Setenv("ALVU_TEST_SET", "value")
+	t.Setenv("ALVU_TEST_EMPTY", "")
+	os.Unsetenv("ALVU_TEST_UNSET")
+
+	L := lua.NewState()
+	defer L.Close()
+	PreloadEnv(L)
+	err := L.DoString(`
+local env = require("env")
+
+assert(env.get("ALVU_TEST_SET") == "value")
+assert(env.get("ALVU_TEST_SET", "default") == "value")
+assert(env.get("ALVU_TEST_UNSET") == nil)
+assert(env.get("ALVU_TEST_UNSET", "default") == "default")
+assert(env.get("ALVU_TEST_EMPTY", "default") == "default")
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,1043 +1,264 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"html/template"
-	textTmpl "text/template"
-
-	"io"
-	"io/fs"
-	"log"
-	"net/http"
 	"os"
-	"path"
-	"path/filepath"
-	"regexp"
-	"runtime"
 	"strings"
-	"sync"
 
 	_ "embed"
 
-	"github.com/barelyhuman/go/env"
-	"github.com/barelyhuman/go/poller"
-	ghttp "github.com/cjoudrey/gluahttp"
-
+	"github.com/barelyhuman/alvu/pkg/alvu"
 	"github.com/barelyhuman/go/color"
-	cp "github.com/otiai10/copy"
-
-	stringsLib "github.com/vadv/gopher-lua-libs/strings"
-
-	yamlLib "github.com/vadv/gopher-lua-libs/yaml"
-	"github.com/yuin/goldmark"
-	"github.com/yuin/goldmark/extension"
-	"github.com/yuin/goldmark/parser"
-	"github.com/yuin/goldmark/renderer"
-	"github.com/yuin/goldmark/renderer/html"
-
-	highlighting "github.com/yuin/goldmark-highlighting"
-
-	lua "github.com/yuin/gopher-lua"
-	"gopkg.in/yaml.v3"
-
-	luaAlvu "github.com/barelyhuman/alvu/lua/alvu"
-	"golang.org/x/net/websocket"
-	luajson "layeh.com/gopher-json"
 )
 
 const logPrefix = "[alvu] "
 
-var mdProcessor goldmark.Markdown
-var baseurl string
-var basePath string
-var outPath string
-var hardWraps bool
-var hookCollection HookCollection
-var reloadCh = []chan bool{}
-var serveFlag *bool
-var notFoundPageExists bool
-
 //go:embed .commitlog.release
 var release string
 
-var layoutFiles []string = []string{"_head.html", "_tail.html", "_layout.html"}
-
-type SiteMeta struct {
-	BaseURL string
-}
-
-type PageRenderData struct {
-	Meta   SiteMeta
-	Data   map[string]interface{}
-	Extras map[string]interface{}
-}
-
-type LayoutRenderData struct {
-	PageRenderData
-	Content template.HTML
-}
-
-// TODO: move stuff into the alvu struct type
-// on each newly added feature or during improving
-// older features.
-type Alvu struct {
-	publicPath string
-	files      []*AlvuFile
-	filesIndex []string
-}
-
-func (al *Alvu) AddFile(file *AlvuFile) {
-	al.files = append(al.files, file)
-	al.filesIndex = append(al.filesIndex, file.sourcePath)
-}
-
-func (al *Alvu) IsAlvuFile(filePath string) bool {
-	for _, af := range al.filesIndex {
-		if af == filePath {
-			return true
-		}
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		bail(err)
 	}
-	return false
 }
 
-func (al *Alvu) Build() {
-	for ind := range al.files {
-		alvuFile := al.files[ind]
-		alvuFile.Build()
-	}
+// run builds (and serves) the site with the given
+// command line arguments
+func run(args []string) error {
+	flags := flag.NewFlagSet("alvu", flag.ContinueOnError)
 
-	onDebug(func() {
-		debugInfo("Run all OnFinish Hooks")
-		memuse()
-	})
-
-	// right before completion run all hooks again but for the onFinish
-	hookCollection.RunAll("OnFinish")
-}
+	var versionFlag bool
 
-func (al *Alvu) CopyPublic() {
-	onDebug(func() {
-		debugInfo("Before copying files")
-		memuse()
-	})
-	// copy public to out
-	_, err := os.Stat(al.publicPath)
-	if err == nil {
-		err = cp.Copy(al.publicPath, outPath)
-		if err != nil {
-			bail(err)
+	flags.BoolVar(&versionFlag, "version", false, "version info")
+	flags.BoolVar(&versionFlag, "v", false, "version info")
+	basePathFlag := flags.String("path", ".", "`DIR` to search for the needed folders in")
+	outPathFlag := flags.String("out", "./dist", "`DIR` to output the compiled files to")
+	baseurlFlag := flags.String("baseurl", "/", "`URL` to be used as the root of the project")
+	hooksPathFlag := flags.String("hooks", "./hooks", "`DIR` that contains hooks for the content")
+	enableHighlightingFlag := flags.Bool("highlight", false, "enable highlighting for markdown files")
+	highlightThemeFlag := flags.String("highlight-theme", "bw", "`THEME` to use for highlighting (supports most themes from pygments or a path to a chroma xml theme)")
+	highlightLineNumbersFlag := flags.Bool("highlight-linenos", false, "show line numbers in the highlighted code blocks")
+	highlightCSSFlag := flags.Bool("highlight-css", false, "use classes for highlighting and write the theme to `highlight.css` instead of inline styles")
+	serveFlag := flags.Bool("serve", false, "start a local server")
+	hardWrapsFlag := flags.Bool("hard-wrap", true, "enable hard wrapping of elements with `<br>`")
+	mdDisableFlag := stringListFlag{}
+	flags.Var(&mdDisableFlag, "md-disable", "`NAME` of a markdown extension to turn off (linkify, table, strikethrough, tasklist or footnote), can be repeated")
+	h1TitleFlag := flags.Bool("h1-title", true, "use the first h1 of a markdown page as the title when the front matter has none")
+	warnDuplicateIDsFlag := flags.Bool("warn-duplicate-ids", false, "warn about the headings in a page that get a numbered id since an earlier one has the same id")
+	definitionListsFlag := flags.Bool("definition-lists", false, "enable definition lists in markdown files")
+	typographerFlag := flags.Bool("typographer", false, "replace quotes, dashes and ellipses in markdown files with their typographic versions")
+	typographerDisableFlag := stringListFlag{}
+	flags.Var(&typographerDisableFlag, "typographer-disable", "`NAME` of a typographer substitution to turn off (single-quotes, double-quotes, en-dash, em-dash, ellipsis or angle-quotes), can be repeated")
+	mathFlag := flags.Bool("math", false, "keep $...$ and $$...$$ in markdown files as is for katex or mathjax to render")
+	mathScriptFlag := flags.Bool("math-script", false, "add katex from a cdn to the pages with math (implies -math)")
+	mermaidFlag := flags.Bool("mermaid", false, "write the mermaid code blocks in markdown files as diagrams for mermaid to render")
+	mermaidScriptFlag := flags.Bool("mermaid-script", false, "add mermaid from a cdn to the pages with diagrams (implies -mermaid)")
+	safeMarkdownFlag := flags.Bool("safe-markdown", false, "leave the raw html in markdown files out, for content that isn't trusted")
+	sanitizeMarkdownFlag := flags.Bool("sanitize-markdown", false, "keep the allowed tags and attributes of the raw html in markdown files instead of leaving it out (implies -safe-markdown)")
+	sanitizeFlag := flags.Bool("sanitize", false, "run the html of markdown files through the allowlist of tags and attributes from sanitize-policy in the config")
+	emojiFlag := flags.Bool("emoji", false, "replace emoji shortcodes like :tada: in markdown files with their emojis")
+	emojiModeFlag := flags.String("emoji-mode", "unicode", "`MODE` to write the emojis in, unicode or image (twemoji img tags)")
+	rootRelativeLinksFlag := flags.Bool("root-relative-links", false, "rewrite the relative src and href of markdown pages to start from the baseurl")
+	portFlag := flags.String("port", "3000", "`PORT` to start the server on, 0 picks a free one")
+	pollDurationFlag := flags.Int("poll", 350, "`MS` to wait for more changes before rebuilding when watching")
+	watchFlag := flags.Bool("watch", true, "watch for changes and rebuild when serving")
+	tlsFlag := flags.Bool("tls", false, "serve over https with a self signed certificate for localhost")
+	certFlag := flags.String("cert", "", "`FILE` with the certificate to serve over https")
+	keyFlag := flags.String("key", "", "`FILE` with the key of the certificate to serve over https")
+	openFlag := flags.Bool("open", false, "open the browser once the server is up")
+	noCompressFlag := flags.Bool("no-compress", false, "disable the gzip/deflate compression of the served files")
+	reloadPortFlag := flags.String("reload-port", "", "`PORT` for the live reload socket (defaults to the same port as the server)")
+	sitemapFlag := flags.Bool("sitemap", false, "generate a sitemap.xml for the compiled pages")
+	feedFormatFlag := flags.String("feed-format", "", "`FORMAT` of the feed to generate for pages with a date (rss, json or both)")
+	feedTitleFlag := flags.String("feed-title", "", "`TITLE` to use for the generated feed")
+	singleFileFlag := flags.String("single-file", "", "join the html pages into one document at `FILE`, relative to the output, instead of writing each one")
+	singleFileManifestFlag := flags.String("single-file-manifest", "", "`FILE` listing the pages, relative to pages, to join into the single file in their order")
+	pdfFlag := flags.String("pdf", "", "write the single file as a pdf to `FILE`, relative to the output, with the -pdf-command")
+	pdfCommandFlag := flags.String("pdf-command", "wkhtmltopdf {input} {output}", "`COMMAND` that converts the single file to the pdf, {input} and {output} are replaced with their paths")
+	searchIndexFlag := flags.Bool("search-index", false, "write the title, url and text of every page to search-index.json for client side search")
+	draftsFlag := flags.Bool("drafts", false, "include pages marked as draft in the meta")
+	futureFlag := flags.Bool("future", false, "include pages with a date in the future")
+	cleanFlag := flags.Bool("clean", false, "remove everything in the output directory before building")
+	cleanDryRunFlag := flags.Bool("clean-dry-run", false, "list what -clean would remove and exit")
+	failFastFlag := flags.Bool("fail-fast", true, "stop at the first file that fails to build, use -fail-fast=false to build the rest and list the failures at the end")
+	strictFlag := flags.Bool("strict", false, "fail the pages that don't match the front matter schema in alvu.schema.yaml instead of warning")
+	strictMarkdownFlag := flags.Bool("strict-markdown", false, "fail the build when a page links to a page or file that isn't in the output")
+	checkExternalFlag := flags.Bool("check-external", false, "check the links to other sites as well, turns on -strict-markdown")
+	dryRunFlag := flags.Bool("dry-run", false, "list where each file would be written to without building")
+	prettyURLsFlag := flags.Bool("pretty-urls", false, "write pages as `name/index.html` instead of `name.html`")
+	minifyFlag := flags.Bool("minify", false, "minify the generated html and the css files from public")
+	fingerprintFlag := flags.Bool("fingerprint", false, "add a content hash to the names of css and js files from public")
+	tocMinLevelFlag := flags.Int("toc-min-level", 2, "`LEVEL` of the smallest heading level to add to the table of contents")
+	wordsPerMinuteFlag := flags.Int("words-per-minute", 200, "`N` words read per minute for the reading time of the pages")
+	navSortFlag := flags.String("nav-sort", "date", "meta `KEY` to order the pages by for the prev/next links")
+	navScopeFlag := flags.String("nav-scope", "dir", "`SCOPE` of the prev/next links, either dir or site")
+	templateExtsFlag := flags.String("template-exts", strings.Join(alvu.DefaultTemplateExtensions, ","), "comma separated `EXTENSIONS` of the files in pages to run through the templates, the rest are copied as is")
+	csvDelimiterFlag := flags.String("csv-delimiter", ",", "`CHAR` that separates the fields of the csv files in the data directory, use \\t for tabs")
+	excludeFlag := stringListFlag{}
+	flags.Var(&excludeFlag, "exclude", "glob `PATTERN` of files to leave out from pages and public, can be repeated")
+	stdinFlag := flags.Bool("stdin", false, "convert a single markdown document from stdin and write the html to stdout")
+	layoutFlag := flags.String("layout", "", "layout `FILE` (relative to the working directory) to wrap the document from -stdin in")
+	noLegacyHeadTailFlag := flags.Bool("no-legacy-headtail", false, "ignore the deprecated _head.html and _tail.html files, for sites that have moved to _layout.html")
+	followSymlinksFlag := flags.Bool("follow-symlinks", false, "copy what the symlinks in public point to instead of linking to the same target")
+	noCacheFlag := flags.Bool("no-cache", false, "build every file instead of skipping the ones that haven't changed since the last build")
+	reportFlag := flags.String("report", "", "`FILE` to write a json report of the build to, with the outputs, sizes and durations of every file")
+	timingFlag := flags.Bool("timing", false, "print how long each phase of the build took once it's done")
+	jobsFlag := flags.Int("jobs", 0, "`N` number of files to process in parallel, the number of CPUs by default or 1 when a hook has an OnStart")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
 		}
+		return err
 	}
-	onDebug(func() {
-		debugInfo("After copying files")
-		memuse()
-	})
-}
-
-func main() {
-	onDebug(func() {
-		debugInfo("Before Exec")
-		memuse()
-	})
-
-	var versionFlag bool
-
-	flag.BoolVar(&versionFlag, "version", false, "version info")
-	flag.BoolVar(&versionFlag, "v", false, "version info")
-	basePathFlag := flag.String("path", ".", "`DIR` to search for the needed folders in")
-	outPathFlag := flag.String("out", "./dist", "`DIR` to output the compiled files to")
-	baseurlFlag := flag.String("baseurl", "/", "`URL` to be used as the root of the project")
-	hooksPathFlag := flag.String("hooks", "./hooks", "`DIR` that contains hooks for the content")
-	enableHighlightingFlag := flag.Bool("highlight", false, "enable highlighting for markdown files")
-	highlightThemeFlag := flag.String("highlight-theme", "bw", "`THEME` to use for highlighting (supports most themes from pygments)")
-	serveFlag = flag.Bool("serve", false, "start a local server")
-	hardWrapsFlag := flag.Bool("hard-wrap", true, "enable hard wrapping of elements with `<br>`")
-	portFlag := flag.String("port", "3000", "`PORT` to start the server on")
-	pollDurationFlag := flag.Int("poll", 350, "Polling duration for file changes in milliseconds")
-
-	flag.Parse()
 
 	// Show version and exit
 	if versionFlag {
 		println(release)
-		os.Exit(0)
-	}
-
-	baseurl = *baseurlFlag
-	basePath = path.Join(*basePathFlag)
-	pagesPath := path.Join(*basePathFlag, "pages")
-	publicPath := path.Join(*basePathFlag, "public")
-	headFilePath := path.Join(pagesPath, "_head.html")
-	baseFilePath := path.Join(pagesPath, "_layout.html")
-	tailFilePath := path.Join(pagesPath, "_tail.html")
-	notFoundFilePath := path.Join(pagesPath, "404.html")
-	outPath = path.Join(*outPathFlag)
-	hooksPath := path.Join(*basePathFlag, *hooksPathFlag)
-	hardWraps = *hardWrapsFlag
-
-	headTailDeprecationWarning := color.ColorString{}
-	headTailDeprecationWarning.Yellow(logPrefix).Yellow("[WARN] use of _tail.html and _head.html is deprecated, please use _layout.html instead")
-
-	os.MkdirAll(publicPath, os.ModePerm)
-
-	alvuApp := &Alvu{
-		publicPath: publicPath,
-	}
-
-	watcher := NewWatcher(alvuApp, *pollDurationFlag)
-
-	if *serveFlag {
-		watcher.AddDir(pagesPath)
-		watcher.AddDir(publicPath)
+		return nil
 	}
 
-	onDebug(func() {
-		debugInfo("Opening _head")
-		memuse()
-	})
-	headFileFd, err := os.Open(headFilePath)
+	siteConfig, err := alvu.LoadConfig(*basePathFlag)
 	if err != nil {
-		if err == fs.ErrNotExist {
-			log.Println("no _head.html found,skipping")
-		}
-	} else {
-		fmt.Println(headTailDeprecationWarning.String())
+		return err
 	}
-
-	onDebug(func() {
-		debugInfo("Opening _layout")
-		memuse()
-	})
-	baseFileFd, err := os.Open(baseFilePath)
-	if err != nil {
-		if err == fs.ErrNotExist {
-			log.Println("no _layout.html found,skipping")
-		}
+	if err := alvu.ApplyConfigToFlags(flags, siteConfig); err != nil {
+		return err
 	}
-
-	onDebug(func() {
-		debugInfo("Opening _tail")
-		memuse()
-	})
-	tailFileFd, err := os.Open(tailFilePath)
+	collections, err := alvu.ConfigCollections(siteConfig)
 	if err != nil {
-		if err == fs.ErrNotExist {
-			log.Println("no _tail.html found, skipping")
-		}
-	} else {
-		fmt.Println(headTailDeprecationWarning.String())
-	}
-
-	onDebug(func() {
-		debugInfo("Checking if 404.html exists")
-		memuse()
-	})
-	if _, err := os.Stat(notFoundFilePath); errors.Is(err, os.ErrNotExist) {
-		notFoundPageExists = false
-		log.Println("no 404.html found, skipping")
-	} else {
-		notFoundPageExists = true
-	}
-
-	alvuApp.CopyPublic()
-
-	onDebug(func() {
-		debugInfo("Reading hook and to process files")
-		memuse()
-	})
-	CollectHooks(basePath, hooksPath)
-	toProcess := CollectFilesToProcess(pagesPath)
-	onDebug(func() {
-		log.Println("printing files to process")
-		log.Println(toProcess)
-	})
-
-	initMDProcessor(*enableHighlightingFlag, *highlightThemeFlag)
-
-	onDebug(func() {
-		debugInfo("Running all OnStart hooks")
-		memuse()
-	})
-
-	hookCollection.RunAll("OnStart")
-
-	prefixSlashPath := regexp.MustCompile(`^\/`)
-
-	onDebug(func() {
-		debugInfo("Creating Alvu Files")
-		memuse()
-	})
-	for _, toProcessItem := range toProcess {
-		fileName := strings.Replace(toProcessItem, pagesPath, "", 1)
-		fileName = prefixSlashPath.ReplaceAllString(fileName, "")
-		destFilePath := strings.Replace(toProcessItem, pagesPath, outPath, 1)
-		isHTML := strings.HasSuffix(fileName, ".html")
-
-		alvuFile := &AlvuFile{
-			lock:         &sync.Mutex{},
-			sourcePath:   toProcessItem,
-			hooks:        hookCollection,
-			destPath:     destFilePath,
-			name:         fileName,
-			isHTML:       isHTML,
-			headFile:     headFileFd,
-			tailFile:     tailFileFd,
-			baseTemplate: baseFileFd,
-			data:         map[string]interface{}{},
-			extras:       map[string]interface{}{},
-		}
-
-		alvuApp.AddFile(alvuFile)
-
-		// If serving, also add the nested path into it
-		if *serveFlag {
-			watcher.AddDir(path.Dir(alvuFile.sourcePath))
-		}
-	}
-
-	alvuApp.Build()
-
-	onDebug(func() {
-		runtime.GC()
-		debugInfo("On Completions")
-		memuse()
-	})
-
-	cs := &color.ColorString{}
-	fmt.Println(cs.Blue(logPrefix).Green("Compiled ").Cyan("\"" + basePath + "\"").Green(" to ").Cyan("\"" + outPath + "\"").String())
-
-	if *serveFlag {
-		watcher.StartWatching()
-		runServer(*portFlag)
-	}
-
-	hookCollection.Shutdown()
-}
-
-func runServer(port string) {
-	normalizedPort := port
-
-	if !strings.HasPrefix(normalizedPort, ":") {
-		normalizedPort = ":" + normalizedPort
-	}
-
-	cs := &color.ColorString{}
-	cs.Blue(logPrefix).Green("Serving on").Reset(" ").Cyan(normalizedPort)
-	fmt.Println(cs.String())
-
-	http.Handle("/", http.HandlerFunc(ServeHandler))
-	AddWebsocketHandler()
-
-	err := http.ListenAndServe(normalizedPort, nil)
-
-	if strings.Contains(err.Error(), "address already in use") {
-		bail(errors.New("port already in use, use another port with the `-port` flag instead"))
+		return err
 	}
-}
-
-func CollectFilesToProcess(basepath string) []string {
-	files := []string{}
-
-	pathstoprocess, err := os.ReadDir(basepath)
+	defaults, err := alvu.ConfigDefaults(siteConfig)
 	if err != nil {
-		panic(err)
-	}
-
-	for _, pathInfo := range pathstoprocess {
-		_path := path.Join(basepath, pathInfo.Name())
-
-		if Contains(layoutFiles, pathInfo.Name()) {
-			continue
-		}
-
-		if pathInfo.IsDir() {
-			files = append(files, CollectFilesToProcess(_path)...)
-		} else {
-			files = append(files, _path)
-		}
-
-	}
-
-	return files
-}
-
-func CollectHooks(basePath, hooksBasePath string) {
-	if _, err := os.Stat(hooksBasePath); err != nil {
-		return
+		return err
 	}
-	pathsToProcess, err := os.ReadDir(hooksBasePath)
+	taxonomies, err := alvu.ConfigTaxonomies(siteConfig)
 	if err != nil {
-		panic(err)
-	}
-
-	for _, pathInfo := range pathsToProcess {
-		if !strings.HasSuffix(pathInfo.Name(), ".lua") {
-			continue
-		}
-		hook := NewHook()
-		hookPath := path.Join(hooksBasePath, pathInfo.Name())
-		if err := hook.DoFile(hookPath); err != nil {
-			panic(err)
-		}
-		hookCollection = append(hookCollection, &Hook{
-			path:  hookPath,
-			state: hook,
-		})
-	}
-
-}
-
-func initMDProcessor(highlight bool, theme string) {
-
-	rendererOptions := []renderer.Option{
-		html.WithXHTML(),
-		html.WithUnsafe(),
-	}
-
-	if hardWraps {
-		rendererOptions = append(rendererOptions, html.WithHardWraps())
-	}
-	gmPlugins := []goldmark.Option{
-		goldmark.WithExtensions(extension.GFM, extension.Footnote),
-		goldmark.WithParserOptions(
-			parser.WithAutoHeadingID(),
-		),
-		goldmark.WithRendererOptions(
-			rendererOptions...,
-		),
-	}
-
-	if highlight {
-		gmPlugins = append(gmPlugins, goldmark.WithExtensions(
-			highlighting.NewHighlighting(
-				highlighting.WithStyle(theme),
-			),
-		))
-	}
-
-	mdProcessor = goldmark.New(gmPlugins...)
-}
-
-type Hook struct {
-	path  string
-	state *lua.LState
-}
-
-type HookCollection []*Hook
-
-func (hc HookCollection) Shutdown() {
-	for _, hook := range hc {
-		hook.state.Close()
-	}
-}
-
-func (hc HookCollection) RunAll(funcName string) {
-	for _, hook := range hc {
-		hookFunc := hook.state.GetGlobal(funcName)
-
-		if hookFunc == lua.LNil {
-			continue
-		}
-
-		if err := hook.state.CallByParam(lua.P{
-			Fn:      hookFunc,
-			NRet:    0,
-			Protect: true,
-		}); err != nil {
-			bail(err)
-		}
-	}
-}
-
-type AlvuFile struct {
-	lock             *sync.Mutex
-	hooks            HookCollection
-	name             string
-	sourcePath       string
-	isHTML           bool
-	destPath         string
-	meta             map[string]interface{}
-	content          []byte
-	writeableContent []byte
-	headFile         *os.File
-	tailFile         *os.File
-	baseTemplate     *os.File
-	targetName       []byte
-	data             map[string]interface{}
-	extras           map[string]interface{}
-}
-
-func (alvuFile *AlvuFile) Build() {
-	bail(alvuFile.ReadFile())
-	bail(alvuFile.ParseMeta())
-
-	if len(alvuFile.hooks) == 0 {
-		alvuFile.ProcessFile(nil)
-	}
-
-	for _, hook := range hookCollection {
-
-		isForSpecificFile := hook.state.GetGlobal("ForFile")
-
-		if isForSpecificFile != lua.LNil {
-			if alvuFile.name == isForSpecificFile.String() {
-				alvuFile.ProcessFile(hook.state)
-			} else {
-				bail(alvuFile.ProcessFile(nil))
-			}
-		} else {
-			bail(alvuFile.ProcessFile(hook.state))
-		}
+		return err
 	}
-
-	alvuFile.FlushFile()
-}
-
-func (af *AlvuFile) ReadFile() error {
-	filecontent, err := os.ReadFile(af.sourcePath)
+	footnotes, err := alvu.ConfigFootnotes(siteConfig)
 	if err != nil {
-		return fmt.Errorf("error reading file, error: %v", err)
-	}
-	af.content = filecontent
-	return nil
-}
-
-func (af *AlvuFile) ParseMeta() error {
-	sep := []byte("---")
-	if !bytes.HasPrefix(af.content, sep) {
-		af.writeableContent = af.content
-		return nil
+		return err
 	}
-
-	metaParts := bytes.SplitN(af.content, sep, 3)
-
-	var meta map[string]interface{}
-	err := yaml.Unmarshal([]byte(metaParts[1]), &meta)
+	sanitizePolicy, err := alvu.ConfigSanitizePolicy(siteConfig)
 	if err != nil {
 		return err
 	}
 
-	af.meta = meta
-	af.writeableContent = []byte(metaParts[2])
-
-	return nil
-}
-
-func (af *AlvuFile) ProcessFile(hook *lua.LState) error {
-	// pre process hook => should return back json with `content` and `data`
-	af.lock.Lock()
-	defer af.lock.Unlock()
-
-	af.targetName = regexp.MustCompile(`\.md$`).ReplaceAll([]byte(af.name), []byte(".html"))
-	onDebug(func() {
-		debugInfo(af.name + " will be changed to " + string(af.targetName))
-	})
-
-	buf := bytes.NewBuffer([]byte(""))
-	mdToHTML := ""
-
-	if filepath.Ext(af.name) == ".md" {
-		newName := strings.Replace(af.name, filepath.Ext(af.name), ".html", 1)
-		af.targetName = []byte(newName)
-		mdProcessor.Convert(af.writeableContent, buf)
-		mdToHTML = buf.String()
-	}
-
-	if hook == nil {
-		return nil
-	}
-
-	hookInput := struct {
-		Name             string                 `json:"name"`
-		SourcePath       string                 `json:"source_path"`
-		DestPath         string                 `json:"dest_path"`
-		Meta             map[string]interface{} `json:"meta"`
-		WriteableContent string                 `json:"content"`
-		HTMLContent      string                 `json:"html"`
-	}{
-		Name:             string(af.targetName),
-		SourcePath:       af.sourcePath,
-		DestPath:         af.destPath,
-		Meta:             af.meta,
-		WriteableContent: string(af.writeableContent),
-		HTMLContent:      mdToHTML,
-	}
-
-	hookJsonInput, err := json.Marshal(hookInput)
-	bail(err)
-
-	if err := hook.CallByParam(lua.P{
-		Fn:      hook.GetGlobal("Writer"),
-		NRet:    1,
-		Protect: true,
-	}, lua.LString(hookJsonInput)); err != nil {
-		panic(err)
-	}
-
-	ret := hook.Get(-1)
-
-	var fromPlug map[string]interface{}
-
-	err = json.Unmarshal([]byte(ret.String()), &fromPlug)
-	bail(err)
-
-	if fromPlug["content"] != nil {
-		stringVal := fmt.Sprintf("%s", fromPlug["content"])
-		af.writeableContent = []byte(stringVal)
-	}
-
-	if fromPlug["name"] != nil {
-		af.targetName = []byte(fmt.Sprintf("%v", fromPlug["name"]))
-	}
-
-	if fromPlug["data"] != nil {
-		af.data = mergeMapWithCheck(af.data, fromPlug["data"])
-	}
-
-	if fromPlug["extras"] != nil {
-		af.extras = mergeMapWithCheck(af.extras, fromPlug["extras"])
-	}
-
-	hook.Pop(1)
+	cfg := alvu.Config{
+		BasePath:             *basePathFlag,
+		OutPath:              *outPathFlag,
+		BaseURL:              *baseurlFlag,
+		HooksPath:            *hooksPathFlag,
+		Highlight:            *enableHighlightingFlag,
+		HighlightTheme:       *highlightThemeFlag,
+		HighlightLineNumbers: *highlightLineNumbersFlag,
+		HighlightCSS:         *highlightCSSFlag,
+		HardWraps:            *hardWrapsFlag,
+		MarkdownDisable:      mdDisableFlag,
+		NoH1Title:            !*h1TitleFlag,
+		WarnDuplicateIDs:     *warnDuplicateIDsFlag,
+		DefinitionLists:      *definitionListsFlag,
+		Typographer:          *typographerFlag,
+		TypographerDisable:   typographerDisableFlag,
+		Math:                 *mathFlag,
+		MathScript:           *mathScriptFlag,
+		Mermaid:              *mermaidFlag,
+		MermaidScript:        *mermaidScriptFlag,
+		SafeMarkdown:         *safeMarkdownFlag,
+		SanitizeMarkdown:     *sanitizeMarkdownFlag,
+		Sanitize:             *sanitizeFlag,
+		Emoji:                *emojiFlag,
+		EmojiMode:            *emojiModeFlag,
+		RootRelativeLinks:    *rootRelativeLinksFlag,
+		Sitemap:              *sitemapFlag,
+		FeedFormat:           *feedFormatFlag,
+		FeedTitle:            *feedTitleFlag,
+		SearchIndex:          *searchIndexFlag,
+		SingleFile:           *singleFileFlag,
+		SingleFileManifest:   *singleFileManifestFlag,
+		PDF:                  *pdfFlag,
+		PDFCommand:           *pdfCommandFlag,
+		Drafts:               *draftsFlag,
+		Future:               *futureFlag,
+		Clean:                *cleanFlag,
+		CleanDryRun:          *cleanDryRunFlag,
+		KeepGoing:            !*failFastFlag,
+		Strict:               *strictFlag,
+		StrictMarkdown:       *strictMarkdownFlag,
+		CheckExternal:        *checkExternalFlag,
+		DryRun:               *dryRunFlag,
+		PrettyURLs:           *prettyURLsFlag,
+		Minify:               *minifyFlag,
+		Fingerprint:          *fingerprintFlag,
+		TOCMinLevel:          *tocMinLevelFlag,
+		WordsPerMinute:       *wordsPerMinuteFlag,
+		NavSort:              *navSortFlag,
+		NavScope:             *navScopeFlag,
+		Jobs:                 *jobsFlag,
+		Exclude:              excludeFlag,
+		TemplateExtensions:   splitList(*templateExtsFlag),
+		CSVDelimiter:         *csvDelimiterFlag,
+		Collections:          collections,
+		Defaults:             defaults,
+		Taxonomies:           taxonomies,
+		Footnotes:            footnotes,
+		SanitizePolicy:       sanitizePolicy,
+		Serve:                *serveFlag,
+		Port:                 *portFlag,
+		Poll:                 *pollDurationFlag,
+		Watch:                *watchFlag,
+		ReloadPort:           *reloadPortFlag,
+		TLS:                  *tlsFlag,
+		TLSCert:              *certFlag,
+		TLSKey:               *keyFlag,
+		Open:                 *openFlag,
+		NoCompress:           *noCompressFlag,
+		FollowSymlinks:       *followSymlinksFlag,
+		NoCache:              *noCacheFlag,
+		Layout:               *layoutFlag,
+		NoLegacyHeadTail:     *noLegacyHeadTailFlag,
+		Report:               *reportFlag,
+		Timing:               *timingFlag,
+	}
+
+	if *stdinFlag {
+		return alvu.Convert(cfg, os.Stdin, os.Stdout)
+	}
+
+	return alvu.Build(cfg)
+}
+
+// stringListFlag collects the values of
+// a flag that can be passed more than once
+type stringListFlag []string
+
+func (sl *stringListFlag) String() string {
+	return strings.Join(*sl, ",")
+}
+
+func (sl *stringListFlag) Set(value string) error {
+	*sl = append(*sl, value)
 	return nil
 }
 
-func (af *AlvuFile) FlushFile() {
-	destFolder := filepath.Dir(af.destPath)
-	os.MkdirAll(destFolder, os.ModePerm)
-
-	targetFile := strings.Replace(path.Join(af.destPath), af.name, string(af.targetName), 1)
-	onDebug(func() {
-		debugInfo("flushing for file: " + af.name + string(af.targetName))
-		debugInfo("flusing file: " + targetFile)
-	})
-
-	f, err := os.Create(targetFile)
-	bail(err)
-	defer f.Sync()
-
-	writeHeadTail := false
-
-	if af.baseTemplate == nil && (filepath.Ext(af.sourcePath) == ".md" || filepath.Ext(af.sourcePath) == "html") {
-		writeHeadTail = true
-	}
-
-	if writeHeadTail && af.headFile != nil {
-		shouldCopyContentsWithReset(af.headFile, f)
-	}
-
-	renderData := PageRenderData{
-		Meta: SiteMeta{
-			BaseURL: baseurl,
-		},
-		Data:   af.data,
-		Extras: af.extras,
-	}
-
-	// Run the Markdown file through the conversion
-	// process to be able to use template variables in
-	// the markdown instead of writing them in
-	// raw HTML
-	var preConvertHTML bytes.Buffer
-	preConvertTmpl := textTmpl.New("temporary_pre_template")
-	preConvertTmpl.Parse(string(af.writeableContent))
-	err = preConvertTmpl.Execute(&preConvertHTML, renderData)
-	bail(err)
-
-	var toHtml bytes.Buffer
-	if !af.isHTML {
-		err = mdProcessor.Convert(preConvertHTML.Bytes(), &toHtml)
-		bail(err)
-	} else {
-		toHtml = preConvertHTML
-	}
-
-	layoutData := LayoutRenderData{
-		PageRenderData: renderData,
-		Content:        template.HTML(toHtml.Bytes()),
-	}
-
-	// If a layout file was found
-	// write the converted html content into the
-	// layout template file
-
-	layout := template.New("layout")
-	var layoutTemplateData string
-	if af.baseTemplate != nil {
-		layoutTemplateData = string(readFileToBytes(af.baseTemplate))
-	} else {
-		layoutTemplateData = `<body>{{.Content}}</body>`
-	}
-
-	layoutTemplateData = _injectLiveReload(&layoutTemplateData)
-	toHtml.Reset()
-	layout.Parse(layoutTemplateData)
-	layout.Execute(&toHtml, layoutData)
-
-	io.Copy(
-		f, &toHtml,
-	)
-
-	if writeHeadTail && af.tailFile != nil && af.baseTemplate == nil {
-		shouldCopyContentsWithReset(af.tailFile, f)
-	}
-
-	data, err := os.ReadFile(targetFile)
-	bail(err)
-
-	onDebug(func() {
-		debugInfo("template path: %v", af.sourcePath)
-	})
-
-	t := template.New(path.Join(af.sourcePath))
-	t.Parse(string(data))
-
-	f.Seek(0, 0)
-
-	err = t.Execute(f, renderData)
-	bail(err)
-}
-
-func NewHook() *lua.LState {
-	lState := lua.NewState()
-	luaAlvu.Preload(lState)
-	luajson.Preload(lState)
-	yamlLib.Preload(lState)
-	stringsLib.Preload(lState)
-	lState.PreloadModule("http", ghttp.NewHttpModule(&http.Client{}).Loader)
-	if basePath == "." {
-		lState.SetGlobal("workingdir", lua.LString(""))
-	} else {
-		lState.SetGlobal("workingdir", lua.LString(basePath))
+// splitList splits a comma separated flag
+// value and drops the empty items
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
 	}
-	return lState
-}
-
-// UTILS
-func memuse() {
-	var m runtime.MemStats
-	runtime.ReadMemStats(&m)
-	fmt.Printf("heap: %v MiB\n", bytesToMB(m.HeapAlloc))
-}
-
-func bytesToMB(inBytes uint64) uint64 {
-	return inBytes / 1024 / 1024
+	return items
 }
 
 func bail(err error) {
-	if err == nil {
-		return
-	}
 	cs := &color.ColorString{}
 	fmt.Fprintln(os.Stderr, cs.Red(logPrefix).Red(": "+err.Error()).String())
-	panic("")
-}
-
-func debugInfo(msg string, a ...any) {
-	cs := &color.ColorString{}
-	prefix := logPrefix
-	baseMessage := cs.Reset("").Yellow(prefix).Reset(" ").Gray(msg).String()
-	fmt.Fprintf(os.Stdout, baseMessage+" \n", a...)
-}
-
-func showDebug() bool {
-	showInfo := env.Get("DEBUG_ALVU", "")
-	return len(showInfo) != 0
-}
-
-func onDebug(fn func()) {
-	if !showDebug() {
-		return
-	}
-
-	fn()
-}
-
-func mergeMapWithCheck(maps ...any) (source map[string]interface{}) {
-	source = map[string]interface{}{}
-	for _, toCheck := range maps {
-		if pairs, ok := toCheck.(map[string]interface{}); ok {
-			for k, v := range pairs {
-				source[k] = v
-			}
-		}
-	}
-	return source
-}
-
-func readFileToBytes(fd *os.File) []byte {
-	buf := &bytes.Buffer{}
-	fd.Seek(0, 0)
-	_, err := io.Copy(buf, fd)
-	bail(err)
-	return buf.Bytes()
-}
-
-func shouldCopyContentsWithReset(src *os.File, target *os.File) {
-	src.Seek(0, 0)
-	_, err := io.Copy(target, src)
-	bail(err)
-}
-
-func ServeHandler(rw http.ResponseWriter, req *http.Request) {
-	path := req.URL.Path
-
-	if path == "/" {
-		path = filepath.Join(outPath, "index.html")
-		http.ServeFile(rw, req, path)
-		return
-	}
-
-	// check if the requested file already exists
-	file := filepath.Join(outPath, path)
-	info, err := os.Stat(file)
-
-	// if not, check if it's a directory
-	// and if it's a directory, we look for
-	// a index.html inside the directory to return instead
-	if err == nil {
-		if info.Mode().IsDir() {
-			file = filepath.Join(outPath, path, "index.html")
-			_, err := os.Stat(file)
-			if err != nil {
-				notFoundHandler(rw, req)
-				return
-			}
-		}
-
-		http.ServeFile(rw, req, file)
-		return
-	}
-
-	// if neither a directory or file was found
-	// try a secondary case where the file might be missing
-	// a `.html` extension for cleaner url so append a .html
-	// to look for the file.
-	if err != nil {
-		file := filepath.Join(outPath, normalizeFilePath(path))
-		_, err := os.Stat(file)
-
-		if err != nil {
-			notFoundHandler(rw, req)
-			return
-		}
-
-		http.ServeFile(rw, req, file)
-		return
-	}
-
-	notFoundHandler(rw, req)
-}
-
-// _webSocketHandler Internal function to setup a listener loop
-// for the live reload setup
-func _webSocketHandler(ws *websocket.Conn) {
-	reloadCh = append(reloadCh, make(chan bool, 1))
-	currIndex := len(reloadCh) - 1
-
-	defer ws.Close()
-
-	for range reloadCh[currIndex] {
-		err := websocket.Message.Send(ws, "reload")
-		if err != nil {
-			// For debug only
-			// log.Printf("Error sending message: %s", err.Error())
-			break
-		}
-		onDebug(func() {
-			debugInfo("Reload message sent")
-		})
-	}
-
-}
-
-func AddWebsocketHandler() {
-	wsHandler := websocket.Handler(_webSocketHandler)
-
-	// Use a custom HTTP handler function to upgrade the HTTP request to WebSocket
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		// Check the request's 'Upgrade' header to see if it's a WebSocket request
-		if r.Header.Get("Upgrade") != "websocket" {
-			http.Error(w, "Not a WebSocket handshake request", http.StatusBadRequest)
-			return
-		}
-
-		// Upgrade the HTTP connection to a WebSocket connection
-		wsHandler.ServeHTTP(w, r)
-	})
-
-}
-
-// _clientNotifyReload Internal function to
-// report changes to all possible reload channels
-func _clientNotifyReload() {
-	for ind := range reloadCh {
-		reloadCh[ind] <- true
-	}
-	reloadCh = []chan bool{}
-}
-
-func normalizeFilePath(path string) string {
-	if strings.HasSuffix(path, ".html") {
-		return path
-	}
-	return path + ".html"
-}
-
-func notFoundHandler(w http.ResponseWriter, r *http.Request) {
-	if notFoundPageExists {
-		compiledNotFoundFile := filepath.Join(outPath, "404.html")
-		notFoundFile, err := os.ReadFile(compiledNotFoundFile)
-		if err != nil {
-			http.Error(w, "404, Page not found....", http.StatusNotFound)
-			return
-		}
-		w.WriteHeader(http.StatusNotFound)
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		w.Write(notFoundFile)
-		return
-	}
-	http.Error(w, "404, Page not found....", http.StatusNotFound)
-}
-
-func Contains(collection []string, item string) bool {
-	for _, x := range collection {
-		if item == x {
-			return true
-		}
-	}
-	return false
-}
-
-// Watcher , create an interface over the fsnotify watcher
-// to be able to run alvu compile processes again
-// FIXME: redundant compile process for the files
-type Watcher struct {
-	alvu   *Alvu
-	poller *poller.Poller
-	dirs   []string
-}
-
-func NewWatcher(alvu *Alvu, interval int) *Watcher {
-	watcher := &Watcher{
-		alvu:   alvu,
-		poller: poller.NewPollWatcher(interval),
-	}
-
-	return watcher
-}
-
-func (w *Watcher) AddDir(dirPath string) {
-
-	for _, pth := range w.dirs {
-		if pth == dirPath {
-			return
-		}
-	}
-
-	w.dirs = append(w.dirs, dirPath)
-	w.poller.Add(dirPath)
-}
-
-func (w *Watcher) RebuildAlvu() {
-	onDebug(func() {
-		debugInfo("Rebuild Started")
-	})
-	w.alvu.CopyPublic()
-	w.alvu.Build()
-	onDebug(func() {
-		debugInfo("Build Completed")
-	})
-}
-
-func (w *Watcher) RebuildFile(filePath string) {
-	onDebug(func() {
-		debugInfo("RebuildFile Started")
-	})
-	for i, af := range w.alvu.files {
-		if af.sourcePath != filePath {
-			continue
-		}
-
-		w.alvu.files[i].Build()
-		break
-	}
-	onDebug(func() {
-		debugInfo("RebuildFile Completed")
-	})
-}
-
-func (w *Watcher) StartWatching() {
-	go w.poller.StartPoller()
-	go func() {
-		for {
-			select {
-			case evt := <-w.poller.Events:
-				onDebug(func() {
-					debugInfo("Events registered")
-				})
-
-				recompiledText := &color.ColorString{}
-				recompiledText.Blue(logPrefix).Green("Recompiled!").Reset(" ")
-
-				_, err := os.Stat(evt.Path)
-
-				// Do nothing if the file doesn't exit, just continue
-				if err != nil {
-					continue
-				}
-
-				// If alvu file then just build the file, else
-				// just rebuilt the whole folder since it could
-				// be a file from the public folder or the _layout file
-				if w.alvu.IsAlvuFile(evt.Path) {
-					recompilingText := &color.ColorString{}
-					recompilingText.Blue(logPrefix).Cyan("Recompiling: ").Gray(evt.Path).Reset(" ")
-					fmt.Println(recompilingText.String())
-					w.RebuildFile(evt.Path)
-				} else {
-					recompilingText := &color.ColorString{}
-					recompilingText.Blue(logPrefix).Cyan("Recompiling: ").Gray("All").Reset(" ")
-					fmt.Println(recompilingText.String())
-					w.RebuildAlvu()
-				}
-
-				_clientNotifyReload()
-				fmt.Println(recompiledText.String())
-				continue
-
-			case err := <-w.poller.Errors:
-				// If the poller has an error, just crash,
-				// digesting polling issues without killing the program would make it complicated
-				// to handle cleanup of all the kind of files that are being maintained by alvu
-				bail(err)
-			}
-		}
-	}()
-}
-
-func _injectLiveReload(layoutHTML *string) string {
-	if !*serveFlag {
-		return *layoutHTML
-	}
-	return *layoutHTML + `<script>
-				  const socket = new WebSocket("ws://localhost:3000/ws");
-
-				  // Connection opened
-				  socket.addEventListener("open", (event) => {
-					socket.send("Hello Server!");
-				  });
-
-				  // Listen for messages
-				  socket.addEventListener("message", (event) => {
-					if (event.data == "reload") {
-					  socket.close();
-					  window.location.reload();
-					}
-				  });
-			</script>`
+	os.Exit(1)
 }
@@ -0,0 +1,1074 @@
+package alvu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	luaAlvu "github.com/barelyhuman/alvu/lua/alvu"
+	"github.com/barelyhuman/go/color"
+	"github.com/barelyhuman/go/env"
+	"github.com/yuin/goldmark"
+)
+
+const logPrefix = "[alvu] "
+
+var reservedFiles []string = []string{"_head.html", "_tail.html", "_layout.html", layoutsDir, "_data.yaml", "_data.yml", "_data.json"}
+
+// layoutsDir is the directory inside pages that holds the
+// named layouts that can be picked with `layout` in the meta
+const layoutsDir = "_layouts"
+
+// shortcodesDir is the directory next to pages that
+// holds the templates of the shortcodes
+const shortcodesDir = "shortcodes"
+
+// partialsDir is the directory next to pages that holds
+// the templates that can be included in other templates
+const partialsDir = "partials"
+
+type SiteMeta struct {
+	BaseURL string
+}
+
+type PageRenderData struct {
+	Meta SiteMeta
+	// Page is the front matter of the page
+	// being rendered
+	Page   map[string]interface{}
+	Data   map[string]interface{}
+	Extras map[string]interface{}
+	Pages  []Page
+}
+
+type LayoutRenderData struct {
+	PageRenderData
+	Content template.HTML
+}
+
+// Alvu holds the state of a single build so
+// multiple sites can be built in the same process
+type Alvu struct {
+	config     Config
+	basePath   string
+	outPath    string
+	publicPath string
+	hooksPath  string
+	pagesPath  string
+	dataPath   string
+	jobs       int
+	files      []*AlvuFile
+	filesIndex []string
+	// generated are the pages that don't have a source
+	// file, like the taxonomy terms, they're created again
+	// every time the pages are collected
+	generated     []*AlvuFile
+	baseTemplate  *templateFile
+	pageTemplates *templateFiles
+	// schema is the front matter schema, nil
+	// when the site doesn't have one
+	schema *MetaSchema
+
+	mdProcessor   goldmark.Markdown
+	hooks         HookCollection
+	pages         []Page
+	nav           map[string]navLinks
+	siteData      map[string]interface{}
+	namedLayouts  *NamedLayouts
+	sitemap       *Sitemap
+	feed          *Feed
+	searchIndex   *SearchIndex
+	singleFile    *SingleFile
+	assetManifest *AssetManifest
+	layouts       *LayoutCache
+	shortcodes    *Shortcodes
+	partials      *Partials
+	cache         *BuildCache
+	timings       *Timings
+	// hookFuncs are the `TemplateFuncs` of the hooks and
+	// templateHooks the lua states they're called in, see
+	// loadHookTemplateFuncs
+	hookFuncs     map[string]interface{}
+	templateHooks HookCollection
+
+	// failed are the errors of the files that failed
+	// when the build is set to keep going
+	failed     []error
+	failedLock *sync.Mutex
+
+	// outputs are the paths written during the build
+	// and the source files that wrote them
+	outputs     map[string]string
+	outputsLock *sync.Mutex
+
+	// dev server
+	liveReload bool
+	reloadLock *sync.Mutex
+	reloadCh   []chan bool
+}
+
+// claimOutput marks the target as written by the source
+// file, fails if some other file has already written to it
+func (al *Alvu) claimOutput(target string, sourcePath string) error {
+	al.outputsLock.Lock()
+	defer al.outputsLock.Unlock()
+
+	if writtenBy, ok := al.outputs[target]; ok && writtenBy != sourcePath {
+		return fmt.Errorf("both %v and %v write to %v", writtenBy, sourcePath, target)
+	}
+	al.outputs[target] = sourcePath
+	return nil
+}
+
+// claimAsset is claimOutput for the assets copied next to
+// the pages, returns false if the asset is already there
+// since more than one page can reference it
+func (al *Alvu) claimAsset(target string, sourcePath string) (bool, error) {
+	al.outputsLock.Lock()
+	defer al.outputsLock.Unlock()
+
+	if writtenBy, ok := al.outputs[target]; ok {
+		if writtenBy != sourcePath {
+			return false, fmt.Errorf("both %v and %v write to %v", writtenBy, sourcePath, target)
+		}
+		return false, nil
+	}
+	al.outputs[target] = sourcePath
+	return true, nil
+}
+
+// filesToBuild are the files from pages along with
+// the generated ones
+func (al *Alvu) filesToBuild() []*AlvuFile {
+	files := make([]*AlvuFile, 0, len(al.files)+len(al.generated))
+	files = append(files, al.files...)
+	return append(files, al.generated...)
+}
+
+func (al *Alvu) AddFile(file *AlvuFile) {
+	al.files = append(al.files, file)
+	al.filesIndex = append(al.filesIndex, file.sourcePath)
+}
+
+func (al *Alvu) IsAlvuFile(filePath string) bool {
+	for _, af := range al.filesIndex {
+		if af == filePath {
+			return true
+		}
+	}
+	return false
+}
+
+// Build runs the build in two phases, all the files are
+// collected first so that every page knows about the
+// others when they are rendered
+func (al *Alvu) Build() error {
+	started := time.Now()
+	if err := al.Collect(); err != nil {
+		return err
+	}
+	al.timings.Phase("load files", started)
+	return al.Render()
+}
+
+// Collect loads every file and builds the index of pages
+func (al *Alvu) Collect() error {
+	al.failedLock.Lock()
+	al.failed = nil
+	al.failedLock.Unlock()
+
+	for _, alvuFile := range al.files {
+		if err := alvuFile.Load(); err != nil {
+			if err := al.fileFailed(err); err != nil {
+				return err
+			}
+			alvuFile.skip, alvuFile.skipReason = true, "failed to load"
+		}
+	}
+	al.CollectPages()
+	return nil
+}
+
+// fileFailed returns the error as is unless the build
+// should keep going, in which case it's logged and
+// counted for the summary at the end of the build
+func (al *Alvu) fileFailed(err error) error {
+	if !al.config.KeepGoing {
+		return err
+	}
+	logError(err)
+	al.failedLock.Lock()
+	al.failed = append(al.failed, err)
+	al.failedLock.Unlock()
+	return nil
+}
+
+// fileJobs is the number of workers to build the files with,
+// the copies of the hooks the workers other than the first one
+// get don't run `OnStart` so the hooks that have it only get
+// the one worker unless the jobs were set
+func (al *Alvu) fileJobs(files int) int {
+	jobs := al.jobs
+	if al.config.Jobs == 0 && al.hooks.Defines("OnStart") {
+		jobs = 1
+	}
+	if jobs > files {
+		jobs = files
+	}
+	if jobs < 1 {
+		jobs = 1
+	}
+	return jobs
+}
+
+// Render runs the hooks and writes the collected files
+func (al *Alvu) Render() error {
+	started := time.Now()
+	al.outputsLock.Lock()
+	al.outputs = map[string]string{}
+	al.outputsLock.Unlock()
+
+	// the hooks might've changed since the last build as well
+	if err := al.loadHookTemplateFuncs(); err != nil {
+		return err
+	}
+
+	// the layouts might've changed since the last build
+	if err := al.loadTemplates(); err != nil {
+		return err
+	}
+	al.timings.Phase("templates", started)
+
+	files := al.filesToBuild()
+	jobs := al.fileJobs(len(files))
+
+	// lua states aren't safe to be shared across goroutines so
+	// every worker other than the first one gets its own copy
+	// of the hooks
+	workerHooks := []HookCollection{al.hooks}
+	for i := 1; i < jobs; i++ {
+		hooks, err := al.hooks.Clone(al.basePath)
+		if err != nil {
+			return err
+		}
+		defer hooks.Shutdown()
+		workerHooks = append(workerHooks, hooks)
+	}
+
+	for _, hooks := range workerHooks {
+		if err := al.setHookPages(hooks); err != nil {
+			return err
+		}
+	}
+
+	// the single file needs the content of every page
+	// so none of them can come from the cache
+	al.cache = nil
+	if !al.config.NoCache && len(al.config.MarkdownExtensions) == 0 && al.singleFile == nil {
+		cacheStarted := time.Now()
+		globals, err := al.cacheGlobals()
+		if err != nil {
+			return err
+		}
+		al.cache = LoadBuildCache(path.Join(al.basePath, buildCacheFile), globals)
+		al.timings.Phase("load cache", cacheStarted)
+	}
+
+	var report *BuildReport
+	if len(al.config.Report) > 0 {
+		report = NewBuildReport()
+	}
+
+	// the first error stops the rest of the queue
+	// from being built
+	var buildErr error
+	errLock := &sync.Mutex{}
+
+	queue := make(chan *AlvuFile)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func(hooks HookCollection) {
+			defer wg.Done()
+			for alvuFile := range queue {
+				errLock.Lock()
+				failed := buildErr != nil
+				errLock.Unlock()
+				if failed {
+					continue
+				}
+
+				alvuFile.hooks = hooks
+				fileStarted := time.Now()
+				cached, err := al.buildCached(alvuFile)
+				if report != nil {
+					report.Add(alvuFile, cached, err, time.Since(fileStarted))
+				}
+				al.timings.File(alvuFile.sourcePath, time.Since(fileStarted))
+				if err != nil {
+					if err = al.fileFailed(err); err == nil {
+						continue
+					}
+					errLock.Lock()
+					if buildErr == nil {
+						buildErr = err
+					}
+					errLock.Unlock()
+				}
+			}
+		}(workerHooks[i])
+	}
+
+	processStarted := time.Now()
+	for _, alvuFile := range files {
+		queue <- alvuFile
+	}
+	close(queue)
+	wg.Wait()
+	al.timings.Phase("process files", processStarted)
+
+	// the report is written for failed builds as well
+	// since that's when it's needed the most
+	if report != nil {
+		if err := report.Write(al.config.Report, files, time.Since(started)); err != nil {
+			return err
+		}
+	}
+
+	if buildErr != nil {
+		return buildErr
+	}
+
+	writeStarted := time.Now()
+	if err := al.writeIndexes(); err != nil {
+		return err
+	}
+
+	if al.singleFile != nil {
+		singleFilePath, err := al.writeSingleFile()
+		if err != nil {
+			return err
+		}
+		if len(al.config.PDF) > 0 {
+			if err := al.writePDF(singleFilePath); err != nil {
+				return err
+			}
+		}
+		for _, hook := range al.hooks {
+			luaAlvu.SetSingleFile(hook.state, singleFilePath)
+		}
+	}
+
+	if al.cache != nil {
+		if err := al.cache.Save(); err != nil {
+			return err
+		}
+	}
+	al.timings.Phase("sitemap, feed, single file and cache", writeStarted)
+
+	onDebug(func() {
+		debugInfo("Run all OnFinish Hooks")
+		memuse()
+	})
+
+	// right before completion run all hooks again but for the onFinish
+	finishStarted := time.Now()
+	if err := al.hooks.RunAll("OnFinish"); err != nil {
+		return err
+	}
+	al.timings.Phase("OnFinish hooks", finishStarted)
+
+	if len(al.failed) > 0 {
+		return fmt.Errorf("%v of %v files failed to build", len(al.failed), len(files))
+	}
+
+	if al.config.StrictMarkdown || al.config.CheckExternal {
+		linksStarted := time.Now()
+		if err := al.checkLinks(); err != nil {
+			return err
+		}
+		al.timings.Phase("check links", linksStarted)
+	}
+	return nil
+}
+
+// writeIndexes writes the sitemap, feed and search
+// index with the pages that have been built
+func (al *Alvu) writeIndexes() error {
+	if al.sitemap != nil {
+		if err := al.sitemap.Write(al.outPath); err != nil {
+			return err
+		}
+	}
+
+	if al.feed != nil {
+		if err := al.feed.Write(al.outPath); err != nil {
+			return err
+		}
+	}
+
+	if al.searchIndex != nil {
+		if err := al.searchIndex.Write(al.outPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DryRun loads the files and writes a table of where each of
+// them would be written to, returns an error if more than
+// one file would be written to the same path
+func (al *Alvu) DryRun(w io.Writer) error {
+	if err := al.Collect(); err != nil {
+		return err
+	}
+
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "SOURCE\tDESTINATION\tNOTE")
+
+	writtenBy := map[string]string{}
+	collisions := []string{}
+	for _, af := range al.filesToBuild() {
+		if af.skip {
+			fmt.Fprintf(table, "%v\t-\tskipped, %v\n", af.sourcePath, af.skipReason)
+			continue
+		}
+
+		target := af.destPath
+		note := ""
+		if af.passthrough {
+			note = "copied as is"
+		} else {
+			target = af.targetFile(af.defaultTargetName())
+			if len(af.permalink) > 0 {
+				note = "permalink"
+			}
+		}
+
+		if source, ok := writtenBy[target]; ok {
+			collisions = append(collisions, fmt.Sprintf("%v and %v both write to %v", source, af.sourcePath, target))
+			note = "collision"
+		}
+		writtenBy[target] = af.sourcePath
+
+		fmt.Fprintf(table, "%v\t%v\t%v\n", af.sourcePath, target, note)
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	if len(collisions) > 0 {
+		return errors.New(strings.Join(collisions, ", "))
+	}
+	return nil
+}
+
+// Build compiles the site with the given config
+func Build(cfg Config) error {
+	onDebug(func() {
+		debugInfo("Before Exec")
+		memuse()
+	})
+
+	cfg = cfg.withDefaults()
+
+	alvuApp, err := newAlvu(cfg)
+	if err != nil {
+		return err
+	}
+	// hooks can be reloaded by the watcher so shutdown
+	// whatever the collection is by the end
+	defer func() {
+		alvuApp.hooks.Shutdown()
+		alvuApp.templateHooks.Shutdown()
+	}()
+
+	if cfg.Clean || cfg.CleanDryRun {
+		started := time.Now()
+		if err := CleanOutPath(alvuApp.outPath, alvuApp.basePath, cfg.CleanDryRun); err != nil {
+			return err
+		}
+		if cfg.CleanDryRun {
+			return nil
+		}
+		alvuApp.timings.Phase("clean", started)
+	}
+
+	if err := alvuApp.prepare(); err != nil {
+		return err
+	}
+
+	if cfg.DryRun {
+		return alvuApp.DryRun(os.Stdout)
+	}
+
+	if err := alvuApp.Build(); err != nil {
+		return err
+	}
+
+	onDebug(func() {
+		runtime.GC()
+		debugInfo("On Completions")
+		memuse()
+	})
+
+	alvuApp.timings.Print(os.Stdout)
+
+	cs := &color.ColorString{}
+	fmt.Println(cs.Blue(logPrefix).Green("Compiled ").Cyan("\"" + alvuApp.basePath + "\"").Green(" to ").Cyan("\"" + alvuApp.outPath + "\"").String())
+
+	if !cfg.Serve {
+		return nil
+	}
+
+	// ctrl-c stops the server and the watcher, a second
+	// one exits right away if stopping takes too long
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	watching := alvuApp.liveReload
+	watcher := NewWatcher(alvuApp, cfg.Poll)
+	if watching {
+		watcher.AddDir(alvuApp.pagesPath)
+		watcher.AddDir(alvuApp.publicPath)
+		watcher.AddDir(alvuApp.hooksPath)
+		watcher.AddDir(alvuApp.dataPath)
+		watcher.AddDir(path.Join(alvuApp.basePath, shortcodesDir))
+		watcher.AddDir(path.Join(alvuApp.basePath, partialsDir))
+		if err := watcher.StartWatching(ctx); err != nil {
+			return err
+		}
+	}
+
+	err = alvuApp.runServer(ctx, cfg.Port)
+	// the server can fail on its own, like when the port is
+	// taken, so the watcher is stopped as well. The hooks are
+	// shutdown once this returns so let a rebuild that's running
+	// finish
+	stop()
+	if watching {
+		watcher.Wait()
+	}
+	return err
+}
+
+// newAlvu checks the config and reads the layouts of the site,
+// the rest is loaded by prepare once the output is cleaned
+func newAlvu(cfg Config) (*Alvu, error) {
+	basePath := path.Join(cfg.BasePath)
+	pagesPath := path.Join(cfg.BasePath, "pages")
+	publicPath := path.Join(cfg.BasePath, "public")
+	headFilePath := path.Join(pagesPath, "_head.html")
+	baseFilePath := path.Join(pagesPath, "_layout.html")
+	tailFilePath := path.Join(pagesPath, "_tail.html")
+	notFoundFilePath := path.Join(pagesPath, "404.html")
+	layoutsPath := path.Join(pagesPath, layoutsDir)
+	dataPath := path.Join(cfg.BasePath, "data")
+	outPath := path.Join(cfg.OutPath)
+	hooksPath := path.Join(cfg.BasePath, cfg.HooksPath)
+
+	headTailDeprecationWarning := color.ColorString{}
+	headTailDeprecationWarning.Yellow(logPrefix).Yellow("[WARN] use of _tail.html and _head.html is deprecated, please use _layout.html instead")
+
+	if !cfg.DryRun {
+		os.MkdirAll(publicPath, os.ModePerm)
+	}
+
+	watching := cfg.Serve && cfg.Watch && !cfg.DryRun
+
+	jobs := cfg.Jobs
+	if jobs == 0 {
+		jobs = runtime.NumCPU()
+	}
+
+	alvuApp := &Alvu{
+		config:       cfg,
+		basePath:     basePath,
+		outPath:      outPath,
+		publicPath:   publicPath,
+		hooksPath:    hooksPath,
+		pagesPath:    pagesPath,
+		dataPath:     dataPath,
+		jobs:         jobs,
+		hooks:        HookCollection{},
+		siteData:     map[string]interface{}{},
+		namedLayouts: NewNamedLayouts(layoutsPath),
+		liveReload:   watching,
+		reloadLock:   &sync.Mutex{},
+		outputs:      map[string]string{},
+		outputsLock:  &sync.Mutex{},
+		failedLock:   &sync.Mutex{},
+	}
+
+	if err := ExcludePatterns(cfg.Exclude).Validate(); err != nil {
+		return nil, err
+	}
+
+	if !Contains([]string{navScopeDir, navScopeSite}, cfg.NavScope) {
+		return nil, fmt.Errorf("invalid nav scope `%v`, use one of dir or site", cfg.NavScope)
+	}
+
+	// the pages need to know the port of the live reload socket
+	if strings.TrimPrefix(cfg.ReloadPort, ":") == "0" {
+		return nil, fmt.Errorf("`-reload-port` can't be 0, leave it out to use the same port as the server")
+	}
+
+	for _, collection := range cfg.Collections {
+		if err := collection.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, metaDefault := range cfg.Defaults {
+		if err := metaDefault.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, taxonomy := range cfg.Taxonomies {
+		if err := taxonomy.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	schema, err := LoadMetaSchema(basePath)
+	if err != nil {
+		return nil, err
+	}
+	alvuApp.schema = schema
+
+	if cfg.Fingerprint {
+		alvuApp.assetManifest = NewAssetManifest(cfg.BaseURL)
+	}
+
+	if cfg.Sitemap {
+		alvuApp.sitemap = NewSitemap()
+	}
+
+	if len(cfg.FeedFormat) > 0 {
+		feed, err := NewFeed(cfg.FeedFormat, cfg.FeedTitle, cfg.BaseURL)
+		if err != nil {
+			return nil, err
+		}
+		alvuApp.feed = feed
+	}
+
+	if cfg.SearchIndex {
+		alvuApp.searchIndex = NewSearchIndex()
+	}
+
+	if len(cfg.SingleFileManifest) > 0 && len(cfg.SingleFile) == 0 {
+		return nil, fmt.Errorf("-single-file-manifest needs -single-file to be set")
+	}
+	if len(cfg.PDF) > 0 && len(cfg.SingleFile) == 0 {
+		return nil, fmt.Errorf("-pdf needs -single-file to be set")
+	}
+	if len(cfg.SingleFile) > 0 {
+		singleFile, err := NewSingleFile(cfg.SingleFileManifest)
+		if err != nil {
+			return nil, err
+		}
+		alvuApp.singleFile = singleFile
+	}
+
+	// the deprecated _head.html and _tail.html
+	// are left alone with -no-legacy-headtail
+	var headFile, tailFile *templateFile
+	if !cfg.NoLegacyHeadTail {
+		onDebug(func() {
+			debugInfo("Reading _head")
+			memuse()
+		})
+		var err error
+		headFile, err = readTemplateFile(headFilePath)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+			log.Println("no _head.html found, skipping")
+		} else {
+			fmt.Println(headTailDeprecationWarning.String())
+		}
+	}
+
+	onDebug(func() {
+		debugInfo("Reading _layout")
+		memuse()
+	})
+	baseFile, err := readTemplateFile(baseFilePath)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		log.Println("no _layout.html found, skipping")
+	}
+	alvuApp.baseTemplate = baseFile
+
+	if !cfg.NoLegacyHeadTail {
+		onDebug(func() {
+			debugInfo("Reading _tail")
+			memuse()
+		})
+		var err error
+		tailFile, err = readTemplateFile(tailFilePath)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+			log.Println("no _tail.html found, skipping")
+		} else {
+			fmt.Println(headTailDeprecationWarning.String())
+		}
+	}
+
+	onDebug(func() {
+		debugInfo("Checking if 404.html exists")
+		memuse()
+	})
+	if _, err := os.Stat(notFoundFilePath); errors.Is(err, os.ErrNotExist) {
+		log.Println("no 404.html found, skipping")
+	}
+
+	if cfg.Timing {
+		alvuApp.timings = NewTimings()
+	}
+
+	alvuApp.pageTemplates = &templateFiles{
+		pagesPath: pagesPath,
+		files: map[string]*templateFile{
+			headFilePath: headFile,
+			baseFilePath: baseFile,
+			tailFilePath: tailFile,
+		},
+		headTailWarning: headTailDeprecationWarning.String(),
+		noHeadTail:      cfg.NoLegacyHeadTail,
+	}
+
+	return alvuApp, nil
+}
+
+// prepare copies the public files, loads the site data and
+// hooks, runs the OnStart hooks and adds the files of the pages
+func (al *Alvu) prepare() error {
+	cfg := al.config
+
+	if !cfg.DryRun {
+		started := time.Now()
+		if err := al.CopyPublic(); err != nil {
+			return err
+		}
+		al.timings.Phase("copy public", started)
+	}
+
+	onDebug(func() {
+		debugInfo("Reading hook and to process files")
+		memuse()
+	})
+	started := time.Now()
+	if err := al.LoadSiteData(); err != nil {
+		return err
+	}
+	al.timings.Phase("site data", started)
+	// hooks can write files on their own,
+	// so they aren't run for a dry run
+	if !cfg.DryRun {
+		started := time.Now()
+		hooks, err := CollectHooks(al.basePath, al.hooksPath)
+		if err != nil {
+			return err
+		}
+		al.hooks = hooks
+		al.timings.Phase("collect hooks", started)
+	}
+
+	started = time.Now()
+	toProcess, err := CollectFilesToProcess(al.pagesPath, ExcludePatterns(cfg.Exclude))
+	if err != nil {
+		return err
+	}
+	al.timings.Phase("collect files", started)
+	onDebug(func() {
+		log.Println("printing files to process")
+		log.Println(toProcess)
+	})
+
+	if !cfg.DryRun {
+		if err := al.initMDProcessor(); err != nil {
+			return err
+		}
+	}
+
+	onDebug(func() {
+		debugInfo("Running all OnStart hooks")
+		memuse()
+	})
+
+	started = time.Now()
+	if err := al.hooks.RunAll("OnStart"); err != nil {
+		return err
+	}
+	al.timings.Phase("OnStart hooks", started)
+
+	prefixSlashPath := regexp.MustCompile(`^\/`)
+
+	onDebug(func() {
+		debugInfo("Creating Alvu Files")
+		memuse()
+	})
+	for _, toProcessItem := range toProcess {
+		fileName := strings.Replace(toProcessItem, al.pagesPath, "", 1)
+		fileName = prefixSlashPath.ReplaceAllString(fileName, "")
+		destFilePath := strings.Replace(toProcessItem, al.pagesPath, al.outPath, 1)
+		isHTML := strings.HasSuffix(fileName, ".html")
+
+		// files in a collection are written under its prefix
+		collection := collectionFor(cfg.Collections, fileName)
+		if collection != nil {
+			destFilePath = path.Join(al.outPath, collection.OutputName(fileName))
+		}
+
+		pageDir := path.Dir(toProcessItem)
+		pageHead, err := al.pageTemplates.nearest(pageDir, "_head.html")
+		if err != nil {
+			return err
+		}
+		pageTail, err := al.pageTemplates.nearest(pageDir, "_tail.html")
+		if err != nil {
+			return err
+		}
+		layoutFile, err := al.pageTemplates.nearest(pageDir, "_layout.html")
+		if err != nil {
+			return err
+		}
+
+		alvuFile := &AlvuFile{
+			lock:         &sync.Mutex{},
+			alvu:         al,
+			sourcePath:   toProcessItem,
+			hooks:        al.hooks,
+			destPath:     destFilePath,
+			name:         fileName,
+			collection:   collection,
+			isHTML:       isHTML,
+			isText:       Contains(textExtensions, strings.ToLower(filepath.Ext(fileName))),
+			passthrough:  !Contains(cfg.TemplateExtensions, strings.ToLower(filepath.Ext(fileName))),
+			headFile:     pageHead,
+			tailFile:     pageTail,
+			baseTemplate: layoutFile,
+			data:         map[string]interface{}{},
+			extras:       map[string]interface{}{},
+		}
+
+		al.AddFile(alvuFile)
+	}
+
+	return nil
+}
+
+// Convert renders a single markdown document read from r to w
+// without building the rest of the site. The front matter is read
+// as usual, the site data, partials and shortcodes come from the
+// base path and the document is wrapped in cfg.Layout if it's set,
+// hooks aren't run and nothing is written to the output directory
+func Convert(cfg Config, r io.Reader, w io.Writer) error {
+	cfg = cfg.withDefaults()
+	// there's no output directory to write the theme to
+	// so the styles are inlined instead
+	cfg.HighlightCSS = false
+
+	pagesPath := path.Join(cfg.BasePath, "pages")
+	al := &Alvu{
+		config:       cfg,
+		basePath:     cfg.BasePath,
+		outPath:      cfg.OutPath,
+		pagesPath:    pagesPath,
+		dataPath:     path.Join(cfg.BasePath, "data"),
+		hooks:        HookCollection{},
+		siteData:     map[string]interface{}{},
+		namedLayouts: NewNamedLayouts(path.Join(pagesPath, layoutsDir)),
+		outputs:      map[string]string{},
+		outputsLock:  &sync.Mutex{},
+		failedLock:   &sync.Mutex{},
+	}
+
+	if err := al.LoadSiteData(); err != nil {
+		return err
+	}
+	if err := al.initMDProcessor(); err != nil {
+		return err
+	}
+	if err := al.loadTemplates(); err != nil {
+		return err
+	}
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("error reading the document, error: %v", err)
+	}
+
+	af := &AlvuFile{
+		lock:       &sync.Mutex{},
+		alvu:       al,
+		name:       "stdin.md",
+		sourcePath: "stdin",
+		content:    content,
+		data:       map[string]interface{}{},
+		extras:     map[string]interface{}{},
+	}
+	if err := af.ParseMeta(); err != nil {
+		return err
+	}
+
+	if len(cfg.Layout) > 0 {
+		layout, err := readTemplateFile(cfg.Layout)
+		if err != nil {
+			return fmt.Errorf("error reading the layout, error: %v", err)
+		}
+		af.layout = layout
+	}
+
+	rendered, err := af.render("")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(rendered)
+	return err
+}
+
+// UTILS
+func memuse() {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	fmt.Printf("heap: %v MiB\n", bytesToMB(m.HeapAlloc))
+}
+
+// outputURL converts the path of a compiled file into
+// the url it would be served at
+func (al *Alvu) outputURL(targetFile string) (string, error) {
+	relPath, err := filepath.Rel(al.outPath, targetFile)
+	if err != nil {
+		return "", err
+	}
+	relPath = filepath.ToSlash(relPath)
+	if al.config.PrettyURLs && path.Base(relPath) == "index.html" {
+		relPath = strings.TrimSuffix(relPath, "index.html")
+	}
+	return joinURL(al.config.BaseURL, relPath), nil
+}
+
+// prettyTargetFile moves html files into a directory of the
+// same name as an index.html so they can be served without
+// the extension, `about.html` => `about/index.html`
+func prettyTargetFile(targetFile string) string {
+	if filepath.Ext(targetFile) != ".html" {
+		return targetFile
+	}
+
+	fileName := filepath.Base(targetFile)
+	if fileName == "index.html" || fileName == "404.html" {
+		return targetFile
+	}
+
+	return filepath.Join(strings.TrimSuffix(targetFile, ".html"), "index.html")
+}
+
+// isTruthy checks for meta values that should be considered
+// as enabled, eg: `true`, `"yes"`
+func isTruthy(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return Contains([]string{"true", "yes", "1"}, strings.ToLower(strings.TrimSpace(v)))
+	case int:
+		return v != 0
+	case float64:
+		return v != 0
+	}
+	return false
+}
+
+// baseURLPath returns just the path of the baseurl,
+// which might be an absolute url
+func baseURLPath(baseURL string) string {
+	if parsed, err := url.Parse(baseURL); err == nil && len(parsed.Host) > 0 {
+		return parsed.Path
+	}
+	return baseURL
+}
+
+// joinURL joins the path to the base url making sure there's
+// exactly one slash between them
+func joinURL(base string, urlPath string) string {
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(urlPath, "/")
+}
+
+func bytesToMB(inBytes uint64) uint64 {
+	return inBytes / 1024 / 1024
+}
+
+func logError(err error) {
+	cs := &color.ColorString{}
+	fmt.Fprintln(os.Stderr, cs.Red(logPrefix).Red(": "+err.Error()).String())
+}
+
+func debugInfo(msg string, a ...any) {
+	cs := &color.ColorString{}
+	prefix := logPrefix
+	baseMessage := cs.Reset("").Yellow(prefix).Reset(" ").Gray(msg).String()
+	fmt.Fprintf(os.Stdout, baseMessage+" \n", a...)
+}
+
+func showDebug() bool {
+	showInfo := env.Get("DEBUG_ALVU", "")
+	return len(showInfo) != 0
+}
+
+func onDebug(fn func()) {
+	if !showDebug() {
+		return
+	}
+
+	fn()
+}
+
+func mergeMapWithCheck(maps ...any) (source map[string]interface{}) {
+	source = map[string]interface{}{}
+	for _, toCheck := range maps {
+		if pairs, ok := toCheck.(map[string]interface{}); ok {
+			for k, v := range pairs {
+				source[k] = v
+			}
+		}
+	}
+	return source
+}
+
+func Contains(collection []string, item string) bool {
+	for _, x := range collection {
+		if item == x {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package alvu
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeSite writes the files, by their path relative to the
+// site, into a temp directory and returns the directory
+func writeSite(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		filePath := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// buildSite builds the site into `dist` in the site's
+// directory, the paths in the config are filled in
+func buildSite(t *testing.T, dir string, cfg Config) error {
+	t.Helper()
+	cfg.BasePath = dir
+	cfg.OutPath = filepath.Join(dir, "dist")
+	return Build(cfg)
+}
+
+// buildAlvu builds the site like buildSite does, keeping the
+// Alvu around for the tests of the server and the watcher
+func buildAlvu(t *testing.T, dir string, cfg Config) *Alvu {
+	t.Helper()
+	cfg.BasePath = dir
+	cfg.OutPath = filepath.Join(dir, "dist")
+	al, err := newAlvu(cfg.withDefaults())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		al.hooks.Shutdown()
+		al.templateHooks.Shutdown()
+	})
+	if err := al.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if err := al.Build(); err != nil {
+		t.Fatal(err)
+	}
+	return al
+}
+
+// readOutput reads a built file, relative to the output
+func readOutput(t *testing.T, dir string, name string) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(dir, "dist", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+// readTree reads every file in the directory by its
+// path relative to the directory
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.WalkDir(dir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		relPath, _ := filepath.Rel(dir, filePath)
+		files[relPath] = string(content)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestBuildOutputDoesNotDependOnJobs(t *testing.T) {
+	files := map[string]string{
+		"pages/_layout.html": `<main>{{.Content}}</main>{{with .Extras.prev}}<a href="{{.URL}}">{{.Title}}</a>{{end}}`,
+		"pages/index.html":   `{{range .Pages}}<a href="{{.URL}}">{{.Meta.title}}</a>{{end}}`,
+		"public/style.css":   "body { color: red; }",
+		"hooks/title.lua": `local json = require("json")
+
+function Writer(filedata)
+    local source = json.decode(filedata)
+    source.content = source.content .. "\n\nhooked " .. source.name
+    return json.encode(source)
+end`,
+	}
+	for i := 0; i < 24; i++ {
+		name := fmt.Sprintf("post-%02d", i)
+		files["pages/blog/"+name+".md"] = fmt.Sprintf("---\ntitle: %v\ndate: 2023-01-%02d\n---\n\n# %v\n\nSome *text*", name, i+1, name)
+	}
+	dir := writeSite(t, files)
+
+	outputs := []map[string]string{}
+	for _, jobs := range []int{1, 8} {
+		outPath := filepath.Join(dir, fmt.Sprintf("dist-%v", jobs))
+		err := Build(Config{BasePath: dir, OutPath: outPath, Jobs: jobs, NoCache: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, readTree(t, outPath))
+	}
+
+	if len(outputs[0]) != 26 {
+		t.Fatalf("expected 26 files in the output, got %v", len(outputs[0]))
+	}
+	post := outputs[0][filepath.Join("blog", "post-05.html")]
+	if !strings.Contains(post, "hooked blog/post-05") || !strings.Contains(post, ">post-04</a>") {
+		t.Fatalf("expected the post to be hooked and link to the previous one, got %q", post)
+	}
+	if !reflect.DeepEqual(outputs[0], outputs[1]) {
+		for name, content := range outputs[0] {
+			if outputs[1][name] != content {
+				t.Errorf("%v differs between -jobs 1 and -jobs 8:\n%v\n---\n%v", name, content, outputs[1][name])
+			}
+		}
+		t.FailNow()
+	}
+}
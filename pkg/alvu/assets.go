@@ -0,0 +1,202 @@
+package alvu
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// assetURL returns the url for a file from public, using the
+// fingerprinted name if there's one
+func (al *Alvu) assetURL(assetPath string) string {
+	assetPath = strings.TrimPrefix(assetPath, "/")
+	if al.assetManifest != nil {
+		return al.assetManifest.URL(assetPath)
+	}
+	return joinURL(al.config.BaseURL, assetPath)
+}
+
+var fingerprintExtensions = []string{".css", ".js"}
+
+// AssetManifest keeps track of the fingerprinted public
+// files, original path => path with the hash
+type AssetManifest struct {
+	lock    *sync.RWMutex
+	baseurl string
+	assets  map[string]string
+}
+
+func NewAssetManifest(baseurl string) *AssetManifest {
+	return &AssetManifest{
+		lock:    &sync.RWMutex{},
+		baseurl: baseurl,
+		assets:  map[string]string{},
+	}
+}
+
+// URL returns the url for the file, using the
+// fingerprinted name if there's one
+func (am *AssetManifest) URL(assetPath string) string {
+	if hashed, ok := am.Get(assetPath); ok {
+		assetPath = hashed
+	}
+	return joinURL(am.baseurl, assetPath)
+}
+
+func (am *AssetManifest) Get(assetPath string) (string, bool) {
+	am.lock.RLock()
+	defer am.lock.RUnlock()
+	hashed, ok := am.assets[assetPath]
+	return hashed, ok
+}
+
+// Fingerprint renames the copied css and js files to include
+// a hash of their content and writes the mapping to assets.json
+func (am *AssetManifest) Fingerprint(sourceDir string, outDir string, excludes ExcludePatterns) error {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+
+	err := filepath.WalkDir(sourceDir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if excludes.MatchIn(sourceDir, filePath) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		ext := filepath.Ext(filePath)
+		if !Contains(fingerprintExtensions, ext) {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(sourceDir, filePath)
+		if err != nil {
+			return err
+		}
+
+		copiedPath := filepath.Join(outDir, relPath)
+		content, err := os.ReadFile(copiedPath)
+		if err != nil {
+			return err
+		}
+
+		hash := sha256.Sum256(content)
+		hashedRelPath := strings.TrimSuffix(relPath, ext) + "." + hex.EncodeToString(hash[:])[:8] + ext
+		if err := os.Rename(copiedPath, filepath.Join(outDir, hashedRelPath)); err != nil {
+			return err
+		}
+
+		am.assets[filepath.ToSlash(relPath)] = filepath.ToSlash(hashedRelPath)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	manifest, err := json.MarshalIndent(am.assets, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(outDir, "assets.json"), manifest, 0644)
+}
+
+var assetReferencePattern = regexp.MustCompile(`(?i)\b(href|src)=("[^"]*"|'[^']*')`)
+
+// RewriteReferences points the href and src attributes that
+// reference a fingerprinted file to the hashed file instead
+func (am *AssetManifest) RewriteReferences(content []byte) []byte {
+	return assetReferencePattern.ReplaceAllFunc(content, func(attr []byte) []byte {
+		match := assetReferencePattern.FindSubmatch(attr)
+		quote := match[2][:1]
+		value := string(match[2][1 : len(match[2])-1])
+
+		assetPath := strings.TrimPrefix(value, strings.TrimSuffix(am.baseurl, "/"))
+		assetPath = strings.TrimPrefix(assetPath, "/")
+		if _, ok := am.Get(assetPath); !ok {
+			return attr
+		}
+
+		return []byte(string(match[1]) + "=" + string(quote) + am.URL(assetPath) + string(quote))
+	})
+}
+
+// rootRelativeReferences points the relative href and src
+// attributes to the baseurl, resolved from the directory of
+// the page in pages, so `./a.png` in `blog/post.md` stays
+// `/blog/a.png` no matter where the page is written to
+func (al *Alvu) rootRelativeReferences(content []byte, pageName string) []byte {
+	pageDir := path.Dir(filepath.ToSlash(pageName))
+	return assetReferencePattern.ReplaceAllFunc(content, func(attr []byte) []byte {
+		match := assetReferencePattern.FindSubmatch(attr)
+		quote := match[2][:1]
+		value := string(match[2][1 : len(match[2])-1])
+		if !isRelativeReference(value) {
+			return attr
+		}
+
+		// the query and fragment are kept out of the path cleanup
+		refPath, suffix := value, ""
+		if i := strings.IndexAny(value, "?#"); i != -1 {
+			refPath, suffix = value[:i], value[i:]
+		}
+		if len(refPath) == 0 {
+			return attr
+		}
+
+		return []byte(string(match[1]) + "=" + string(quote) + al.linkURL(pageDir+"/"+refPath) + suffix + string(quote))
+	})
+}
+
+// relativeReferences returns the paths of the relative
+// href and src attributes, without the query and fragment
+func relativeReferences(content []byte) []string {
+	refs := []string{}
+	for _, match := range assetReferencePattern.FindAllSubmatch(content, -1) {
+		value := string(match[2][1 : len(match[2])-1])
+		if !isRelativeReference(value) {
+			continue
+		}
+		if i := strings.IndexAny(value, "?#"); i != -1 {
+			value = value[:i]
+		}
+		value = strings.ReplaceAll(value, "&amp;", "&")
+		if unescaped, err := url.PathUnescape(value); err == nil {
+			value = unescaped
+		}
+		if len(value) == 0 || Contains(refs, value) {
+			continue
+		}
+		refs = append(refs, value)
+	}
+	return refs
+}
+
+// isRelativeReference checks if the url is relative to the
+// page, absolute urls, root paths, anchors and template
+// actions are left as is
+func isRelativeReference(value string) bool {
+	if len(value) == 0 || strings.HasPrefix(value, "/") || strings.HasPrefix(value, "#") || strings.HasPrefix(value, "?") {
+		return false
+	}
+	if strings.Contains(value, "{{") {
+		return false
+	}
+	parsed, err := url.Parse(value)
+	return err == nil && len(parsed.Scheme) == 0
+}
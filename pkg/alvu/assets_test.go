@@ -0,0 +1,31 @@
+package alvu
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRootRelativeLinks(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"pages/blog/2023/post.md": "![a](./a.png)\n\n![b](../img/b.png?v=1)\n\n" +
+			"[next](next.html#top) [abs](/about/) [ext](https://example.com/x.png) [anchor](#intro) [mail](mailto:a@b.c)",
+	})
+	if err := buildSite(t, dir, Config{BaseURL: "/docs/", RootRelativeLinks: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	post := readOutput(t, dir, "blog/2023/post.html")
+	for _, expected := range []string{
+		`src="/docs/blog/2023/a.png"`,
+		`src="/docs/blog/img/b.png?v=1"`,
+		`href="/docs/blog/2023/next.html#top"`,
+		`href="/about/"`,
+		`href="https://example.com/x.png"`,
+		`href="#intro"`,
+		`href="mailto:a@b.c"`,
+	} {
+		if !strings.Contains(post, expected) {
+			t.Errorf("expected %v in the page, got %q", expected, post)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package alvu
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+// buildCached builds the file unless it's unchanged since the
+// last build, in which case the outputs from the last build are
+// kept and only added to the sitemap and feed again, returns
+// true if the cached build was used
+func (al *Alvu) buildCached(af *AlvuFile) (bool, error) {
+	if al.cache == nil || af.skip || af.passthrough {
+		return false, af.Build()
+	}
+
+	hash := contentHash(af.content)
+	if entry, ok := al.cache.Get(af.sourcePath, hash); ok {
+		onDebug(func() {
+			debugInfo("Unchanged %v, using the cached build", af.sourcePath)
+		})
+		af.hooked = entry.Hooked
+		af.warnings = entry.Warnings
+		for _, output := range entry.Outputs {
+			if err := al.claimOutput(output.Path, af.sourcePath); err != nil {
+				return true, err
+			}
+			if al.sitemap != nil && output.Sitemap != nil {
+				al.sitemap.add(output.Path, *output.Sitemap)
+			}
+			if al.feed != nil && output.Feed != nil {
+				al.feed.add(output.Path, *output.Feed)
+			}
+			if al.searchIndex != nil && output.Search != nil {
+				al.searchIndex.add(output.Path, *output.Search)
+			}
+			// the assets are copied again since they
+			// aren't a part of the hash of the page
+			af.assets = output.Assets
+			if err := af.copyAssets(output.Path); err != nil {
+				return true, err
+			}
+			af.aliases = output.Aliases
+			if err := af.writeAliases(output.Path, output.Aliases); err != nil {
+				return true, err
+			}
+		}
+		return true, nil
+	}
+
+	if err := af.Build(); err != nil {
+		return false, err
+	}
+
+	entry := buildCacheEntry{
+		Hash:     hash,
+		Hooked:   af.hooked,
+		Warnings: af.warnings,
+	}
+	for _, file := range af.builtFiles() {
+		output := cachedOutput{
+			Path:    file.targetFile(string(file.targetName)),
+			Assets:  file.assets,
+			Aliases: file.aliases,
+		}
+		if al.sitemap != nil {
+			if url, ok := al.sitemap.get(output.Path); ok {
+				output.Sitemap = &url
+			}
+		}
+		if al.feed != nil {
+			if item, ok := al.feed.get(output.Path); ok {
+				output.Feed = &item
+			}
+		}
+		if al.searchIndex != nil {
+			if entry, ok := al.searchIndex.get(output.Path); ok {
+				output.Search = &entry
+			}
+		}
+		entry.Outputs = append(entry.Outputs, output)
+	}
+	al.cache.Set(af.sourcePath, entry)
+	return false, nil
+}
+
+// builtFiles are the files written by the last build of
+// the file, the ones fanned out by a hook if there are any
+func (af *AlvuFile) builtFiles() []*AlvuFile {
+	if len(af.fanout) > 0 {
+		return af.fanout
+	}
+	return []*AlvuFile{af}
+}
+
+// buildCacheFile is kept in the base path and
+// buildCacheVersion is bumped whenever the format
+// of the cache changes
+const (
+	buildCacheFile    = ".alvu-cache.json"
+	buildCacheVersion = 3
+)
+
+// BuildCache keeps the hash of every built file along with the
+// outputs it wrote, the files are only built again if their content
+// or the globals (config, layouts, hooks, data and the meta of
+// every page) have changed since the last build
+type BuildCache struct {
+	lock     *sync.Mutex
+	path     string
+	globals  string
+	previous map[string]buildCacheEntry
+	current  map[string]buildCacheEntry
+}
+
+type buildCacheFileData struct {
+	Version int                        `json:"version"`
+	Globals string                     `json:"globals"`
+	Files   map[string]buildCacheEntry `json:"files"`
+}
+
+type buildCacheEntry struct {
+	Hash     string         `json:"hash"`
+	Outputs  []cachedOutput `json:"outputs"`
+	Hooked   bool           `json:"hooked,omitempty"`
+	Warnings []string       `json:"warnings,omitempty"`
+}
+
+type cachedOutput struct {
+	Path    string       `json:"path"`
+	Assets  []string     `json:"assets,omitempty"`
+	Aliases []string     `json:"aliases,omitempty"`
+	Sitemap *sitemapURL  `json:"sitemap,omitempty"`
+	Feed    *FeedItem    `json:"feed,omitempty"`
+	Search  *SearchEntry `json:"search,omitempty"`
+}
+
+// LoadBuildCache reads the cache from the last build, a missing
+// or unreadable cache, or one from another version or with other
+// globals, is the same as an empty one
+func LoadBuildCache(cachePath string, globals string) *BuildCache {
+	bc := &BuildCache{
+		lock:     &sync.Mutex{},
+		path:     cachePath,
+		globals:  globals,
+		previous: map[string]buildCacheEntry{},
+		current:  map[string]buildCacheEntry{},
+	}
+
+	content, err := os.ReadFile(cachePath)
+	if err != nil {
+		return bc
+	}
+	var data buildCacheFileData
+	if err := json.Unmarshal(content, &data); err != nil {
+		return bc
+	}
+	if data.Version != buildCacheVersion || data.Globals != globals || data.Files == nil {
+		return bc
+	}
+	bc.previous = data.Files
+	return bc
+}
+
+// Get returns the entry of the file from the last build if the
+// hash matches and all of it's outputs are still there
+func (bc *BuildCache) Get(sourcePath string, hash string) (buildCacheEntry, bool) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	entry, ok := bc.previous[sourcePath]
+	if !ok || entry.Hash != hash || len(entry.Outputs) == 0 {
+		return buildCacheEntry{}, false
+	}
+	for _, output := range entry.Outputs {
+		if _, err := os.Stat(output.Path); err != nil {
+			return buildCacheEntry{}, false
+		}
+	}
+	bc.current[sourcePath] = entry
+	return entry, true
+}
+
+func (bc *BuildCache) Set(sourcePath string, entry buildCacheEntry) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	bc.current[sourcePath] = entry
+}
+
+// Save writes the entries of this build, files that weren't
+// built (removed, skipped or failed) are left out
+func (bc *BuildCache) Save() error {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	content, err := json.Marshal(buildCacheFileData{
+		Version: buildCacheVersion,
+		Globals: bc.globals,
+		Files:   bc.current,
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(bc.path, content, 0644)
+}
+
+// cacheGlobals hashes everything other than the page itself
+// that ends up in it's output
+func (al *Alvu) cacheGlobals() (string, error) {
+	hash := sha256.New()
+	// the report and timing don't change what's built
+	cfg := al.config
+	cfg.Report = ""
+	cfg.Timing = false
+	// pointers would hash their address which changes on every
+	// run, the policy is hashed by value and the cache is off
+	// with any MarkdownExtensions
+	cfg.SanitizePolicy = nil
+	cfg.MarkdownExtensions = nil
+	fmt.Fprintf(hash, "%#v\n", cfg)
+	policy, err := json.Marshal(al.config.SanitizePolicy)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(hash, "%s\n", policy)
+	fmt.Fprintf(hash, "%v\n%v\n%v\n", al.pages, al.siteData, al.liveReload)
+	if al.assetManifest != nil {
+		al.assetManifest.lock.RLock()
+		fmt.Fprintf(hash, "%v\n", al.assetManifest.assets)
+		al.assetManifest.lock.RUnlock()
+	}
+
+	dirs := []string{
+		al.hooksPath,
+		al.dataPath,
+		path.Join(al.basePath, partialsDir),
+		path.Join(al.basePath, shortcodesDir),
+		path.Join(al.pagesPath, layoutsDir),
+	}
+	for _, dir := range dirs {
+		if err := hashDir(hash, dir, nil); err != nil {
+			return "", err
+		}
+	}
+	// the reserved files in pages, the layouts and the data files
+	isReserved := func(filePath string) bool {
+		return Contains(reservedFiles, filepath.Base(filePath))
+	}
+	if err := hashDir(hash, al.pagesPath, isReserved); err != nil {
+		return "", err
+	}
+	// the highlight theme can be a path to a chroma theme
+	if content, err := os.ReadFile(al.config.HighlightTheme); err == nil {
+		hash.Write(content)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// hashDir adds the path and the content of every file in the
+// directory to the hash, only the files that match if given
+func hashDir(hash io.Writer, dirPath string, match func(filePath string) bool) error {
+	err := filepath.WalkDir(dirPath, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || (match != nil && !match(filePath)) {
+			return nil
+		}
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(hash, "%v\n", filePath)
+		hash.Write(content)
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func contentHash(content []byte) string {
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,40 @@
+package alvu
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildCacheHitsWithDefaultConfig(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"pages/index.md":     "# Home",
+		"pages/blog/post.md": "# Post\n\nSome text",
+		"pages/about.html":   "<p>{{.Meta.BaseURL}}</p>",
+	})
+	reportPath := filepath.Join(dir, "report.json")
+
+	for i := 0; i < 2; i++ {
+		if err := buildSite(t, dir, Config{Report: reportPath}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	content, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report buildReportData
+	if err := json.Unmarshal(content, &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Files) != 3 {
+		t.Fatalf("expected 3 files in the report, got %v", len(report.Files))
+	}
+	for _, file := range report.Files {
+		if file.Status != "cached" {
+			t.Errorf("expected %v to be cached on the second build, got %v", file.Source, file.Status)
+		}
+	}
+}
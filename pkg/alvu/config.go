@@ -0,0 +1,464 @@
+package alvu
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/barelyhuman/go/color"
+	"github.com/yuin/goldmark"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration for a single build
+// of the site, the zero values fall back to the same
+// defaults as the CLI flags where it makes sense
+type Config struct {
+	// BasePath is the directory that contains the
+	// pages, public, hooks and data directories
+	BasePath string
+	OutPath  string
+	BaseURL  string
+	// HooksPath is relative to the BasePath
+	HooksPath string
+
+	Highlight            bool
+	HighlightTheme       string
+	HighlightLineNumbers bool
+	HighlightCSS         bool
+	HardWraps            bool
+	// MarkdownDisable are the names of the markdown
+	// extensions to turn off, see markdownExtensions
+	MarkdownDisable []string
+	// NoH1Title stops the first h1 of a markdown page from being
+	// used as `.Page.title` when the front matter has no title
+	NoH1Title bool
+	// WarnDuplicateIDs warns about the headings of a page that
+	// get a numbered id since an earlier one has the same id
+	WarnDuplicateIDs bool
+	// DefinitionLists and Typographer are opt in markdown
+	// extensions, TypographerDisable are the names of the
+	// substitutions to leave out, see typographerSubstitutions
+	DefinitionLists    bool
+	Typographer        bool
+	TypographerDisable []string
+	// Math keeps `$...$` and `$$...$$` as is for katex or
+	// mathjax, MathScript adds katex to the pages with math
+	Math       bool
+	MathScript bool
+	// Mermaid writes the ```mermaid code blocks for the mermaid
+	// script to render, MermaidScript adds it to the pages with them
+	Mermaid       bool
+	MermaidScript bool
+	// SafeMarkdown leaves the raw html in markdown files out
+	// and SanitizeMarkdown keeps the parts of it that are in
+	// the SanitizePolicy instead, Sanitize runs the whole
+	// converted markdown through the SanitizePolicy
+	SafeMarkdown     bool
+	SanitizeMarkdown bool
+	Sanitize         bool
+	// SanitizePolicy defaults to the DefaultSanitizePolicy
+	SanitizePolicy *SanitizePolicy
+	// Emoji turns shortcodes like `:tada:` into emojis, EmojiMode
+	// is one of emojiModes and defaults to unicode
+	Emoji     bool
+	EmojiMode string
+	// MarkdownExtensions are extra goldmark extensions, they
+	// are added after the built in ones (highlighting included)
+	MarkdownExtensions []goldmark.Extender
+	// RootRelativeLinks rewrites the relative src and href
+	// of markdown pages to start from the BaseURL
+	RootRelativeLinks bool
+
+	Sitemap    bool
+	FeedFormat string
+	FeedTitle  string
+	// SearchIndex writes the title, url and text of
+	// every page to `search-index.json`
+	SearchIndex bool
+	// SingleFile joins the html pages into one document at this
+	// path, relative to the OutPath, instead of writing each one,
+	// SingleFileManifest lists the pages to join in their order
+	SingleFile         string
+	SingleFileManifest string
+	// PDF is the path, relative to the OutPath, that PDFCommand
+	// writes the single file to as a pdf, `{input}` and `{output}`
+	// in the command are replaced with the paths of the two
+	PDF        string
+	PDFCommand string
+
+	Drafts      bool
+	Future      bool
+	Clean       bool
+	CleanDryRun bool
+	// KeepGoing logs the files that fail to build
+	// and builds the rest instead of stopping at the
+	// first error
+	KeepGoing bool
+	// Strict fails the pages that don't match the front
+	// matter schema instead of warning, see MetaSchema
+	Strict bool
+	// StrictMarkdown fails the build when a page links to a
+	// file that isn't in the output, CheckExternal requests
+	// the links to other sites as well, see LinkChecker
+	StrictMarkdown bool
+	CheckExternal  bool
+	// DryRun prints the files that would be built
+	// without writing anything to the output
+	DryRun      bool
+	PrettyURLs  bool
+	Minify      bool
+	Fingerprint bool
+	TOCMinLevel int
+	// Jobs is the number of files processed at a time, 0 picks
+	// the number of CPUs, or 1 for the pages when a hook has an
+	// `OnStart` since the copies of the hooks the other workers
+	// get don't run it
+	Jobs int
+	// WordsPerMinute is the reading speed that the
+	// `.Extras.reading_time` of the pages is based on
+	WordsPerMinute int
+	// TemplateExtensions are the extensions of the files in
+	// pages that are run through the templates, everything
+	// else is copied as is
+	TemplateExtensions []string
+	// Exclude are glob patterns of the files to leave
+	// out from pages and public, see ExcludePatterns
+	Exclude []string
+
+	// NavSort is the meta key the pages are ordered by
+	// for the prev/next links and NavScope is either
+	// `dir` or `site`
+	NavSort  string
+	NavScope string
+
+	// Collections are the directories in pages with their
+	// own default layout and output prefix, see Collection
+	Collections []Collection
+
+	// Taxonomies are the front matter keys, like `tags`, that
+	// get a page for each of their values, see Taxonomy
+	Taxonomies []Taxonomy
+
+	// Footnotes are the footnote options from the config
+	Footnotes Footnotes
+
+	// Defaults are the front matter of the pages matching
+	// their path, later ones win over the earlier ones and the
+	// page's own front matter wins over all of them
+	Defaults []FrontMatterDefault
+
+	// CSVDelimiter is the delimiter of the csv files in the
+	// data directory, a single character or `\t` for tabs
+	CSVDelimiter string
+
+	// Layout is the layout file (relative to the working
+	// directory) that Convert wraps the document in
+	Layout string
+	// NoLegacyHeadTail stops the deprecated `_head.html` and
+	// `_tail.html` from being added to the pages
+	NoLegacyHeadTail bool
+	// Report is the file (relative to the working directory)
+	// to write the build report to, see BuildReport
+	Report string
+	// Timing prints how long each phase of the build took
+	Timing bool
+
+	// Serve starts the dev server after the build,
+	// which blocks till the server is stopped
+	Serve bool
+	Port  string
+	// Poll is the milliseconds the watcher waits for more
+	// changes before rebuilding, see Watcher
+	Poll       int
+	Watch      bool
+	ReloadPort string
+	// TLS serves over https with a self signed certificate
+	// for localhost, unless a TLSCert and TLSKey are given
+	TLS     bool
+	TLSCert string
+	TLSKey  string
+	// Open opens the browser once the server is up
+	Open bool
+	// NoCompress turns off the gzip/deflate
+	// compression of the served files
+	NoCompress bool
+	// FollowSymlinks copies what the symlinks in public
+	// point to instead of linking to the same target
+	FollowSymlinks bool
+	// NoCache builds every file instead of skipping the ones
+	// that haven't changed since the last build, see BuildCache.
+	// The cache is also off with MarkdownExtensions since they
+	// can't be hashed
+	NoCache bool
+}
+
+// textExtensions are the templated files that aren't
+// html, these skip the markdown conversion and layouts
+var textExtensions = []string{".txt", ".xml"}
+
+// DefaultTemplateExtensions are the files in pages
+// that are templated unless configured otherwise
+var DefaultTemplateExtensions = []string{".md", ".html", ".txt", ".xml"}
+
+func (cfg Config) withDefaults() Config {
+	if len(cfg.BasePath) == 0 {
+		cfg.BasePath = "."
+	}
+	if len(cfg.OutPath) == 0 {
+		cfg.OutPath = "./dist"
+	}
+	if len(cfg.BaseURL) == 0 {
+		cfg.BaseURL = "/"
+	}
+	if len(cfg.HooksPath) == 0 {
+		cfg.HooksPath = "./hooks"
+	}
+	if len(cfg.HighlightTheme) == 0 {
+		cfg.HighlightTheme = "bw"
+	}
+	if cfg.TOCMinLevel == 0 {
+		cfg.TOCMinLevel = 2
+	}
+	if cfg.WordsPerMinute <= 0 {
+		cfg.WordsPerMinute = 200
+	}
+	if cfg.TemplateExtensions == nil {
+		cfg.TemplateExtensions = DefaultTemplateExtensions
+	}
+	templateExtensions := []string{}
+	for _, ext := range cfg.TemplateExtensions {
+		templateExtensions = append(templateExtensions, "."+strings.TrimPrefix(strings.ToLower(ext), "."))
+	}
+	cfg.TemplateExtensions = templateExtensions
+	if cfg.MathScript {
+		cfg.Math = true
+	}
+	if cfg.MermaidScript {
+		cfg.Mermaid = true
+	}
+	if cfg.SanitizeMarkdown {
+		cfg.SafeMarkdown = true
+	}
+	if cfg.SanitizePolicy == nil {
+		cfg.SanitizePolicy = DefaultSanitizePolicy()
+	}
+	if len(cfg.EmojiMode) == 0 {
+		cfg.EmojiMode = emojiModeUnicode
+	}
+	if len(cfg.NavSort) == 0 {
+		cfg.NavSort = "date"
+	}
+	if len(cfg.NavScope) == 0 {
+		cfg.NavScope = navScopeDir
+	}
+	if len(cfg.PDFCommand) == 0 {
+		cfg.PDFCommand = defaultPDFCommand
+	}
+	if len(cfg.CSVDelimiter) == 0 {
+		cfg.CSVDelimiter = ","
+	}
+	if len(cfg.Port) == 0 {
+		cfg.Port = "3000"
+	}
+	if cfg.Poll == 0 {
+		cfg.Poll = 350
+	}
+	return cfg
+}
+
+var configFiles = []string{"alvu.yaml", "alvu.yml", "alvu.json"}
+
+// LoadConfig reads the first config file found in the base path,
+// returns an empty config if the project doesn't have one
+func LoadConfig(basePath string) (map[string]interface{}, error) {
+	config := map[string]interface{}{}
+	for _, configFile := range configFiles {
+		configPath := path.Join(basePath, configFile)
+		content, err := os.ReadFile(configPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return config, fmt.Errorf("error reading config, error: %v", err)
+		}
+
+		// yaml is a superset of json so the same parser works for both
+		if err := yaml.Unmarshal(content, &config); err != nil {
+			return config, fmt.Errorf("invalid config in %v, error: %v", configPath, err)
+		}
+
+		onDebug(func() {
+			debugInfo("Loaded config from %v", configPath)
+		})
+		return config, nil
+	}
+	return config, nil
+}
+
+// ApplyConfigToFlags uses the config values as the defaults for
+// flags that weren't passed on the command line
+func ApplyConfigToFlags(flags *flag.FlagSet, config map[string]interface{}) error {
+	passedFlags := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		passedFlags[f.Name] = true
+	})
+
+	for key, value := range config {
+		if Contains(structuredConfigKeys, key) {
+			continue
+		}
+		configFlag := flags.Lookup(key)
+		if configFlag == nil || key == "path" || key == "version" || key == "v" {
+			warning := &color.ColorString{}
+			warning.Yellow(logPrefix).Yellow("[WARN] unknown config key: " + key)
+			fmt.Println(warning.String())
+			continue
+		}
+
+		if passedFlags[key] {
+			continue
+		}
+
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+
+		for _, v := range values {
+			if err := flags.Set(key, fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("invalid value for config key `%v`, error: %v", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// structuredConfigKeys are the config keys that don't map
+// to a flag, they're read from the config by their own helpers
+var structuredConfigKeys = []string{"collections", "defaults", "footnotes", "sanitize-policy", "taxonomies"}
+
+// FrontMatterDefault is the front matter the pages matching the
+// path get by default, set under `defaults` in the config, eg:
+//
+//	defaults:
+//	  - path: blog
+//	    meta:
+//	      layout: post
+type FrontMatterDefault struct {
+	// Path is a glob relative to the pages, with the same
+	// syntax as the ExcludePatterns, a directory matches
+	// everything in it
+	Path string                 `yaml:"path"`
+	Meta map[string]interface{} `yaml:"meta"`
+}
+
+// ConfigDefaults reads the front matter defaults from the
+// config, in the order they're listed
+func ConfigDefaults(config map[string]interface{}) ([]FrontMatterDefault, error) {
+	defaults := []FrontMatterDefault{}
+	value, ok := config["defaults"]
+	if !ok || value == nil {
+		return defaults, nil
+	}
+
+	content, err := yaml.Marshal(value)
+	if err != nil {
+		return defaults, fmt.Errorf("invalid defaults in the config, error: %v", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&defaults); err != nil {
+		return defaults, fmt.Errorf("invalid defaults in the config, error: %v", err)
+	}
+	return defaults, nil
+}
+
+// Validate checks that the path is a valid pattern
+func (d FrontMatterDefault) Validate() error {
+	if len(strings.Trim(d.Path, "/")) == 0 {
+		return fmt.Errorf("missing path for the front matter defaults, use `**` to match every page")
+	}
+	for _, segment := range strings.Split(d.Path, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("invalid path `%v` for the front matter defaults, error: %v", d.Path, err)
+		}
+	}
+	return nil
+}
+
+// Matches checks if the file, relative to the pages,
+// or any of it's directories match the path
+func (d FrontMatterDefault) Matches(fileName string) bool {
+	return ExcludePatterns{d.Path}.MatchNested(filepath.ToSlash(fileName))
+}
+
+// mergeMeta merges the override on top of the base, the maps
+// in both are merged the same way and everything else in the
+// override replaces what's in the base, the nested maps are
+// copied so the result can be changed without touching either
+func mergeMeta(base map[string]interface{}, override map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(override))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range override {
+		overrideMap, ok := value.(map[string]interface{})
+		if !ok {
+			merged[key] = value
+			continue
+		}
+		baseMap, _ := merged[key].(map[string]interface{})
+		merged[key] = mergeMeta(baseMap, overrideMap)
+	}
+	return merged
+}
+
+// Footnotes changes how the footnotes of markdown pages are
+// written, set under `footnotes` in the config, eg:
+//
+//	footnotes:
+//	  heading: Notes
+//	  backlink: "&#x2191;"
+//
+// the empty ones keep what goldmark writes by default
+type Footnotes struct {
+	// Heading is the text of a heading added to the top of
+	// the footnotes, there is no heading by default
+	Heading string `yaml:"heading"`
+	// Backlink is the html of the links back to the text,
+	// LinkTitle and BacklinkTitle are the title attributes of
+	// the links and LinkClass and BacklinkClass their classes,
+	// `^^` in any of them is replaced by the footnote's number
+	Backlink      string `yaml:"backlink"`
+	LinkTitle     string `yaml:"link-title"`
+	BacklinkTitle string `yaml:"backlink-title"`
+	LinkClass     string `yaml:"link-class"`
+	BacklinkClass string `yaml:"backlink-class"`
+}
+
+// ConfigFootnotes reads the footnote options from the config
+func ConfigFootnotes(config map[string]interface{}) (Footnotes, error) {
+	footnotes := Footnotes{}
+	value, ok := config["footnotes"]
+	if !ok || value == nil {
+		return footnotes, nil
+	}
+
+	content, err := yaml.Marshal(value)
+	if err != nil {
+		return footnotes, fmt.Errorf("invalid footnotes in the config, error: %v", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&footnotes); err != nil {
+		return footnotes, fmt.Errorf("invalid footnotes in the config, error: %v", err)
+	}
+	return footnotes, nil
+}
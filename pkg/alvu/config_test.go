@@ -0,0 +1,106 @@
+package alvu
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeMeta(t *testing.T) {
+	base := map[string]interface{}{
+		"layout": "post",
+		"type":   "article",
+		"author": map[string]interface{}{"name": "alvu", "site": "alvu.dev"},
+	}
+	override := map[string]interface{}{
+		"type":   "note",
+		"author": map[string]interface{}{"name": "reaper"},
+	}
+
+	merged := mergeMeta(base, override)
+	want := map[string]interface{}{
+		"layout": "post",
+		"type":   "note",
+		"author": map[string]interface{}{"name": "reaper", "site": "alvu.dev"},
+	}
+	if !reflect.DeepEqual(merged, want) {
+		t.Fatalf("mergeMeta() = %v, want %v", merged, want)
+	}
+
+	merged["author"].(map[string]interface{})["name"] = "changed"
+	if base["author"].(map[string]interface{})["name"] != "alvu" {
+		t.Errorf("changing the merged meta changed the base")
+	}
+	if override["author"].(map[string]interface{})["name"] != "reaper" {
+		t.Errorf("changing the merged meta changed the override")
+	}
+}
+
+func TestConfigDefaults(t *testing.T) {
+	defaults, err := ConfigDefaults(map[string]interface{}{
+		"defaults": []interface{}{
+			map[string]interface{}{
+				"path": "blog",
+				"meta": map[string]interface{}{"layout": "post"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FrontMatterDefault{{Path: "blog", Meta: map[string]interface{}{"layout": "post"}}}
+	if !reflect.DeepEqual(defaults, want) {
+		t.Errorf("ConfigDefaults() = %v, want %v", defaults, want)
+	}
+
+	_, err = ConfigDefaults(map[string]interface{}{
+		"defaults": []interface{}{map[string]interface{}{"path": "blog", "layuot": "post"}},
+	})
+	if err == nil {
+		t.Errorf("ConfigDefaults() with an unknown field, want an error")
+	}
+}
+
+func TestFrontMatterDefaultsPrecedence(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"pages/_layout.html": `{{.Page.type}} {{.Page.layout}} {{.Page.author.name}} {{.Page.author.site}}|{{.Content}}`,
+		"pages/blog/a.md":    "first",
+		"pages/blog/b.md": strings.Join([]string{
+			"---",
+			"type: note",
+			"author:",
+			"  name: reaper",
+			"---",
+			"second",
+		}, "\n"),
+		"pages/about.md": "about",
+	})
+	err := buildSite(t, dir, Config{Defaults: []FrontMatterDefault{
+		{Path: "**", Meta: map[string]interface{}{
+			"layout": "page",
+			"author": map[string]interface{}{"name": "alvu", "site": "alvu.dev"},
+		}},
+		{Path: "blog", Meta: map[string]interface{}{"layout": "post", "type": "article"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		// the later default wins over the earlier one
+		{"blog/a.html", "article post alvu alvu.dev|"},
+		// the page's own keys win, nested maps are merged
+		{"blog/b.html", "note post reaper alvu.dev|"},
+		// only the defaults matching the page apply
+		{"about.html", " page alvu alvu.dev|"},
+	}
+	for _, tt := range tests {
+		got := readOutput(t, dir, tt.name)
+		if !strings.HasPrefix(got, tt.want) {
+			t.Errorf("%v = %q, want it to start with %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package alvu
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	luaAlvu "github.com/barelyhuman/alvu/lua/alvu"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadSiteData reads the `_data` file from the pages and
+// the files in the data directory into the shared site data
+func (al *Alvu) LoadSiteData() error {
+	data := map[string]interface{}{}
+
+	csvDelimiter, err := luaAlvu.CSVDelimiter(al.config.CSVDelimiter)
+	if err != nil {
+		return err
+	}
+
+	for _, dataFile := range []string{"_data.yaml", "_data.yml", "_data.json"} {
+		fileData, err := readDataFile(path.Join(al.pagesPath, dataFile), csvDelimiter)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if fileData, ok := fileData.(map[string]interface{}); ok {
+			data = mergeMapWithCheck(data, fileData)
+		}
+	}
+
+	if _, err := os.Stat(al.dataPath); err == nil {
+		dirData, err := readDataDir(al.dataPath, csvDelimiter)
+		if err != nil {
+			return err
+		}
+		data = mergeMapWithCheck(data, dirData)
+	}
+
+	al.siteData = data
+	return nil
+}
+
+var dataFileExtensions = []string{".yaml", ".yml", ".json", ".csv", ".tsv"}
+
+// readDataDir reads all the data files in the directory, keyed
+// by the file name without the extension, nested directories
+// are added as nested maps
+func readDataDir(dirPath string, csvDelimiter rune) (map[string]interface{}, error) {
+	data := map[string]interface{}{}
+
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return data, err
+	}
+
+	for _, entry := range entries {
+		entryPath := path.Join(dirPath, entry.Name())
+		if entry.IsDir() {
+			nestedData, err := readDataDir(entryPath, csvDelimiter)
+			if err != nil {
+				return data, err
+			}
+			data[entry.Name()] = nestedData
+			continue
+		}
+
+		ext := filepath.Ext(entry.Name())
+		if !Contains(dataFileExtensions, ext) {
+			continue
+		}
+
+		fileData, err := readDataFile(entryPath, csvDelimiter)
+		if err != nil {
+			return data, err
+		}
+		data[strings.TrimSuffix(entry.Name(), ext)] = fileData
+	}
+
+	return data, nil
+}
+
+// readDataFile reads the yaml, json or csv file, csv files
+// (and tsv files, which always use tabs) are read into a list
+// of rows keyed by the header
+func readDataFile(filePath string, csvDelimiter rune) (interface{}, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".tsv":
+		csvDelimiter = '\t'
+		fallthrough
+	case ".csv":
+		rows, err := luaAlvu.ParseCSV(content, csvDelimiter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data file %v, error: %v", filePath, err)
+		}
+		return rows, nil
+	}
+
+	var data interface{}
+	if err := yaml.Unmarshal(content, &data); err != nil {
+		return nil, fmt.Errorf("invalid data file %v, error: %v", filePath, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,227 @@
+package alvu
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	luaAlvu "github.com/barelyhuman/alvu/lua/alvu"
+)
+
+// FeedItem is the information collected from a page for the
+// feeds, shared by both the rss and json outputs
+type FeedItem struct {
+	Title       string
+	URL         string
+	Summary     string
+	ContentHTML string
+	Date        time.Time
+}
+
+// Feed collects the pages that have a `date` in their meta
+// and writes them as an rss and/or json feed
+type Feed struct {
+	lock    *sync.Mutex
+	format  string
+	title   string
+	baseurl string
+	items   map[string]FeedItem
+}
+
+func NewFeed(format string, title string, baseurl string) (*Feed, error) {
+	if !Contains([]string{"rss", "json", "both"}, format) {
+		return nil, fmt.Errorf("invalid feed format `%v`, use one of rss, json or both", format)
+	}
+
+	return &Feed{
+		lock:    &sync.Mutex{},
+		format:  format,
+		title:   title,
+		baseurl: baseurl,
+		items:   map[string]FeedItem{},
+	}, nil
+}
+
+func (fd *Feed) AddFile(af *AlvuFile, targetFile string, contentHTML string) {
+	if include, ok := af.meta["feed"].(bool); ok && !include {
+		return
+	}
+
+	date, ok := luaAlvu.ParseDate(af.meta["date"])
+	if !ok {
+		return
+	}
+
+	url, err := af.alvu.outputURL(targetFile)
+	if err != nil {
+		return
+	}
+
+	item := FeedItem{
+		Title:       fmt.Sprint(af.meta["title"]),
+		URL:         url,
+		ContentHTML: contentHTML,
+		Date:        date,
+	}
+	if af.meta["title"] == nil {
+		item.Title = string(af.targetName)
+	}
+	if af.meta["description"] != nil {
+		item.Summary = fmt.Sprint(af.meta["description"])
+	}
+
+	fd.add(targetFile, item)
+}
+
+func (fd *Feed) add(targetFile string, item FeedItem) {
+	fd.lock.Lock()
+	defer fd.lock.Unlock()
+	fd.items[targetFile] = item
+}
+
+func (fd *Feed) get(targetFile string) (FeedItem, bool) {
+	fd.lock.Lock()
+	defer fd.lock.Unlock()
+	item, ok := fd.items[targetFile]
+	return item, ok
+}
+
+// sortedItems returns the items with the latest first
+func (fd *Feed) sortedItems() []FeedItem {
+	items := []FeedItem{}
+	for _, item := range fd.items {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Date.Equal(items[j].Date) {
+			return items[i].URL < items[j].URL
+		}
+		return items[i].Date.After(items[j].Date)
+	})
+	return items
+}
+
+func (fd *Feed) Write(outPath string) error {
+	fd.lock.Lock()
+	defer fd.lock.Unlock()
+
+	items := fd.sortedItems()
+
+	if fd.format == "rss" || fd.format == "both" {
+		if err := fd.writeRSS(outPath, items); err != nil {
+			return err
+		}
+	}
+
+	if fd.format == "json" || fd.format == "both" {
+		if err := fd.writeJSON(outPath, items); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+	Description string `xml:"description"`
+}
+
+func (fd *Feed) writeRSS(outPath string, items []FeedItem) error {
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       fd.title,
+			Link:        fd.baseurl,
+			Description: fd.title,
+		},
+	}
+
+	for _, item := range items {
+		description := item.Summary
+		if len(description) == 0 {
+			description = item.ContentHTML
+		}
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       item.Title,
+			Link:        item.URL,
+			GUID:        item.URL,
+			PubDate:     item.Date.Format(time.RFC1123Z),
+			Description: description,
+		})
+	}
+
+	content, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	content = append([]byte(xml.Header), content...)
+	return os.WriteFile(filepath.Join(outPath, "feed.xml"), content, 0644)
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string `json:"id"`
+	URL           string `json:"url"`
+	Title         string `json:"title"`
+	Summary       string `json:"summary,omitempty"`
+	ContentHTML   string `json:"content_html"`
+	DatePublished string `json:"date_published"`
+}
+
+func (fd *Feed) writeJSON(outPath string, items []FeedItem) error {
+	feed := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       fd.title,
+		HomePageURL: fd.baseurl,
+		FeedURL:     joinURL(fd.baseurl, "feed.json"),
+		Items:       []jsonFeedItem{},
+	}
+
+	for _, item := range items {
+		feed.Items = append(feed.Items, jsonFeedItem{
+			ID:            item.URL,
+			URL:           item.URL,
+			Title:         item.Title,
+			Summary:       item.Summary,
+			ContentHTML:   item.ContentHTML,
+			DatePublished: item.Date.Format(time.RFC3339),
+		})
+	}
+
+	content, err := json.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(outPath, "feed.json"), content, 0644)
+}
@@ -0,0 +1,598 @@
+package alvu
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	luaAlvu "github.com/barelyhuman/alvu/lua/alvu"
+	"github.com/barelyhuman/go/color"
+	cp "github.com/otiai10/copy"
+	toml "github.com/pelletier/go-toml/v2"
+	lua "github.com/yuin/gopher-lua"
+	"gopkg.in/yaml.v3"
+)
+
+type AlvuFile struct {
+	lock             *sync.Mutex
+	alvu             *Alvu
+	hooks            HookCollection
+	name             string
+	sourcePath       string
+	isHTML           bool
+	isText           bool
+	destPath         string
+	meta             map[string]interface{}
+	content          []byte
+	writeableContent []byte
+	skip             bool
+	skipReason       string
+	headFile         *templateFile
+	tailFile         *templateFile
+	baseTemplate     *templateFile
+	layout           *templateFile
+	targetName       []byte
+	data             map[string]interface{}
+	extras           map[string]interface{}
+	fanout           []*AlvuFile
+	permalink        string
+	// collection is the one the file is in, nil if none
+	collection *Collection
+	// generated files don't have a source file and
+	// aren't passed to the hooks, see Alvu.generated
+	generated bool
+	// passthrough files aren't templated and
+	// are copied to the output as is
+	passthrough bool
+	// assets are the relative src and href of the
+	// converted markdown, see copyAssets
+	assets []string
+	// aliases are the files the redirects to the
+	// page were written to, see writeAliases
+	aliases []string
+	// hooked is set when a hook's BeforeFile or Writer
+	// ran on the file, for the build report along with
+	// the warnings
+	hooked   bool
+	warnings []string
+}
+
+// Load reads the file and it's meta, needs to be
+// called before the file is built
+func (alvuFile *AlvuFile) Load() error {
+	alvuFile.warnings = nil
+	if alvuFile.passthrough {
+		return nil
+	}
+	if err := alvuFile.ReadFile(); err != nil {
+		return err
+	}
+	if err := alvuFile.ParseMeta(); err != nil {
+		return err
+	}
+	alvuFile.skip, alvuFile.skipReason = alvuFile.ShouldSkip()
+	if alvuFile.skip {
+		return nil
+	}
+	if err := alvuFile.checkSchema(); err != nil {
+		return err
+	}
+	permalink, err := alvuFile.resolvePermalink()
+	if err != nil {
+		return err
+	}
+	alvuFile.permalink = permalink
+	return nil
+}
+
+func (alvuFile *AlvuFile) Build() error {
+	alvuFile.fanout = nil
+	alvuFile.hooked = false
+
+	if alvuFile.skip {
+		onDebug(func() {
+			debugInfo("Skipping %v, %v", alvuFile.sourcePath, alvuFile.skipReason)
+		})
+		return nil
+	}
+
+	if alvuFile.passthrough {
+		return alvuFile.CopyFile()
+	}
+
+	alvuFile.ResolveLayout()
+
+	hooksStarted := time.Now()
+	hooks := alvuFile.hooks
+	if alvuFile.generated {
+		hooks = nil
+	}
+	if len(hooks) == 0 {
+		if err := alvuFile.ProcessFile(nil); err != nil {
+			return err
+		}
+	}
+
+	for _, hook := range hooks {
+		hook.Isolate()
+		hookState := hook.state
+
+		isForSpecificFile := hook.state.GetGlobal("ForFile")
+		if isForSpecificFile != lua.LNil && alvuFile.name != isForSpecificFile.String() {
+			hookState = nil
+		}
+
+		if err := alvuFile.ProcessFile(hookState); err != nil {
+			return err
+		}
+	}
+
+	alvuFile.alvu.timings.Step("hooks", time.Since(hooksStarted))
+
+	renderStarted := time.Now()
+	defer func() {
+		alvuFile.alvu.timings.Step("render", time.Since(renderStarted))
+	}()
+
+	if len(alvuFile.fanout) > 0 {
+		for _, derived := range alvuFile.fanout {
+			if err := derived.FlushFile(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return alvuFile.FlushFile()
+}
+
+// CopyFile copies the file to the output without
+// running it through the hooks or the templates
+func (af *AlvuFile) CopyFile() error {
+	onDebug(func() {
+		debugInfo("copying file: " + af.name)
+	})
+	if err := af.alvu.claimOutput(af.destPath, af.sourcePath); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(af.destPath), os.ModePerm); err != nil {
+		return err
+	}
+	return cp.Copy(af.sourcePath, af.destPath)
+}
+
+func (af *AlvuFile) ReadFile() error {
+	filecontent, err := os.ReadFile(af.sourcePath)
+	if err != nil {
+		return fmt.Errorf("error reading file, error: %v", err)
+	}
+	af.content = filecontent
+	return nil
+}
+
+type frontMatterFormat struct {
+	name      string
+	sep       []byte
+	unmarshal func([]byte, interface{}) error
+}
+
+var frontMatterFormats = []frontMatterFormat{
+	{name: "yaml", sep: []byte("---"), unmarshal: yaml.Unmarshal},
+	{name: "toml", sep: []byte("+++"), unmarshal: toml.Unmarshal},
+	{name: "json", sep: []byte(";;;"), unmarshal: json.Unmarshal},
+}
+
+var blankLinePattern = regexp.MustCompile(`\r?\n[ \t]*\r?\n`)
+
+// parseJSONFrontMatter checks for a json object at the very top of the
+// content, the object needs to be complete before the first blank line
+// so pages that just start with a `{` are left alone
+func parseJSONFrontMatter(content []byte) (meta map[string]interface{}, rest []byte, ok bool) {
+	if !bytes.HasPrefix(content, []byte("{")) {
+		return nil, content, false
+	}
+
+	block := content
+	if loc := blankLinePattern.FindIndex(content); loc != nil {
+		block = content[:loc[0]]
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(block))
+	if err := decoder.Decode(&meta); err != nil {
+		return nil, content, false
+	}
+
+	offset := int(decoder.InputOffset())
+	if len(bytes.TrimSpace(block[offset:])) != 0 {
+		return nil, content, false
+	}
+
+	return meta, content[offset:], true
+}
+
+// ParseMeta reads the front matter of the file on top
+// of the defaults from the config that match it
+func (af *AlvuFile) ParseMeta() error {
+	if err := af.parseFrontMatter(); err != nil {
+		return err
+	}
+
+	var defaults map[string]interface{}
+	for _, metaDefault := range af.alvu.config.Defaults {
+		if metaDefault.Matches(af.name) {
+			defaults = mergeMeta(defaults, metaDefault.Meta)
+		}
+	}
+	if defaults != nil {
+		af.meta = mergeMeta(defaults, af.meta)
+	}
+	return nil
+}
+
+func (af *AlvuFile) parseFrontMatter() error {
+	// the file is read again by the watcher, so the meta
+	// of front matter that has since been removed is cleared
+	af.meta = map[string]interface{}{}
+
+	for _, format := range frontMatterFormats {
+		if !bytes.HasPrefix(af.content, format.sep) {
+			continue
+		}
+
+		metaParts := bytes.SplitN(af.content, format.sep, 3)
+		if len(metaParts) < 3 {
+			return fmt.Errorf("missing closing `%s` for the %s front matter in %v", format.sep, format.name, af.sourcePath)
+		}
+
+		var meta map[string]interface{}
+		err := format.unmarshal(metaParts[1], &meta)
+		if err != nil {
+			return fmt.Errorf("invalid %s front matter in %v, error: %v", format.name, af.sourcePath, err)
+		}
+
+		if meta != nil {
+			af.meta = meta
+		}
+		af.writeableContent = metaParts[2]
+		return nil
+	}
+
+	if meta, rest, ok := parseJSONFrontMatter(af.content); ok {
+		af.meta = meta
+		af.writeableContent = rest
+		return nil
+	}
+
+	af.writeableContent = af.content
+	return nil
+}
+
+// ShouldSkip checks the meta of the file to see if it
+// needs to be left out of the build
+func (af *AlvuFile) ShouldSkip() (bool, string) {
+	if !af.alvu.config.Drafts && isTruthy(af.meta["draft"]) {
+		return true, "marked as draft"
+	}
+
+	if !af.alvu.config.Future && af.meta["date"] != nil {
+		date, ok := luaAlvu.ParseDate(af.meta["date"])
+		if !ok {
+			af.warn(fmt.Sprintf("invalid date `%v` in %v, including the page", af.meta["date"], af.sourcePath))
+		} else if date.After(time.Now()) {
+			return true, "dated in the future"
+		}
+	}
+
+	return false, ""
+}
+
+// ResolveLayout picks the layout named in the meta, or the
+// collection's layout, from the layouts directory, falls back
+// to the default `_layout.html`
+func (af *AlvuFile) ResolveLayout() {
+	af.layout = af.baseTemplate
+
+	layoutName, ok := af.meta["layout"].(string)
+	if (!ok || len(layoutName) == 0) && af.collection != nil {
+		layoutName = af.collection.Layout
+	}
+	if len(layoutName) == 0 {
+		return
+	}
+
+	layout, err := af.alvu.namedLayouts.Get(layoutName)
+	if err != nil {
+		af.warn("layout `" + layoutName + "` not found for " + af.sourcePath + ", using the default layout")
+		return
+	}
+
+	af.layout = layout
+}
+
+// warn logs the warning and keeps it for the build report
+func (af *AlvuFile) warn(msg string) {
+	warning := &color.ColorString{}
+	warning.Yellow(logPrefix).Yellow("[WARN] " + msg)
+	fmt.Println(warning.String())
+	af.warnings = append(af.warnings, msg)
+}
+
+// defaultTargetName is the name of the compiled file
+// before any changes from the hooks
+func (af *AlvuFile) defaultTargetName() string {
+	if len(af.permalink) > 0 {
+		return af.permalink
+	}
+	return markdownExtPattern.ReplaceAllString(af.outputName(), ".html")
+}
+
+// outputName is the name of the file relative to the output,
+// the same as the name unless the file is in a collection
+func (af *AlvuFile) outputName() string {
+	if af.collection == nil {
+		return af.name
+	}
+	return af.collection.OutputName(af.name)
+}
+
+var permalinkTokenPattern = regexp.MustCompile(`:[a-z_]+`)
+
+// resolvePermalink replaces the tokens in the `permalink`
+// of the meta and returns the name to write the file as,
+// a permalink ending with a `/` is written as an index.html
+// and one without an extension gets a `.html`
+func (af *AlvuFile) resolvePermalink() (string, error) {
+	permalink, ok := af.meta["permalink"].(string)
+	if !ok || len(strings.TrimSpace(permalink)) == 0 {
+		return "", nil
+	}
+
+	baseName := strings.TrimSuffix(filepath.Base(af.name), filepath.Ext(af.name))
+	title, ok := af.meta["title"].(string)
+	if !ok || len(strings.TrimSpace(title)) == 0 {
+		title = baseName
+	}
+
+	var tokenErr error
+	resolved := permalinkTokenPattern.ReplaceAllStringFunc(permalink, func(token string) string {
+		switch token {
+		case ":slug":
+			return luaAlvu.Slugify(title)
+		case ":title":
+			return strings.Join(strings.Fields(title), "-")
+		case ":year", ":month", ":day":
+			date, ok := luaAlvu.ParseDate(af.meta["date"])
+			if !ok {
+				if tokenErr == nil {
+					tokenErr = fmt.Errorf("permalink token `%v` in %v needs a valid date in the meta", token, af.sourcePath)
+				}
+				return token
+			}
+			switch token {
+			case ":year":
+				return date.Format("2006")
+			case ":month":
+				return date.Format("01")
+			}
+			return date.Format("02")
+		}
+		if tokenErr == nil {
+			tokenErr = fmt.Errorf("unknown permalink token `%v` in %v", token, af.sourcePath)
+		}
+		return token
+	})
+	if tokenErr != nil {
+		return "", tokenErr
+	}
+
+	if strings.HasSuffix(resolved, "/") {
+		resolved += "index.html"
+	} else if len(path.Ext(resolved)) == 0 {
+		resolved += ".html"
+	}
+	return strings.TrimPrefix(path.Clean("/"+resolved), "/"), nil
+}
+
+// targetFile is the path the file will be written
+// to with the given target name
+func (af *AlvuFile) targetFile(targetName string) string {
+	targetFile := strings.Replace(path.Join(af.destPath), af.outputName(), targetName, 1)
+	if af.alvu.config.PrettyURLs {
+		targetFile = prettyTargetFile(targetFile)
+	}
+	return targetFile
+}
+
+var markdownExtPattern = regexp.MustCompile(`\.md$`)
+
+func (af *AlvuFile) FlushFile() error {
+	targetFile := af.targetFile(string(af.targetName))
+
+	// the html pages only make up the single file
+	// when there is one and aren't written on their own
+	if af.alvu.singleFile != nil && filepath.Ext(targetFile) == ".html" {
+		if _, err := af.render(targetFile); err != nil {
+			return err
+		}
+		return af.copyAssets(targetFile)
+	}
+
+	os.MkdirAll(filepath.Dir(targetFile), os.ModePerm)
+	onDebug(func() {
+		debugInfo("flushing for file: " + af.name + string(af.targetName))
+		debugInfo("flusing file: " + targetFile)
+	})
+
+	if err := af.alvu.claimOutput(targetFile, af.sourcePath); err != nil {
+		return err
+	}
+
+	if af.alvu.sitemap != nil {
+		af.alvu.sitemap.AddFile(af, targetFile)
+	}
+
+	rendered, err := af.render(targetFile)
+	if err != nil {
+		return err
+	}
+	if err := af.alvu.writeOutput(targetFile, rendered); err != nil {
+		return err
+	}
+	if err := af.copyAssets(targetFile); err != nil {
+		return err
+	}
+
+	aliases, err := af.aliasFiles()
+	if err != nil {
+		return err
+	}
+	af.aliases = aliases
+	return af.writeAliases(targetFile, aliases)
+}
+
+// copyAssets copies the files from pages that the markdown
+// references relatively to where the page was written, so they
+// keep working when the page isn't written next to its source,
+// eg: with pretty urls or a permalink
+func (af *AlvuFile) copyAssets(targetFile string) error {
+	pageDir := path.Dir(filepath.ToSlash(af.name))
+	for _, ref := range af.assets {
+		assetName := path.Clean(pageDir + "/" + ref)
+		if assetName == ".." || strings.HasPrefix(assetName, "../") {
+			continue
+		}
+		// templated files are pages of their own
+		if Contains(af.alvu.config.TemplateExtensions, strings.ToLower(path.Ext(assetName))) {
+			continue
+		}
+		if ExcludePatterns(af.alvu.config.Exclude).MatchNested(assetName) {
+			continue
+		}
+
+		sourcePath := filepath.Join(af.alvu.pagesPath, filepath.FromSlash(assetName))
+		info, err := os.Stat(sourcePath)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		target := filepath.Join(filepath.Dir(targetFile), filepath.FromSlash(ref))
+		// the asset is already copied here with the rest of pages
+		if target == filepath.Join(af.alvu.outPath, filepath.FromSlash(assetName)) {
+			continue
+		}
+		if relPath, err := filepath.Rel(af.alvu.outPath, target); err != nil || strings.HasPrefix(relPath, "..") {
+			continue
+		}
+
+		claimed, err := af.alvu.claimAsset(target, sourcePath)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+
+		onDebug(func() {
+			debugInfo("copying asset: " + assetName + " for " + af.name)
+		})
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return err
+		}
+		if err := cp.Copy(sourcePath, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// aliasFiles resolves the `aliases` in the meta to the files
+// the redirects are written to, the aliases are relative to
+// the root of the site, an alias ending with a `/` or without
+// an extension is written as an index.html in that directory
+func (af *AlvuFile) aliasFiles() ([]string, error) {
+	var aliases []interface{}
+	switch value := af.meta["aliases"].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		aliases = []interface{}{value}
+	case []interface{}:
+		aliases = value
+	default:
+		return nil, fmt.Errorf("invalid `aliases` in %v, use a list of paths", af.sourcePath)
+	}
+
+	files := []string{}
+	for _, alias := range aliases {
+		aliasPath, ok := alias.(string)
+		if !ok || len(strings.TrimSpace(aliasPath)) == 0 {
+			return nil, fmt.Errorf("invalid alias `%v` in %v, use a path like /old-page/", alias, af.sourcePath)
+		}
+		if strings.Contains(aliasPath, "://") || strings.HasPrefix(aliasPath, "//") {
+			return nil, fmt.Errorf("invalid alias `%v` in %v, aliases are paths on the site", aliasPath, af.sourcePath)
+		}
+		aliasName := path.Clean("/" + aliasPath)
+		if strings.HasSuffix(aliasPath, "/") || len(path.Ext(aliasName)) == 0 {
+			aliasName = path.Join(aliasName, "index.html")
+		}
+		files = append(files, filepath.Join(af.alvu.outPath, filepath.FromSlash(strings.TrimPrefix(aliasName, "/"))))
+	}
+	return files, nil
+}
+
+const aliasTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Redirecting to %[1]s</title>
+<link rel="canonical" href="%[1]s">
+<meta name="robots" content="noindex">
+<meta http-equiv="refresh" content="0; url=%[1]s">
+</head>
+<body><a href="%[1]s">Redirecting to %[1]s</a></body>
+</html>
+`
+
+// writeAliases writes a redirect to the page at each of the
+// alias files, fails if an alias would overwrite a page, another
+// alias or a file from public
+func (af *AlvuFile) writeAliases(targetFile string, aliasFiles []string) error {
+	if len(aliasFiles) == 0 {
+		return nil
+	}
+	pageURL, err := af.alvu.outputURL(targetFile)
+	if err != nil {
+		return err
+	}
+
+	for _, aliasFile := range aliasFiles {
+		if aliasFile == targetFile {
+			return fmt.Errorf("the alias %v of %v is the page itself", aliasFile, af.sourcePath)
+		}
+		if err := af.alvu.claimOutput(aliasFile, af.sourcePath); err != nil {
+			return err
+		}
+		if relPath, err := filepath.Rel(af.alvu.outPath, aliasFile); err == nil && len(af.alvu.publicPath) > 0 {
+			if _, err := os.Stat(filepath.Join(af.alvu.publicPath, relPath)); err == nil {
+				return fmt.Errorf("the alias %v of %v would overwrite %v from public", aliasFile, af.sourcePath, relPath)
+			}
+		}
+
+		onDebug(func() {
+			debugInfo("writing alias: " + aliasFile + " for " + af.name)
+		})
+		stub := fmt.Sprintf(aliasTemplate, template.HTMLEscapeString(pageURL))
+		if err := af.alvu.writeOutput(aliasFile, []byte(stub)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package alvu
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrettyURLs(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"pages/index.md":      "# Home",
+		"pages/foo.md":        "# Foo",
+		"pages/blog/index.md": "# Blog",
+		"pages/blog/post.md":  "# Post",
+		"pages/404.html":      "<p>Not found</p>",
+	})
+	if err := buildSite(t, dir, Config{PrettyURLs: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"index.html", "foo/index.html", "blog/index.html", "blog/post/index.html", "404.html"} {
+		if _, err := os.Stat(filepath.Join(dir, "dist", name)); err != nil {
+			t.Errorf("expected %v to be built, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"foo.html", "blog/post.html", "404/index.html"} {
+		if _, err := os.Stat(filepath.Join(dir, "dist", name)); err == nil {
+			t.Errorf("expected %v to not be built", name)
+		}
+	}
+}
+
+func TestPrettyTargetFile(t *testing.T) {
+	tests := map[string]string{
+		"dist/about.html":      "dist/about/index.html",
+		"dist/index.html":      "dist/index.html",
+		"dist/blog/index.html": "dist/blog/index.html",
+		"dist/blog/a.html":     "dist/blog/a/index.html",
+		"dist/robots.txt":      "dist/robots.txt",
+		"dist/feed/custom.xml": "dist/feed/custom.xml",
+	}
+	for targetFile, expected := range tests {
+		if got := prettyTargetFile(targetFile); got != expected {
+			t.Errorf("prettyTargetFile(%q) = %q, expected %q", targetFile, got, expected)
+		}
+	}
+}
+
+func TestShorterSecondPassLeavesNoTrailingBytes(t *testing.T) {
+	// the first pass writes the comment out as an action, which
+	// the final pass over the whole page then leaves out
+	dir := writeSite(t, map[string]string{
+		"pages/page.html": `<p>{{"{{"}}/* a comment that's a lot longer than what's left of the page */{{"}}"}}end</p>`,
+	})
+	if err := buildSite(t, dir, Config{NoCache: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if page := readOutput(t, dir, "page.html"); page != "<body><p>end</p></body>" {
+		t.Errorf("expected just the rendered page, got %q", page)
+	}
+}
+
+func TestRebuildOverLongerOutputTruncatesIt(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"pages/page.md":  "# Short",
+		"dist/page.html": strings.Repeat("<p>left over from an earlier build</p>\n", 100),
+	})
+	if err := buildSite(t, dir, Config{NoCache: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<body><h1 id="short">Short</h1>
+</body>`
+	if page := readOutput(t, dir, "page.html"); page != expected {
+		t.Errorf("expected %q, got %q", expected, page)
+	}
+}
+
+func TestAliasesRedirectToThePage(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"pages/blog/new.md": "---\naliases: [/old-path/, /older.html]\n---\nmoved",
+	})
+	if err := buildSite(t, dir, Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"old-path/index.html", "older.html"} {
+		stub := readOutput(t, dir, name)
+		for _, want := range []string{
+			`<link rel="canonical" href="/blog/new.html">`,
+			`<meta http-equiv="refresh" content="0; url=/blog/new.html">`,
+		} {
+			if !strings.Contains(stub, want) {
+				t.Errorf("%v = %q, want it to contain %q", name, stub, want)
+			}
+		}
+	}
+}
+
+func TestAliasesDontOverwriteContent(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+	}{
+		{"page", map[string]string{
+			"pages/new.md":   "---\naliases: [/about.html]\n---\nmoved",
+			"pages/about.md": "about",
+		}},
+		{"alias", map[string]string{
+			"pages/a.md": "---\naliases: [/old/]\n---\na",
+			"pages/b.md": "---\naliases: [/old/]\n---\nb",
+		}},
+		{"itself", map[string]string{
+			"pages/new.md": "---\naliases: [/new.html]\n---\nnew",
+		}},
+		{"public", map[string]string{
+			"pages/new.md":    "---\naliases: [/old.html]\n---\nmoved",
+			"public/old.html": "kept",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeSite(t, tt.files)
+			if err := buildSite(t, dir, Config{}); err == nil {
+				t.Errorf("Build() with an alias over a %v, want an error", tt.name)
+			}
+		})
+	}
+}
@@ -0,0 +1,424 @@
+package alvu
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/barelyhuman/go/color"
+	cp "github.com/otiai10/copy"
+)
+
+func (al *Alvu) CopyPublic() error {
+	onDebug(func() {
+		debugInfo("Before copying files")
+		memuse()
+	})
+	// copy public to out
+	_, err := os.Stat(al.publicPath)
+	if err == nil {
+		excludes := ExcludePatterns(al.config.Exclude)
+		if err := copyDir(al.publicPath, al.outPath, al.jobs, excludes, al.config.FollowSymlinks); err != nil {
+			return err
+		}
+		if al.config.Minify {
+			if err := minifyCopiedFiles(al.publicPath, al.outPath, excludes); err != nil {
+				return err
+			}
+		}
+		if al.assetManifest != nil {
+			if err := al.assetManifest.Fingerprint(al.publicPath, al.outPath, excludes); err != nil {
+				return err
+			}
+		}
+	}
+	onDebug(func() {
+		debugInfo("After copying files")
+		memuse()
+	})
+	return nil
+}
+
+// copyDir copies the directory to the outDir with a pool of
+// `jobs` workers, the directories are created while walking so
+// the workers only ever copy files. Symlinks are linked to the
+// same target unless followSymlinks is set, in which case what
+// they point to is copied instead
+func copyDir(sourceDir string, outDir string, jobs int, excludes ExcludePatterns, followSymlinks bool) error {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	type copyJob struct {
+		source string
+		target string
+		mode   fs.FileMode
+	}
+
+	var copyErr error
+	errLock := &sync.Mutex{}
+	setErr := func(err error) {
+		errLock.Lock()
+		defer errLock.Unlock()
+		if copyErr == nil {
+			copyErr = err
+		}
+	}
+	hasErr := func() bool {
+		errLock.Lock()
+		defer errLock.Unlock()
+		return copyErr != nil
+	}
+	// stops the walk once a copy has failed
+	errStopped := errors.New("copy stopped")
+
+	queue := make(chan copyJob)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				if err := copyFileMode(job.source, job.target, job.mode); err != nil {
+					setErr(err)
+				}
+			}
+		}()
+	}
+
+	// the followed directories are tracked so a link
+	// to a parent directory doesn't loop forever
+	visited := map[string]bool{}
+	if resolved, err := filepath.EvalSymlinks(sourceDir); err == nil {
+		visited[resolved] = true
+	}
+
+	var walk func(dirPath string, targetDir string) error
+	walk = func(dirPath string, targetDir string) error {
+		return filepath.WalkDir(dirPath, func(filePath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if hasErr() {
+				return errStopped
+			}
+
+			relPath, err := filepath.Rel(dirPath, filePath)
+			if err != nil {
+				return err
+			}
+			target := filepath.Join(targetDir, relPath)
+
+			if excludes.MatchIn(outDir, target) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case d.Type()&fs.ModeSymlink != 0:
+				if !followSymlinks {
+					return copySymlink(filePath, target)
+				}
+				resolved, err := filepath.EvalSymlinks(filePath)
+				if err != nil {
+					return err
+				}
+				resolvedInfo, err := os.Stat(resolved)
+				if err != nil {
+					return err
+				}
+				if resolvedInfo.IsDir() {
+					if visited[resolved] {
+						return nil
+					}
+					visited[resolved] = true
+					return walk(resolved, target)
+				}
+				queue <- copyJob{source: resolved, target: target, mode: resolvedInfo.Mode()}
+			case d.IsDir():
+				// a link left by an earlier build would have the
+				// files written into wherever it points to
+				if err := removeSymlink(target); err != nil {
+					return err
+				}
+				return os.MkdirAll(target, info.Mode().Perm()|0700)
+			case info.Mode().IsRegular():
+				queue <- copyJob{source: filePath, target: target, mode: info.Mode()}
+			}
+			// anything else (sockets, devices) is skipped
+			return nil
+		})
+	}
+
+	walkErr := walk(sourceDir, outDir)
+	close(queue)
+	wg.Wait()
+
+	if walkErr != nil && walkErr != errStopped {
+		return walkErr
+	}
+	return copyErr
+}
+
+// copyFileMode copies the file with the same permissions,
+// writable by the owner so the next build can replace it
+func copyFileMode(source string, target string, mode fs.FileMode) (err error) {
+	if err := removeSymlink(target); err != nil {
+		return err
+	}
+
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm()|0200)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	// the mode is only used when the file is created
+	return out.Chmod(mode.Perm() | 0200)
+}
+
+// copySymlink links the target to wherever the
+// source link points to
+func copySymlink(source string, target string) error {
+	link, err := os.Readlink(source)
+	if err != nil {
+		return err
+	}
+	if current, err := os.Readlink(target); err == nil && current == link {
+		return nil
+	}
+	if err := os.RemoveAll(target); err != nil {
+		return err
+	}
+	return os.Symlink(link, target)
+}
+
+// removeSymlink removes the path if it's a symlink
+func removeSymlink(filePath string) error {
+	info, err := os.Lstat(filePath)
+	if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		return nil
+	}
+	return os.Remove(filePath)
+}
+
+// CopyPublicFile copies a single file from public to
+// the output, used when only that file has changed
+func (al *Alvu) CopyPublicFile(filePath string) error {
+	relPath, err := filepath.Rel(al.publicPath, filePath)
+	if err != nil {
+		return err
+	}
+	if ExcludePatterns(al.config.Exclude).MatchNested(filepath.ToSlash(relPath)) {
+		return nil
+	}
+
+	target := filepath.Join(al.outPath, relPath)
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+	if err := cp.Copy(filePath, target); err != nil {
+		return err
+	}
+	if al.config.Minify {
+		return minifyFile(target)
+	}
+	return nil
+}
+
+// CleanOutPath removes the contents of the output directory
+// but keeps the directory itself, refuses to touch anything
+// that contains the project or the current directory
+func CleanOutPath(outPath string, basePath string, dryRun bool) error {
+	absOutPath, err := filepath.Abs(outPath)
+	if err != nil {
+		return err
+	}
+
+	protectedPaths := []string{basePath, "."}
+	if home, err := os.UserHomeDir(); err == nil {
+		protectedPaths = append(protectedPaths, home)
+	}
+
+	for _, protectedPath := range protectedPaths {
+		absProtectedPath, err := filepath.Abs(protectedPath)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(absOutPath, absProtectedPath)
+		if err != nil {
+			continue
+		}
+		if rel == "." || !strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("refusing to clean %v since it contains %v", outPath, protectedPath)
+		}
+	}
+
+	entries, err := os.ReadDir(outPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		entryPath := filepath.Join(outPath, entry.Name())
+		if dryRun {
+			cs := &color.ColorString{}
+			fmt.Println(cs.Blue(logPrefix).Yellow("Would remove: ").Gray(entryPath).String())
+			continue
+		}
+		if err := os.RemoveAll(entryPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func CollectFilesToProcess(basepath string, excludes ExcludePatterns) ([]string, error) {
+	return collectFiles(basepath, basepath, excludes)
+}
+
+func collectFiles(rootPath string, basepath string, excludes ExcludePatterns) ([]string, error) {
+	files := []string{}
+
+	pathstoprocess, err := os.ReadDir(basepath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pages from %v, error: %v", basepath, err)
+	}
+
+	for _, pathInfo := range pathstoprocess {
+		_path := path.Join(basepath, pathInfo.Name())
+
+		if Contains(reservedFiles, pathInfo.Name()) {
+			continue
+		}
+
+		if excludes.MatchIn(rootPath, _path) {
+			continue
+		}
+
+		if pathInfo.IsDir() {
+			nestedFiles, err := collectFiles(rootPath, _path, excludes)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, nestedFiles...)
+		} else {
+			files = append(files, _path)
+		}
+
+	}
+
+	return files, nil
+}
+
+// ExcludePatterns are glob patterns with the same syntax
+// as `path.Match` along with `**` for any number of
+// directories, a pattern without a `/` is matched against
+// the name of the file at any depth
+type ExcludePatterns []string
+
+// Validate checks if all the patterns are valid
+func (ep ExcludePatterns) Validate() error {
+	for _, pattern := range ep {
+		for _, segment := range strings.Split(pattern, "/") {
+			if _, err := path.Match(segment, ""); err != nil {
+				return fmt.Errorf("invalid exclude pattern `%v`, error: %v", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// MatchIn checks if the filePath, relative to the rootPath,
+// matches any of the patterns
+func (ep ExcludePatterns) MatchIn(rootPath string, filePath string) bool {
+	if len(ep) == 0 {
+		return false
+	}
+	relPath, err := filepath.Rel(rootPath, filePath)
+	if err != nil || relPath == "." {
+		return false
+	}
+	return ep.Match(filepath.ToSlash(relPath))
+}
+
+// Match checks if the slash separated relative
+// path matches any of the patterns
+func (ep ExcludePatterns) Match(relPath string) bool {
+	for _, pattern := range ep {
+		pattern = strings.Trim(pattern, "/")
+		if !strings.Contains(pattern, "/") && pattern != "**" {
+			if ok, _ := path.Match(pattern, path.Base(relPath)); ok {
+				return true
+			}
+			continue
+		}
+		if matchSegments(strings.Split(pattern, "/"), strings.Split(relPath, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchNested checks the relative path along with all
+// of it's parent directories, for when the path isn't
+// reached by walking down from the root
+func (ep ExcludePatterns) MatchNested(relPath string) bool {
+	for current := relPath; current != "." && current != "/"; current = path.Dir(current) {
+		if ep.Match(current) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(patterns []string, segments []string) bool {
+	if len(patterns) == 0 {
+		return len(segments) == 0
+	}
+	if patterns[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(patterns[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(patterns[0], segments[0]); !ok {
+		return false
+	}
+	return matchSegments(patterns[1:], segments[1:])
+}
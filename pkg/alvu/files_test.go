@@ -0,0 +1,72 @@
+package alvu
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExcludePatternsMatch(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		relPath  string
+		expected bool
+	}{
+		{"*.tmp", "notes.tmp", true},
+		{"*.tmp", "blog/2023/notes.tmp", true},
+		{"*.tmp", "notes.md", false},
+		{"drafts/**", "drafts/post.md", true},
+		{"drafts/**", "drafts/2023/post.md", true},
+		{"drafts/**", "blog/drafts/post.md", false},
+		{"**/drafts/**", "blog/drafts/post.md", true},
+		{"blog/*.md", "blog/post.md", true},
+		{"blog/*.md", "blog/2023/post.md", false},
+		{"blog/**/*.md", "blog/2023/01/post.md", true},
+		{"about.md", "about.md", true},
+		{"about.md", "blog/about.md", true},
+		{"/about.md/", "about.md", true},
+	}
+	for _, test := range tests {
+		if got := ExcludePatterns([]string{test.pattern}).Match(test.relPath); got != test.expected {
+			t.Errorf("`%v` matching %v = %v, expected %v", test.pattern, test.relPath, got, test.expected)
+		}
+	}
+}
+
+func TestExcludePatternsValidate(t *testing.T) {
+	if err := ExcludePatterns([]string{"drafts/**", "*.tmp"}).Validate(); err != nil {
+		t.Errorf("expected the patterns to be valid, got %v", err)
+	}
+	if err := ExcludePatterns([]string{"drafts/[a"}).Validate(); err == nil {
+		t.Errorf("expected an error for the unclosed bracket")
+	}
+}
+
+func TestExcludedFilesAreNotBuiltOrCopied(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"pages/index.md":            "# Home",
+		"pages/notes.tmp":           "scratch",
+		"pages/drafts/post.md":      "# Draft",
+		"pages/drafts/2023/old.md":  "# Old draft",
+		"pages/blog/post.md":        "# Post",
+		"public/styles.css":         "body {}",
+		"public/drafts/preview.png": "png",
+		"public/vendor/lib.tmp":     "scratch",
+		"public/vendor/lib.js":      "js",
+	})
+	err := buildSite(t, dir, Config{Exclude: []string{"drafts/**", "*.tmp"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"index.html", "blog/post.html", "styles.css", "vendor/lib.js"} {
+		if _, err := os.Stat(filepath.Join(dir, "dist", name)); err != nil {
+			t.Errorf("expected %v in the output, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"notes.tmp", "drafts", "vendor/lib.tmp"} {
+		if _, err := os.Stat(filepath.Join(dir, "dist", name)); err == nil {
+			t.Errorf("expected %v to be excluded", name)
+		}
+	}
+}
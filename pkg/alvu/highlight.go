@@ -0,0 +1,97 @@
+package alvu
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alecthomas/chroma"
+	chromahtml "github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/styles"
+)
+
+// highlightStyle returns the built in style with the given
+// name or loads it from the file if it's a path to a theme
+func highlightStyle(theme string) (*chroma.Style, error) {
+	if isThemeFile(theme) {
+		return loadHighlightTheme(theme)
+	}
+	return styles.Get(theme), nil
+}
+
+// writeHighlightCSS writes the css classes for the given style
+func (al *Alvu) writeHighlightCSS(targetFile string, style *chroma.Style, formatOptions []chromahtml.Option) error {
+	var css bytes.Buffer
+	err := chromahtml.New(formatOptions...).WriteCSS(&css, style)
+	if err != nil {
+		return err
+	}
+
+	return al.writeOutput(targetFile, css.Bytes())
+}
+
+// isThemeFile checks if the highlight theme is a path
+// to a theme file instead of the name of a built in theme
+func isThemeFile(theme string) bool {
+	if filepath.Ext(theme) == ".xml" {
+		return true
+	}
+	info, err := os.Stat(theme)
+	return err == nil && !info.IsDir()
+}
+
+// highlightThemeFile is the chroma xml style definition
+//
+//	<style name="mytheme">
+//		<entry type="Background" style="bg:#ffffff"/>
+//		<entry type="Keyword" style="bold #000080"/>
+//	</style>
+type highlightThemeFile struct {
+	Name    string `xml:"name,attr"`
+	Entries []struct {
+		Type  string `xml:"type,attr"`
+		Style string `xml:"style,attr"`
+	} `xml:"entry"`
+}
+
+// loadHighlightTheme reads a chroma style from the given
+// xml file
+func loadHighlightTheme(themePath string) (*chroma.Style, error) {
+	content, err := os.ReadFile(themePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read highlight theme %v, error: %v", themePath, err)
+	}
+
+	themeFile := highlightThemeFile{}
+	if err := xml.Unmarshal(content, &themeFile); err != nil {
+		return nil, fmt.Errorf("invalid highlight theme %v, error: %v", themePath, err)
+	}
+
+	tokenTypes := map[string]chroma.TokenType{}
+	for tokenType := range chroma.StandardTypes {
+		tokenTypes[tokenType.String()] = tokenType
+	}
+
+	name := themeFile.Name
+	if len(name) == 0 {
+		name = strings.TrimSuffix(filepath.Base(themePath), filepath.Ext(themePath))
+	}
+
+	entries := chroma.StyleEntries{}
+	for _, entry := range themeFile.Entries {
+		tokenType, ok := tokenTypes[entry.Type]
+		if !ok {
+			return nil, fmt.Errorf("invalid highlight theme %v, unknown token type `%v`", themePath, entry.Type)
+		}
+		entries[tokenType] = entry.Style
+	}
+
+	style, err := chroma.NewStyle(name, entries)
+	if err != nil {
+		return nil, fmt.Errorf("invalid highlight theme %v, error: %v", themePath, err)
+	}
+	return style, nil
+}
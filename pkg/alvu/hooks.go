@@ -0,0 +1,511 @@
+package alvu
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	luaAlvu "github.com/barelyhuman/alvu/lua/alvu"
+	ghttp "github.com/cjoudrey/gluahttp"
+	stringsLib "github.com/vadv/gopher-lua-libs/strings"
+	yamlLib "github.com/vadv/gopher-lua-libs/yaml"
+	lua "github.com/yuin/gopher-lua"
+	luajson "layeh.com/gopher-json"
+)
+
+// setHookPages makes the pages available to the
+// hooks with `alvu.pages()`
+func (al *Alvu) setHookPages(hooks HookCollection) error {
+	pagesJSON, err := json.Marshal(al.pages)
+	if err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		if err := luaAlvu.SetPages(hook.state, pagesJSON); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReloadHooks closes the existing lua states and
+// loads the hooks again from the hooks directory
+func (al *Alvu) ReloadHooks() error {
+	al.hooks.Shutdown()
+	hooks, err := CollectHooks(al.basePath, al.hooksPath)
+	if err != nil {
+		al.hooks = HookCollection{}
+		return err
+	}
+	al.hooks = hooks
+	return al.hooks.RunAll("OnStart")
+}
+
+func CollectHooks(basePath, hooksBasePath string) (HookCollection, error) {
+	hookCollection := HookCollection{}
+	if _, err := os.Stat(hooksBasePath); err != nil {
+		return hookCollection, nil
+	}
+	pathsToProcess, err := os.ReadDir(hooksBasePath)
+	if err != nil {
+		return hookCollection, fmt.Errorf("failed to read hooks from %v, error: %v", hooksBasePath, err)
+	}
+
+	for _, pathInfo := range pathsToProcess {
+		if !strings.HasSuffix(pathInfo.Name(), ".lua") {
+			continue
+		}
+		hook := NewHook(basePath)
+		hookPath := path.Join(hooksBasePath, pathInfo.Name())
+		if err := hook.DoFile(hookPath); err != nil {
+			hook.Close()
+			hookCollection.Shutdown()
+			return HookCollection{}, fmt.Errorf("failed to load hook %v, error: %v", hookPath, err)
+		}
+		hookCollection = append(hookCollection, &Hook{
+			path:  hookPath,
+			state: hook,
+		})
+	}
+
+	// hooks run in the order of their `Priority`, lower first,
+	// and by their file name when the priority is the same
+	sort.SliceStable(hookCollection, func(i, j int) bool {
+		left, right := hookCollection[i], hookCollection[j]
+		if left.Priority() != right.Priority() {
+			return left.Priority() < right.Priority()
+		}
+		return left.path < right.path
+	})
+
+	return hookCollection, nil
+}
+
+type Hook struct {
+	path  string
+	state *lua.LState
+	// globals is the snapshot of an `Isolated`
+	// hook's globals, see Isolate
+	globals map[lua.LValue]lua.LValue
+}
+
+// defaultHookPriority is used for hooks that don't
+// define a `Priority`
+const defaultHookPriority = 0
+
+// Priority returns the `Priority` global of the hook
+func (h *Hook) Priority() float64 {
+	if priority, ok := h.state.GetGlobal("Priority").(lua.LNumber); ok {
+		return float64(priority)
+	}
+	return defaultHookPriority
+}
+
+// Isolated returns the `Isolated` global of the hook
+func (h *Hook) Isolated() bool {
+	return lua.LVAsBool(h.state.GetGlobal("Isolated"))
+}
+
+// Isolate is called before every file and resets the globals
+// of an `Isolated` hook to what they were before the first
+// file, so globals added or changed while handling one file
+// aren't seen by the next. Only the globals themselves are
+// restored, a table that's changed in place stays changed
+func (h *Hook) Isolate() {
+	if h.globals == nil && !h.Isolated() {
+		return
+	}
+
+	globals := h.state.G.Global
+	if h.globals == nil {
+		h.globals = map[lua.LValue]lua.LValue{}
+		globals.ForEach(func(key lua.LValue, value lua.LValue) {
+			h.globals[key] = value
+		})
+		return
+	}
+
+	added := []lua.LValue{}
+	globals.ForEach(func(key lua.LValue, _ lua.LValue) {
+		if _, ok := h.globals[key]; !ok {
+			added = append(added, key)
+		}
+	})
+	for _, key := range added {
+		globals.RawSet(key, lua.LNil)
+	}
+	for key, value := range h.globals {
+		globals.RawSet(key, value)
+	}
+}
+
+type HookCollection []*Hook
+
+func (hc HookCollection) Shutdown() {
+	for _, hook := range hc {
+		hook.state.Close()
+	}
+}
+
+// Clone loads a new lua state for each hook in the collection,
+// used to give every build worker its own set of hooks since
+// `OnStart` and `OnFinish` only run on the original collection
+func (hc HookCollection) Clone(basePath string) (HookCollection, error) {
+	clone := HookCollection{}
+	for _, hook := range hc {
+		state := NewHook(basePath)
+		if err := state.DoFile(hook.path); err != nil {
+			state.Close()
+			clone.Shutdown()
+			return nil, fmt.Errorf("failed to load hook %v, error: %v", hook.path, err)
+		}
+		clone = append(clone, &Hook{
+			path:  hook.path,
+			state: state,
+		})
+	}
+	return clone, nil
+}
+
+// Defines checks if any of the hooks has the function
+func (hc HookCollection) Defines(funcName string) bool {
+	for _, hook := range hc {
+		if _, ok := hook.state.GetGlobal(funcName).(*lua.LFunction); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (hc HookCollection) RunAll(funcName string) error {
+	for _, hook := range hc {
+		hookFunc := hook.state.GetGlobal(funcName)
+
+		if hookFunc == lua.LNil {
+			continue
+		}
+
+		if err := hook.state.CallByParam(lua.P{
+			Fn:      hookFunc,
+			NRet:    0,
+			Protect: true,
+		}); err != nil {
+			return fmt.Errorf("failed to run %v hook in %v, error: %v", funcName, hook.path, err)
+		}
+	}
+	return nil
+}
+
+func (af *AlvuFile) ProcessFile(hook *lua.LState) error {
+	// pre process hook => should return back json with `content` and `data`
+	af.lock.Lock()
+	defer af.lock.Unlock()
+
+	af.targetName = []byte(af.defaultTargetName())
+	onDebug(func() {
+		debugInfo(af.name + " will be changed to " + string(af.targetName))
+	})
+
+	buf := bytes.NewBuffer([]byte(""))
+	mdToHTML := ""
+
+	if filepath.Ext(af.name) == ".md" {
+		af.alvu.mdProcessor.Convert(af.writeableContent, buf)
+		mdToHTML = buf.String()
+	}
+
+	if hook == nil {
+		return nil
+	}
+
+	hookInput := struct {
+		Name             string                 `json:"name"`
+		SourcePath       string                 `json:"source_path"`
+		DestPath         string                 `json:"dest_path"`
+		Meta             map[string]interface{} `json:"meta"`
+		WriteableContent string                 `json:"content"`
+		HTMLContent      string                 `json:"html"`
+		Site             map[string]interface{} `json:"site"`
+		Pages            []Page                 `json:"pages"`
+		Collection       string                 `json:"collection,omitempty"`
+	}{
+		Name:             string(af.targetName),
+		SourcePath:       af.sourcePath,
+		DestPath:         af.destPath,
+		Meta:             af.meta,
+		WriteableContent: string(af.writeableContent),
+		HTMLContent:      mdToHTML,
+		Site:             af.alvu.siteData,
+		Pages:            af.alvu.pages,
+	}
+	if af.collection != nil {
+		hookInput.Collection = af.collection.Name
+	}
+
+	hookJsonInput, err := json.Marshal(hookInput)
+	if err != nil {
+		return err
+	}
+
+	if hook.GetGlobal("BeforeFile") != lua.LNil || hook.GetGlobal("Writer") != lua.LNil {
+		af.hooked = true
+	}
+
+	// `BeforeFile` gets the same input as the `Writer` but
+	// can only add to the `data` and `extras` of the file
+	if beforeFile := hook.GetGlobal("BeforeFile"); beforeFile != lua.LNil {
+		if err := af.runBeforeFile(hook, beforeFile, hookJsonInput); err != nil {
+			return err
+		}
+	}
+
+	writer := hook.GetGlobal("Writer")
+	if writer == lua.LNil {
+		return nil
+	}
+
+	if err := hook.CallByParam(lua.P{
+		Fn:      writer,
+		NRet:    1,
+		Protect: true,
+	}, lua.LString(hookJsonInput)); err != nil {
+		return fmt.Errorf("failed to run Writer hook on %v, error: %v", af.sourcePath, err)
+	}
+
+	ret := hook.Get(-1)
+	defer hook.Pop(1)
+
+	// a list means the file is to be split into
+	// multiple files, one for each item
+	retString := strings.TrimSpace(ret.String())
+	if strings.HasPrefix(retString, "[") {
+		var fromPlugList []map[string]interface{}
+		err = json.Unmarshal([]byte(retString), &fromPlugList)
+		if err != nil {
+			return fmt.Errorf("invalid list returned by the hook for %v, error: %v", af.sourcePath, err)
+		}
+
+		for _, fromPlug := range fromPlugList {
+			if fromPlug["name"] == nil {
+				return fmt.Errorf("missing `name` in the list returned by the hook for %v", af.sourcePath)
+			}
+			derived := *af
+			derived.lock = &sync.Mutex{}
+			derived.fanout = nil
+			derived.applyHookOutput(fromPlug)
+			af.fanout = append(af.fanout, &derived)
+		}
+		return nil
+	}
+
+	var fromPlug map[string]interface{}
+
+	err = json.Unmarshal([]byte(retString), &fromPlug)
+	if err != nil {
+		return fmt.Errorf("invalid value returned by the hook for %v, error: %v", af.sourcePath, err)
+	}
+
+	af.applyHookOutput(fromPlug)
+	return nil
+}
+
+func (af *AlvuFile) runBeforeFile(hook *lua.LState, beforeFile lua.LValue, hookJsonInput []byte) error {
+	if err := hook.CallByParam(lua.P{
+		Fn:      beforeFile,
+		NRet:    1,
+		Protect: true,
+	}, lua.LString(hookJsonInput)); err != nil {
+		return fmt.Errorf("failed to run BeforeFile hook on %v, error: %v", af.sourcePath, err)
+	}
+
+	ret := hook.Get(-1)
+	defer hook.Pop(1)
+
+	if ret == lua.LNil {
+		return nil
+	}
+
+	var fromPlug map[string]interface{}
+	if err := json.Unmarshal([]byte(ret.String()), &fromPlug); err != nil {
+		return fmt.Errorf("invalid value returned by the BeforeFile hook for %v, error: %v", af.sourcePath, err)
+	}
+
+	af.applyHookOutput(map[string]interface{}{
+		"data":   fromPlug["data"],
+		"extras": fromPlug["extras"],
+	})
+	return nil
+}
+
+func (af *AlvuFile) applyHookOutput(fromPlug map[string]interface{}) {
+	if fromPlug["content"] != nil {
+		stringVal := fmt.Sprintf("%s", fromPlug["content"])
+		af.writeableContent = []byte(stringVal)
+	}
+
+	if fromPlug["name"] != nil {
+		af.targetName = []byte(fmt.Sprintf("%v", fromPlug["name"]))
+	}
+
+	if fromPlug["data"] != nil {
+		af.data = mergeMapWithCheck(af.data, fromPlug["data"])
+	}
+
+	if fromPlug["extras"] != nil {
+		af.extras = mergeMapWithCheck(af.extras, fromPlug["extras"])
+	}
+}
+
+// templateFuncNamePattern matches the names
+// that can be called from a template
+var templateFuncNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// loadHookTemplateFuncs reads the `TemplateFuncs` table of the
+// hooks into hookFuncs, eg:
+//
+//	TemplateFuncs = {
+//		shout = function(text) return string.upper(text) .. "!" end,
+//	}
+//
+// The templates of every worker share the functions so the hooks
+// that have them are loaded again, into lua states that are only
+// used by the templates, and the calls to a hook are run one at
+// a time. The templates hold on to the functions till they're
+// parsed again, which the watcher doesn't do for a single page,
+// so the states are kept in templateHooks till the next call
+// or till the hooks are shutdown
+func (al *Alvu) loadHookTemplateFuncs() error {
+	hookFuncs := map[string]interface{}{}
+	builtins := al.builtinTemplateFuncs()
+	definedBy := map[string]string{}
+
+	templateHooks := HookCollection{}
+	for _, hook := range al.hooks {
+		if _, ok := hook.state.GetGlobal("TemplateFuncs").(*lua.LTable); !ok {
+			continue
+		}
+		clone, err := HookCollection{hook}.Clone(al.basePath)
+		if err != nil {
+			templateHooks.Shutdown()
+			return err
+		}
+		templateHooks = append(templateHooks, clone...)
+	}
+	if err := al.setHookPages(templateHooks); err != nil {
+		templateHooks.Shutdown()
+		return err
+	}
+
+	for _, hook := range templateHooks {
+		funcs, ok := hook.state.GetGlobal("TemplateFuncs").(*lua.LTable)
+		if !ok {
+			continue
+		}
+		lock := &sync.Mutex{}
+		var err error
+		funcs.ForEach(func(key lua.LValue, value lua.LValue) {
+			if err != nil {
+				return
+			}
+			name, isString := key.(lua.LString)
+			fn, isFunction := value.(*lua.LFunction)
+			switch {
+			case !isString || !templateFuncNamePattern.MatchString(string(name)):
+				err = fmt.Errorf("invalid template function name `%v` in %v, use letters, digits and _", key, hook.path)
+			case !isFunction:
+				err = fmt.Errorf("template function `%v` in %v isn't a function", name, hook.path)
+			case builtins[string(name)] != nil:
+				err = fmt.Errorf("template function `%v` in %v is already built in", name, hook.path)
+			case len(definedBy[string(name)]) > 0:
+				err = fmt.Errorf("template function `%v` is in both %v and %v", name, definedBy[string(name)], hook.path)
+			default:
+				definedBy[string(name)] = hook.path
+				hookFuncs[string(name)] = hookTemplateFunc(hook, lock, string(name), fn)
+			}
+		})
+		if err != nil {
+			templateHooks.Shutdown()
+			return err
+		}
+	}
+
+	al.templateHooks.Shutdown()
+	al.templateHooks = templateHooks
+	al.hookFuncs = hookFuncs
+	return nil
+}
+
+// hookTemplateFunc calls the lua function with the arguments from
+// the template, both ways go through json like the rest of the
+// data passed to the hooks so tables come back as maps and lists
+func hookTemplateFunc(hook *Hook, lock *sync.Mutex, name string, fn *lua.LFunction) func(...interface{}) (interface{}, error) {
+	return func(args ...interface{}) (interface{}, error) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		L := hook.state
+		luaArgs := []lua.LValue{}
+		for _, arg := range args {
+			encoded, err := json.Marshal(arg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid argument for the template function %v, error: %v", name, err)
+			}
+			value, err := luajson.Decode(L, encoded)
+			if err != nil {
+				return nil, fmt.Errorf("invalid argument for the template function %v, error: %v", name, err)
+			}
+			luaArgs = append(luaArgs, value)
+		}
+
+		if err := L.CallByParam(lua.P{
+			Fn:      fn,
+			NRet:    1,
+			Protect: true,
+		}, luaArgs...); err != nil {
+			return nil, fmt.Errorf("failed to run the template function %v in %v, error: %v", name, hook.path, err)
+		}
+		ret := L.Get(-1)
+		L.Pop(1)
+
+		switch ret := ret.(type) {
+		case lua.LString:
+			return string(ret), nil
+		case *lua.LNilType:
+			return "", nil
+		}
+		encoded, err := luajson.Encode(ret)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value returned by the template function %v in %v, error: %v", name, hook.path, err)
+		}
+		var value interface{}
+		if err := json.Unmarshal(encoded, &value); err != nil {
+			return nil, fmt.Errorf("invalid value returned by the template function %v in %v, error: %v", name, hook.path, err)
+		}
+		return value, nil
+	}
+}
+
+func NewHook(basePath string) *lua.LState {
+	lState := lua.NewState()
+	luaAlvu.Preload(lState)
+	luaAlvu.PreloadEnv(lState)
+	luajson.Preload(lState)
+	yamlLib.Preload(lState)
+	stringsLib.Preload(lState)
+	lState.PreloadModule("http", ghttp.NewHttpModule(&http.Client{}).Loader)
+	luaAlvu.SetRoot(lState, basePath)
+	if basePath == "." {
+		lState.SetGlobal("workingdir", lua.LString(""))
+	} else {
+		lState.SetGlobal("workingdir", lua.LString(basePath))
+	}
+	return lState
+}
@@ -0,0 +1,191 @@
+package alvu
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const countingHook = `local json = require("json")
+
+Counter = 0
+
+function Writer(filedata)
+    local source = json.decode(filedata)
+    Counter = Counter + 1
+    source.content = source.content .. "\n\ncount " .. Counter .. " previous " .. tostring(Previous)
+    Previous = source.name
+    return json.encode(source)
+end`
+
+func TestIsolatedHooksDontLeakGlobals(t *testing.T) {
+	for _, isolated := range []bool{true, false} {
+		hook := countingHook
+		if isolated {
+			hook = "Isolated = true\n" + hook
+		}
+		dir := writeSite(t, map[string]string{
+			"hooks/count.lua": hook,
+			"pages/a.md":      "# A",
+			"pages/b.md":      "# B",
+		})
+		if err := buildSite(t, dir, Config{Jobs: 1, NoCache: true}); err != nil {
+			t.Fatal(err)
+		}
+
+		b := readOutput(t, dir, "b.html")
+		if isolated && !strings.Contains(b, "count 1 previous nil") {
+			t.Errorf("expected the isolated hook to start over for b.md, got %q", b)
+		}
+		if !isolated && !strings.Contains(b, "count 2 previous a.html") {
+			t.Errorf("expected the hook to keep its globals for b.md, got %q", b)
+		}
+	}
+}
+
+const templateFuncsHook = `TemplateFuncs = {
+    shout = function(text) return string.upper(text) .. "!" end,
+    words = function(text)
+        local words = {}
+        for word in string.gmatch(text, "%S+") do
+            table.insert(words, word)
+        end
+        return words
+    end,
+}`
+
+func TestHookTemplateFuncs(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"hooks/funcs.lua":    templateFuncsHook,
+		"pages/_layout.html": `{{shout "layout"}}|{{.Content}}`,
+		"pages/index.html":   "---\ntitle: index\n---" + `{{shout .Page.title}} {{range words "a b c"}}[{{.}}]{{end}}`,
+		"pages/post.md":      "---\ntitle: post\n---\n{{shout .Page.title}}",
+	})
+	// the pages are built at the same time to
+	// check that the calls to the hook are safe
+	if err := buildSite(t, dir, Config{Jobs: 8, NoCache: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := readOutput(t, dir, "index.html"), "LAYOUT!|INDEX! [a][b][c]"; got != want {
+		t.Errorf("index.html = %q, want %q", got, want)
+	}
+	if got, want := readOutput(t, dir, "post.html"), "LAYOUT!|<p>POST!</p>\n"; got != want {
+		t.Errorf("post.html = %q, want %q", got, want)
+	}
+}
+
+func TestHookTemplateFuncsAreChecked(t *testing.T) {
+	tests := []struct {
+		name  string
+		hooks map[string]string
+		want  string
+	}{
+		{"built in", map[string]string{
+			"hooks/a.lua": `TemplateFuncs = { slugify = function(text) return text end }`,
+		}, "a.lua is already built in"},
+		{"duplicate", map[string]string{
+			"hooks/a.lua": `TemplateFuncs = { shout = function(text) return text end }`,
+			"hooks/b.lua": `TemplateFuncs = { shout = function(text) return text end }`,
+		}, "`shout` is in both"},
+		{"not a function", map[string]string{
+			"hooks/a.lua": `TemplateFuncs = { shout = "loud" }`,
+		}, "isn't a function"},
+		{"invalid name", map[string]string{
+			"hooks/a.lua": `TemplateFuncs = { ["with-dash"] = function() end }`,
+		}, "invalid template function name `with-dash`"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{"pages/index.md": "index"}
+			for name, hook := range tt.hooks {
+				files[name] = hook
+			}
+			dir := writeSite(t, files)
+			err := buildSite(t, dir, Config{NoCache: true})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Build() = %v, want an error with %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHookTemplateFuncErrorsFailThePage(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"hooks/funcs.lua":  `TemplateFuncs = { fail = function() error("broken") end }`,
+		"pages/index.html": `{{fail}}`,
+	})
+	err := buildSite(t, dir, Config{NoCache: true})
+	if err == nil || !strings.Contains(err.Error(), "broken") || !strings.Contains(err.Error(), "index.html") {
+		t.Errorf("Build() = %v, want the error from the hook and the page", err)
+	}
+}
+
+const onStartHook = `local json = require("json")
+
+function OnStart()
+    Started = "started"
+end
+
+function Writer(filedata)
+    local source = json.decode(filedata)
+    source.content = source.content .. "\n\n" .. Started
+    return json.encode(source)
+end`
+
+func TestOnStartStateIsInEveryFileByDefault(t *testing.T) {
+	files := map[string]string{"hooks/start.lua": onStartHook}
+	for i := 0; i < 16; i++ {
+		files[fmt.Sprintf("pages/%02d.md", i)] = "# Page"
+	}
+	dir := writeSite(t, files)
+	if err := buildSite(t, dir, Config{NoCache: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 16; i++ {
+		name := fmt.Sprintf("%02d.html", i)
+		if page := readOutput(t, dir, name); !strings.Contains(page, "started") {
+			t.Errorf("expected %v to have the state from OnStart, got %q", name, page)
+		}
+	}
+}
+
+func TestFileJobsWithOnStart(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"hooks/start.lua": onStartHook,
+		"hooks/count.lua": countingHook,
+	})
+	withOnStart, err := CollectHooks(dir, filepath.Join(dir, "hooks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer withOnStart.Shutdown()
+	var withoutOnStart HookCollection
+	for _, hook := range withOnStart {
+		if filepath.Base(hook.path) == "count.lua" {
+			withoutOnStart = HookCollection{hook}
+		}
+	}
+
+	tests := []struct {
+		name       string
+		hooks      HookCollection
+		configJobs int
+		files      int
+		want       int
+	}{
+		{"default", withoutOnStart, 0, 100, 8},
+		{"default with OnStart", withOnStart, 0, 100, 1},
+		{"set with OnStart", withOnStart, 8, 100, 8},
+		{"fewer files", withoutOnStart, 0, 3, 3},
+		{"no files", withoutOnStart, 0, 0, 1},
+	}
+	for _, tt := range tests {
+		al := &Alvu{jobs: 8, hooks: tt.hooks, config: Config{Jobs: tt.configJobs}}
+		if got := al.fileJobs(tt.files); got != tt.want {
+			t.Errorf("%v: fileJobs(%v) = %v, want %v", tt.name, tt.files, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package alvu
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// checkLinks logs the broken links in the html
+// that was written and fails if there's any
+func (al *Alvu) checkLinks() error {
+	al.outputsLock.Lock()
+	pages := map[string]string{}
+	for target, sourcePath := range al.outputs {
+		if path.Ext(target) == ".html" {
+			pages[target] = sourcePath
+		}
+	}
+	al.outputsLock.Unlock()
+
+	checker := NewLinkChecker(al.outPath, al.config.BaseURL, al.config.CheckExternal, al.jobs)
+	broken, err := checker.Check(pages)
+	if err != nil {
+		return err
+	}
+	if len(broken) == 0 {
+		return nil
+	}
+	for _, link := range broken {
+		logError(fmt.Errorf("broken link `%v` in %v", link.Link, link.Source))
+	}
+	return fmt.Errorf("%v broken links found", len(broken))
+}
+
+// linkAttrs are the attributes of the tags
+// that the LinkChecker looks at
+var linkAttrs = map[string]string{
+	"a":      "href",
+	"link":   "href",
+	"img":    "src",
+	"script": "src",
+	"source": "src",
+	"iframe": "src",
+}
+
+// htmlLinks lists the links in the html, in order
+func htmlLinks(content []byte) []string {
+	tokenizer := xhtml.NewTokenizer(bytes.NewReader(content))
+	links := []string{}
+	for {
+		switch tokenizer.Next() {
+		case xhtml.ErrorToken:
+			return links
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			name, hasAttr := tokenizer.TagName()
+			attr, ok := linkAttrs[string(name)]
+			for ok && hasAttr {
+				var key, value []byte
+				key, value, hasAttr = tokenizer.TagAttr()
+				if string(key) == attr {
+					links = append(links, strings.TrimSpace(string(value)))
+				}
+			}
+		}
+	}
+}
+
+// LinkChecker looks for the links in the html pages that don't
+// resolve to a file in the output directory, the same way the
+// dev server would resolve them, and optionally the links to
+// other sites that don't respond with a success
+type LinkChecker struct {
+	outPath       string
+	baseURL       *url.URL
+	checkExternal bool
+	jobs          int
+	client        *http.Client
+}
+
+// BrokenLink is a link that didn't resolve and
+// the source of the page it's in
+type BrokenLink struct {
+	Source string
+	Link   string
+}
+
+// externalLinkTimeout is how long the LinkChecker
+// waits for each of the external links
+const externalLinkTimeout = 10 * time.Second
+
+func NewLinkChecker(outPath string, baseURL string, checkExternal bool, jobs int) *LinkChecker {
+	parsedBase, err := url.Parse(baseURL)
+	if err != nil {
+		parsedBase = &url.URL{Path: "/"}
+	}
+	if jobs < 1 {
+		jobs = 1
+	}
+	return &LinkChecker{
+		outPath:       outPath,
+		baseURL:       parsedBase,
+		checkExternal: checkExternal,
+		jobs:          jobs,
+		client:        &http.Client{Timeout: externalLinkTimeout},
+	}
+}
+
+// Check reads the pages, keyed by their output file with the
+// source as the value, and returns the broken links sorted by
+// the source
+func (lc *LinkChecker) Check(pages map[string]string) ([]BrokenLink, error) {
+	fsys := os.DirFS(lc.outPath)
+	broken := []BrokenLink{}
+	// the same external link is only requested once
+	external := map[string][]string{}
+
+	for target, sourcePath := range pages {
+		content, err := os.ReadFile(target)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %v for the links, error: %v", target, err)
+		}
+		relTarget, err := filepath.Rel(lc.outPath, target)
+		if err != nil {
+			return nil, err
+		}
+		pageDir := path.Dir(filepath.ToSlash(relTarget))
+
+		for _, link := range htmlLinks(content) {
+			name, kind := lc.resolve(pageDir, link)
+			switch kind {
+			case linkExternal:
+				if lc.checkExternal {
+					external[link] = append(external[link], sourcePath)
+				}
+			case linkInternal:
+				if !linkTargetExists(fsys, name) {
+					broken = append(broken, BrokenLink{Source: sourcePath, Link: link})
+				}
+			case linkInvalid:
+				broken = append(broken, BrokenLink{Source: sourcePath, Link: link})
+			}
+		}
+	}
+
+	for link, sources := range lc.checkExternalLinks(external) {
+		for _, sourcePath := range sources {
+			broken = append(broken, BrokenLink{Source: sourcePath, Link: link})
+		}
+	}
+
+	sort.SliceStable(broken, func(i, j int) bool {
+		if broken[i].Source != broken[j].Source {
+			return broken[i].Source < broken[j].Source
+		}
+		return broken[i].Link < broken[j].Link
+	})
+	return broken, nil
+}
+
+type linkKind int
+
+const (
+	linkInternal linkKind = iota
+	linkExternal
+	// linkIgnored are the links to the page itself
+	// and the ones like `mailto:`
+	linkIgnored
+	// linkInvalid are the links that can't be
+	// parsed or that point out of the site
+	linkInvalid
+)
+
+// resolve turns the link into a name in the output directory,
+// relative links are resolved from the directory of the page
+// and the ones starting with a `/` from the baseurl
+func (lc *LinkChecker) resolve(pageDir string, link string) (string, linkKind) {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return "", linkInvalid
+	}
+	if len(parsed.Scheme) > 0 || len(parsed.Host) > 0 {
+		// absolute urls to the site itself are checked like the rest
+		sameSite := len(lc.baseURL.Host) > 0 && parsed.Host == lc.baseURL.Host
+		switch {
+		case parsed.Scheme != "http" && parsed.Scheme != "https" && len(parsed.Scheme) > 0:
+			return "", linkIgnored
+		case !sameSite:
+			return "", linkExternal
+		}
+	}
+	if len(parsed.Path) == 0 {
+		return "", linkIgnored
+	}
+
+	var name string
+	if strings.HasPrefix(parsed.Path, "/") {
+		prefix := strings.TrimSuffix("/"+strings.Trim(lc.baseURL.Path, "/"), "/")
+		if !strings.HasPrefix(parsed.Path+"/", prefix+"/") {
+			return "", linkInvalid
+		}
+		name = path.Clean(strings.TrimPrefix(strings.TrimPrefix(parsed.Path, prefix), "/"))
+	} else {
+		name = path.Join(pageDir, parsed.Path)
+	}
+	if !fs.ValidPath(name) {
+		return "", linkInvalid
+	}
+	return name, linkInternal
+}
+
+// linkTargetExists checks for the file the same way the dev server
+// looks for it, a directory needs an index.html and names without
+// an extension can point to the html file
+func linkTargetExists(fsys fs.FS, name string) bool {
+	info, err := fs.Stat(fsys, name)
+	if err == nil {
+		if !info.IsDir() {
+			return true
+		}
+		_, err = fs.Stat(fsys, path.Join(name, "index.html"))
+		return err == nil
+	}
+	_, err = fs.Stat(fsys, normalizeFilePath(name))
+	return err == nil
+}
+
+// checkExternalLinks requests the links, `jobs` at a time, and
+// returns the ones that failed along with their sources
+func (lc *LinkChecker) checkExternalLinks(links map[string][]string) map[string][]string {
+	failed := map[string][]string{}
+	lock := &sync.Mutex{}
+	queue := make(chan string)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < lc.jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for link := range queue {
+				if lc.externalLinkWorks(link) {
+					continue
+				}
+				lock.Lock()
+				failed[link] = links[link]
+				lock.Unlock()
+			}
+		}()
+	}
+	for link := range links {
+		queue <- link
+	}
+	close(queue)
+	wg.Wait()
+	return failed
+}
+
+// externalLinkWorks checks the link with a HEAD request, falling
+// back to a GET since some servers don't answer the HEAD ones
+func (lc *LinkChecker) externalLinkWorks(link string) bool {
+	if strings.HasPrefix(link, "//") {
+		link = "https:" + link
+	}
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequest(method, link, nil)
+		if err != nil {
+			return false
+		}
+		req.Header.Set("User-Agent", "alvu")
+		res, err := lc.client.Do(req)
+		if err != nil {
+			continue
+		}
+		res.Body.Close()
+		if res.StatusCode < http.StatusBadRequest {
+			return true
+		}
+	}
+	return false
+}
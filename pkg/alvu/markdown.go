@@ -0,0 +1,483 @@
+package alvu
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	chromahtml "github.com/alecthomas/chroma/formatters/html"
+	"github.com/yuin/goldmark"
+	emoji "github.com/yuin/goldmark-emoji"
+	highlighting "github.com/yuin/goldmark-highlighting"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+func (al *Alvu) initMDProcessor() error {
+	cfg := al.config
+
+	rendererOptions := []renderer.Option{
+		html.WithXHTML(),
+	}
+
+	if cfg.SafeMarkdown {
+		safeRenderer := &safeHTMLRenderer{}
+		if cfg.SanitizeMarkdown {
+			safeRenderer.policy = cfg.SanitizePolicy
+		}
+		rendererOptions = append(rendererOptions, renderer.WithNodeRenderers(
+			util.Prioritized(safeRenderer, 999),
+		))
+	} else {
+		rendererOptions = append(rendererOptions, html.WithUnsafe())
+	}
+
+	if cfg.HardWraps {
+		rendererOptions = append(rendererOptions, html.WithHardWraps())
+	}
+
+	extensions, err := markdownExtenders(cfg.MarkdownDisable)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Footnotes != (Footnotes{}) {
+		for i, ext := range extensions {
+			if ext == extension.Footnote {
+				extensions[i] = &footnoteExtension{footnotes: cfg.Footnotes}
+			}
+		}
+	}
+
+	if cfg.DefinitionLists {
+		extensions = append(extensions, extension.DefinitionList)
+	}
+
+	if cfg.Typographer {
+		typographer, err := typographerExtender(cfg.TypographerDisable)
+		if err != nil {
+			return err
+		}
+		extensions = append(extensions, typographer)
+	}
+
+	if cfg.Math {
+		extensions = append(extensions, &mathExtension{})
+	}
+
+	if cfg.Mermaid {
+		extensions = append(extensions, &mermaidExtension{})
+	}
+
+	if cfg.Emoji {
+		emojiExtension, err := emojiExtender(cfg.EmojiMode)
+		if err != nil {
+			return err
+		}
+		extensions = append(extensions, emojiExtension)
+	}
+
+	gmPlugins := []goldmark.Option{
+		goldmark.WithExtensions(extensions...),
+		goldmark.WithParserOptions(
+			parser.WithAutoHeadingID(),
+		),
+		goldmark.WithRendererOptions(
+			rendererOptions...,
+		),
+	}
+
+	if cfg.Highlight {
+		style, err := highlightStyle(cfg.HighlightTheme)
+		if err != nil {
+			return err
+		}
+
+		formatOptions := []chromahtml.Option{
+			// line numbers and highlighted lines can also be set per
+			// code block with `{linenos=true hl_lines=["2-4"]}`
+			chromahtml.WithLineNumbers(cfg.HighlightLineNumbers),
+			chromahtml.WithClasses(cfg.HighlightCSS),
+		}
+
+		gmPlugins = append(gmPlugins, goldmark.WithExtensions(
+			highlighting.NewHighlighting(
+				highlighting.WithCustomStyle(style),
+				highlighting.WithFormatOptions(formatOptions...),
+			),
+		))
+
+		// with classes, the styles for the theme are written
+		// just once instead of being inlined on every page
+		if cfg.HighlightCSS {
+			err := al.writeHighlightCSS(path.Join(al.outPath, "highlight.css"), style, formatOptions)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// extensions added from go come last so that they can
+	// build on (or override) the built in ones
+	gmPlugins = append(gmPlugins, goldmark.WithExtensions(cfg.MarkdownExtensions...))
+
+	al.mdProcessor = goldmark.New(gmPlugins...)
+	return nil
+}
+
+// markdownExtensions are the goldmark extensions that are
+// enabled by default, the first four make up GFM
+var markdownExtensions = []struct {
+	name     string
+	extender goldmark.Extender
+}{
+	{"linkify", extension.Linkify},
+	{"table", extension.Table},
+	{"strikethrough", extension.Strikethrough},
+	{"tasklist", extension.TaskList},
+	{"footnote", extension.Footnote},
+}
+
+// markdownExtenders returns the default markdown
+// extensions other than the disabled ones
+func markdownExtenders(disabled []string) ([]goldmark.Extender, error) {
+	names := []string{}
+	for _, ext := range markdownExtensions {
+		names = append(names, ext.name)
+	}
+	for _, name := range disabled {
+		if !Contains(names, name) {
+			return nil, fmt.Errorf("unknown markdown extension `%v`, use one of %v", name, strings.Join(names, ", "))
+		}
+	}
+
+	extenders := []goldmark.Extender{}
+	for _, ext := range markdownExtensions {
+		if !Contains(disabled, ext.name) {
+			extenders = append(extenders, ext.extender)
+		}
+	}
+	return extenders, nil
+}
+
+// typographerSubstitutions are the replacements made by the
+// typographer, grouped by the name used to disable them, with
+// the text to write back when they are disabled
+var typographerSubstitutions = []struct {
+	name     string
+	original map[extension.TypographicPunctuation][]byte
+}{
+	// apostrophes fall back to the single quotes in goldmark
+	// so they can't be turned off on their own
+	{"single-quotes", map[extension.TypographicPunctuation][]byte{extension.LeftSingleQuote: nil, extension.RightSingleQuote: nil, extension.Apostrophe: nil}},
+	{"double-quotes", map[extension.TypographicPunctuation][]byte{extension.LeftDoubleQuote: nil, extension.RightDoubleQuote: nil}},
+	{"en-dash", map[extension.TypographicPunctuation][]byte{extension.EnDash: []byte("--")}},
+	{"em-dash", map[extension.TypographicPunctuation][]byte{extension.EmDash: []byte("---")}},
+	{"ellipsis", map[extension.TypographicPunctuation][]byte{extension.Ellipsis: []byte("...")}},
+	{"angle-quotes", map[extension.TypographicPunctuation][]byte{extension.LeftAngleQuote: []byte("&lt;&lt;"), extension.RightAngleQuote: []byte("&gt;&gt;")}},
+}
+
+const (
+	emojiModeUnicode = "unicode"
+	emojiModeImage   = "image"
+)
+
+var emojiModes = []string{emojiModeUnicode, emojiModeImage}
+
+// emojiImageTemplate is the img tag for the image mode, the
+// twemoji default points to a cdn that has been shut down
+const emojiImageTemplate = `<img class="emoji" draggable="false" alt="%[1]s" src="https://cdn.jsdelivr.net/gh/twitter/twemoji@14.0.2/assets/72x72/%[2]s.png"%[3]s>`
+
+// emojiExtender returns the emoji extension for the given mode,
+// shortcodes that aren't known emojis are left as is
+func emojiExtender(mode string) (goldmark.Extender, error) {
+	switch mode {
+	case emojiModeUnicode:
+		return emoji.New(emoji.WithRenderingMethod(emoji.Unicode)), nil
+	case emojiModeImage:
+		return emoji.New(
+			emoji.WithRenderingMethod(emoji.Twemoji),
+			emoji.WithTwemojiTemplate(emojiImageTemplate),
+		), nil
+	}
+	return nil, fmt.Errorf("unknown emoji mode `%v`, use one of %v", mode, strings.Join(emojiModes, ", "))
+}
+
+// typographerExtender returns the typographer with the
+// disabled substitutions turned off
+func typographerExtender(disabled []string) (goldmark.Extender, error) {
+	names := []string{}
+	for _, sub := range typographerSubstitutions {
+		names = append(names, sub.name)
+	}
+	for _, name := range disabled {
+		if !Contains(names, name) {
+			return nil, fmt.Errorf("unknown typographer substitution `%v`, use one of %v", name, strings.Join(names, ", "))
+		}
+	}
+
+	// goldmark leaves quotes as is when their substitution is nil,
+	// the dashes are written back instead so that a disabled `---`
+	// isn't picked up as an en dash followed by a `-`
+	substitutions := map[extension.TypographicPunctuation][]byte{}
+	for _, sub := range typographerSubstitutions {
+		if !Contains(disabled, sub.name) {
+			continue
+		}
+		for punctuation, original := range sub.original {
+			substitutions[punctuation] = original
+		}
+	}
+
+	return extension.NewTypographer(
+		extension.WithTypographicSubstitutions(substitutions),
+	), nil
+}
+
+// footnoteExtension is the goldmark footnote extension
+// with the options from the config
+type footnoteExtension struct {
+	footnotes Footnotes
+}
+
+func (e *footnoteExtension) Extend(m goldmark.Markdown) {
+	options := []extension.FootnoteOption{}
+	if len(e.footnotes.Backlink) > 0 {
+		options = append(options, extension.WithFootnoteBacklinkHTML([]byte(e.footnotes.Backlink)))
+	}
+	if len(e.footnotes.LinkTitle) > 0 {
+		options = append(options, extension.WithFootnoteLinkTitle([]byte(e.footnotes.LinkTitle)))
+	}
+	if len(e.footnotes.BacklinkTitle) > 0 {
+		options = append(options, extension.WithFootnoteBacklinkTitle([]byte(e.footnotes.BacklinkTitle)))
+	}
+	if len(e.footnotes.LinkClass) > 0 {
+		options = append(options, extension.WithFootnoteLinkClass([]byte(e.footnotes.LinkClass)))
+	}
+	if len(e.footnotes.BacklinkClass) > 0 {
+		options = append(options, extension.WithFootnoteBacklinkClass([]byte(e.footnotes.BacklinkClass)))
+	}
+	extension.NewFootnote(options...).Extend(m)
+
+	// goldmark has no option for a heading, so the list is
+	// written by a renderer that runs before the built in one
+	if len(e.footnotes.Heading) > 0 {
+		m.Renderer().AddOptions(
+			renderer.WithNodeRenderers(util.Prioritized(&footnoteListRenderer{heading: e.footnotes.Heading}, 499)),
+		)
+	}
+}
+
+type footnoteListRenderer struct {
+	heading string
+}
+
+func (r *footnoteListRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(east.KindFootnoteList, r.renderFootnoteList)
+}
+
+// renderFootnoteList writes the same list as goldmark
+// with the heading in between the rule and the list
+func (r *footnoteListRenderer) renderFootnoteList(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		w.WriteString("</ol>\n")
+		w.WriteString("</div>\n")
+		return ast.WalkContinue, nil
+	}
+
+	w.WriteString(`<div class="footnotes" role="doc-endnotes"`)
+	if n.Attributes() != nil {
+		html.RenderAttributes(w, n, html.GlobalAttributeFilter)
+	}
+	w.WriteString(">\n<hr />\n")
+	w.WriteString(`<h2 class="footnotes-heading">`)
+	w.Write(util.EscapeHTML([]byte(r.heading)))
+	w.WriteString("</h2>\n")
+	w.WriteString("<ol>\n")
+	return ast.WalkContinue, nil
+}
+
+// safeHTMLRenderer writes the raw html of markdown files when
+// they aren't trusted, the shortcode placeholders are kept since
+// they're swapped with the output of the shortcodes afterwards
+type safeHTMLRenderer struct {
+	// policy sanitizes the raw html instead of leaving it out
+	policy *SanitizePolicy
+}
+
+func (r *safeHTMLRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindHTMLBlock, r.renderHTMLBlock)
+	reg.Register(ast.KindRawHTML, r.renderRawHTML)
+}
+
+func (r *safeHTMLRenderer) renderHTMLBlock(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	block := n.(*ast.HTMLBlock)
+	var raw bytes.Buffer
+	lines := block.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		segment := lines.At(i)
+		raw.Write(segment.Value(source))
+	}
+	if block.HasClosure() {
+		raw.Write(block.ClosureLine.Value(source))
+	}
+	r.write(w, raw.Bytes())
+	w.WriteByte('\n')
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *safeHTMLRenderer) renderRawHTML(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkSkipChildren, nil
+	}
+	var raw bytes.Buffer
+	segments := n.(*ast.RawHTML).Segments
+	for i := 0; i < segments.Len(); i++ {
+		segment := segments.At(i)
+		raw.Write(segment.Value(source))
+	}
+	r.write(w, raw.Bytes())
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *safeHTMLRenderer) write(w util.BufWriter, raw []byte) {
+	if r.policy != nil {
+		w.Write(r.policy.Sanitize(raw))
+		return
+	}
+	for _, placeholder := range shortcodePlaceholderPattern.FindAll(raw, -1) {
+		w.Write(placeholder)
+	}
+	if len(bytes.TrimSpace(shortcodePlaceholderPattern.ReplaceAll(raw, nil))) > 0 {
+		w.WriteString("<!-- raw HTML omitted -->")
+	}
+}
+
+// TOCEntry is a single heading from the page, available
+// to the templates as `.Extras.toc`
+type TOCEntry struct {
+	Level  int    `json:"level"`
+	Text   string `json:"text"`
+	Anchor string `json:"anchor"`
+}
+
+// convertMarkdown converts the markdown source to html and
+// returns the headings that make up the table of contents
+// along with the text of the first h1 heading and the headings
+// that got a numbered id
+func (al *Alvu) convertMarkdown(source []byte, w io.Writer) ([]TOCEntry, string, []DuplicateID, error) {
+	ids := newHeadingIDs()
+	doc := al.mdProcessor.Parser().Parse(text.NewReader(source), parser.WithContext(parser.NewContext(parser.WithIDs(ids))))
+
+	toc := []TOCEntry{}
+	title := ""
+	err := ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		heading, ok := n.(*ast.Heading)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		if heading.Level == 1 && len(title) == 0 {
+			title = string(heading.Text(source))
+		}
+		if heading.Level < al.config.TOCMinLevel {
+			return ast.WalkSkipChildren, nil
+		}
+
+		entry := TOCEntry{
+			Level: heading.Level,
+			Text:  string(heading.Text(source)),
+		}
+		if id, ok := heading.AttributeString("id"); ok {
+			if idBytes, ok := id.([]byte); ok {
+				entry.Anchor = string(idBytes)
+			}
+		}
+		toc = append(toc, entry)
+		return ast.WalkSkipChildren, nil
+	})
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return toc, title, ids.duplicates, al.mdProcessor.Renderer().Render(w, source, doc)
+}
+
+// DuplicateID is a heading that got a numbered id since an
+// earlier element in the page has the same id
+type DuplicateID struct {
+	Text string
+	// Base is the id the heading would've had
+	Base string
+	ID   string
+}
+
+// headingIDs generates the ids of the headings the same way as
+// goldmark, the text is lower cased with the spaces turned into
+// dashes and the other symbols dropped. An id that's already used
+// gets a `-1`, `-2`... suffix in the order of the page so the
+// ids stay the same between builds, those are kept in duplicates
+type headingIDs struct {
+	values     map[string]bool
+	duplicates []DuplicateID
+}
+
+func newHeadingIDs() *headingIDs {
+	return &headingIDs{values: map[string]bool{}}
+}
+
+func (hi *headingIDs) Generate(value []byte, kind ast.NodeKind) []byte {
+	value = util.TrimRightSpace(util.TrimLeftSpace(value))
+	result := []byte{}
+	for i := 0; i < len(value); {
+		v := value[i]
+		l := util.UTF8Len(v)
+		i += int(l)
+		if l != 1 {
+			continue
+		}
+		if util.IsAlphaNumeric(v) {
+			if 'A' <= v && v <= 'Z' {
+				v += 'a' - 'A'
+			}
+			result = append(result, v)
+		} else if util.IsSpace(v) || v == '-' || v == '_' {
+			result = append(result, '-')
+		}
+	}
+	if len(result) == 0 {
+		if kind == ast.KindHeading {
+			result = []byte("heading")
+		} else {
+			result = []byte("id")
+		}
+	}
+
+	base := string(result)
+	if !hi.values[base] {
+		hi.values[base] = true
+		return result
+	}
+	for i := 1; ; i++ {
+		id := fmt.Sprintf("%s-%d", base, i)
+		if !hi.values[id] {
+			hi.values[id] = true
+			hi.duplicates = append(hi.duplicates, DuplicateID{Text: string(value), Base: base, ID: id})
+			return []byte(id)
+		}
+	}
+}
+
+func (hi *headingIDs) Put(value []byte) {
+	hi.values[string(value)] = true
+}
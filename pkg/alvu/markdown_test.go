@@ -0,0 +1,178 @@
+package alvu
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/yuin/goldmark"
+)
+
+// renderMarkdown converts the markdown with the extensions
+func renderMarkdown(t *testing.T, source string, extensions ...goldmark.Extender) string {
+	t.Helper()
+	var rendered bytes.Buffer
+	if err := goldmark.New(goldmark.WithExtensions(extensions...)).Convert([]byte(source), &rendered); err != nil {
+		t.Fatal(err)
+	}
+	return rendered.String()
+}
+
+func TestEmoji(t *testing.T) {
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{emojiModeUnicode, "<p>done \U0001F389 :not_an_emoji:</p>\n"},
+		{emojiModeImage, `<p>done <img class="emoji" draggable="false" alt="party popper" src="https://cdn.jsdelivr.net/gh/twitter/twemoji@14.0.2/assets/72x72/1f389.png"> :not_an_emoji:</p>` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			emojiExtension, err := emojiExtender(tt.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := renderMarkdown(t, "done :tada: :not_an_emoji:", emojiExtension); got != tt.want {
+				t.Errorf("rendered %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := emojiExtender("ascii"); err == nil || !strings.Contains(err.Error(), "unknown emoji mode") {
+		t.Errorf("emojiExtender(\"ascii\") = %v, want an unknown mode error", err)
+	}
+}
+
+func TestEmojiInPages(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"pages/_layout.html": "{{.Content}}",
+		"pages/index.md":     "done :tada: :not_an_emoji:",
+	})
+	if err := buildSite(t, dir, Config{Emoji: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readOutput(t, dir, "index.html"), "<p>done \U0001F389 :not_an_emoji:</p>\n"; got != want {
+		t.Errorf("index.html = %q, want %q", got, want)
+	}
+}
+
+func TestSafeMarkdown(t *testing.T) {
+	document := strings.Join([]string{
+		"<script>alert(1)</script>",
+		"",
+		`Some <img src="a.png" onerror="alert(2)"> text`,
+		"",
+		"[link](javascript:alert(3)) and <a href=\"javascript:alert(4)\">raw link</a>",
+	}, "\n")
+
+	tests := []struct {
+		name string
+		cfg  Config
+		kept []string
+	}{
+		{"safe", Config{SafeMarkdown: true}, []string{"<p>Some  text</p>", ">link</a> and raw link"}},
+		// the allowed tags and attributes are kept when sanitizing
+		{"sanitized", Config{SafeMarkdown: true, SanitizeMarkdown: true}, []string{`<img src="a.png">`, "<a>raw link</a>"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeSite(t, map[string]string{
+				"pages/_layout.html": "{{.Content}}",
+				"pages/index.md":     document,
+			})
+			if err := buildSite(t, dir, tt.cfg); err != nil {
+				t.Fatal(err)
+			}
+			got := readOutput(t, dir, "index.html")
+			for _, unsafe := range []string{"<script", "alert", "onerror", "javascript:"} {
+				if strings.Contains(got, unsafe) {
+					t.Errorf("index.html = %q, want it without %q", got, unsafe)
+				}
+			}
+			for _, kept := range tt.kept {
+				if !strings.Contains(got, kept) {
+					t.Errorf("index.html = %q, want it to contain %q", got, kept)
+				}
+			}
+		})
+	}
+}
+
+func TestUnsafeMarkdownIsTheDefault(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"pages/_layout.html": "{{.Content}}",
+		"pages/index.md":     "<script>alert(1)</script>",
+	})
+	if err := buildSite(t, dir, Config{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readOutput(t, dir, "index.html"); !strings.Contains(got, "<script>alert(1)</script>") {
+		t.Errorf("index.html = %q, want the raw html kept", got)
+	}
+}
+
+func TestDefinitionListsAndTypographer(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      Config
+		document string
+		want     string
+	}{
+		{
+			"definition lists",
+			Config{DefinitionLists: true},
+			"Term\n: Definition",
+			"<dl>\n<dt>Term</dt>\n<dd>Definition</dd>\n</dl>\n",
+		},
+		{
+			"no definition lists",
+			Config{},
+			"Term\n: Definition",
+			"<p>Term\n: Definition</p>\n",
+		},
+		{
+			"typographer",
+			Config{Typographer: true},
+			`"double" 'single' it's a -- b --- c... <<angle>>`,
+			"<p>&ldquo;double&rdquo; &lsquo;single&rsquo; it&rsquo;s a &ndash; b &mdash; c&hellip; &laquo;angle&raquo;</p>\n",
+		},
+		{
+			"no typographer",
+			Config{},
+			`"double" 'single' it's a -- b --- c...`,
+			"<p>&quot;double&quot; 'single' it's a -- b --- c...</p>\n",
+		},
+		{
+			"typographer with the quotes disabled",
+			Config{Typographer: true, TypographerDisable: []string{"double-quotes", "single-quotes"}},
+			`"double" 'single' it's a -- b --- c...`,
+			"<p>&quot;double&quot; 'single' it's a &ndash; b &mdash; c&hellip;</p>\n",
+		},
+		{
+			"typographer with the dashes and ellipsis disabled",
+			Config{Typographer: true, TypographerDisable: []string{"en-dash", "em-dash", "ellipsis", "angle-quotes"}},
+			`"double" a -- b --- c... <<angle>>`,
+			"<p>&ldquo;double&rdquo; a -- b --- c... &lt;&lt;angle&gt;&gt;</p>\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeSite(t, map[string]string{
+				"pages/_layout.html": "{{.Content}}",
+				"pages/index.md":     tt.document,
+			})
+			if err := buildSite(t, dir, tt.cfg); err != nil {
+				t.Fatal(err)
+			}
+			if got := readOutput(t, dir, "index.html"); got != tt.want {
+				t.Errorf("index.html = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTypographerDisableIsChecked(t *testing.T) {
+	if _, err := typographerExtender([]string{"smart-quotes"}); err == nil || !strings.Contains(err.Error(), "unknown typographer substitution") {
+		t.Errorf("typographerExtender() = %v, want an unknown substitution error", err)
+	}
+}
@@ -0,0 +1,225 @@
+package alvu
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+var kindMath = ast.NewNodeKind("Math")
+
+var kindMathBlock = ast.NewNodeKind("MathBlock")
+
+// mathNode is a `$...$` or `$$...$$` span in a line
+type mathNode struct {
+	ast.BaseInline
+	value   []byte
+	display bool
+}
+
+func (n *mathNode) Kind() ast.NodeKind {
+	return kindMath
+}
+
+func (n *mathNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Value": string(n.value)}, nil)
+}
+
+// mathBlockNode is the math between two `$$` lines, or
+// a line that's just `$$...$$`
+type mathBlockNode struct {
+	ast.BaseBlock
+	closed bool
+}
+
+func (n *mathBlockNode) Kind() ast.NodeKind {
+	return kindMathBlock
+}
+
+func (n *mathBlockNode) IsRaw() bool {
+	return true
+}
+
+func (n *mathBlockNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// mathExtension keeps the math out of the markdown conversion
+// so that it reaches katex or mathjax without emphasis, escapes
+// or typography being applied to it
+type mathExtension struct{}
+
+func (e *mathExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(util.Prioritized(&mathBlockParser{}, 700)),
+		parser.WithInlineParsers(util.Prioritized(&mathInlineParser{}, 150)),
+	)
+	m.Renderer().AddOptions(
+		renderer.WithNodeRenderers(util.Prioritized(&mathRenderer{}, 500)),
+	)
+}
+
+type mathInlineParser struct{}
+
+func (p *mathInlineParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (p *mathInlineParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	// the second `$` of a `$$` that didn't close
+	if block.PrecendingCharacter() == '$' {
+		return nil
+	}
+
+	line, _ := block.PeekLine()
+	delim := 1
+	if len(line) > 1 && line[1] == '$' {
+		delim = 2
+	}
+
+	closing := findMathClosing(line, delim)
+	if closing == -1 {
+		return nil
+	}
+
+	block.Advance(closing + delim)
+	return &mathNode{
+		value:   append([]byte{}, line[delim:closing]...),
+		display: delim == 2,
+	}
+}
+
+// findMathClosing returns the index of the closing delimiter, a
+// single `$` only pairs with the next unescaped `$` and follows
+// the same rules as pandoc so that prices like `$5 and $10`
+// aren't picked up, the opening `$` can't be followed by a space
+// and the closing one can't be after a space or before a digit
+func findMathClosing(line []byte, delim int) int {
+	if len(line) <= delim || (delim == 1 && util.IsSpace(line[1])) {
+		return -1
+	}
+
+	for i := delim; i < len(line); i++ {
+		switch {
+		case line[i] == '\\':
+			i++
+		case line[i] != '$':
+		case delim == 2:
+			if i+1 < len(line) && line[i+1] == '$' && i > delim {
+				return i
+			}
+		case i > delim && !util.IsSpace(line[i-1]) && (i+1 == len(line) || !isDigit(line[i+1])):
+			return i
+		default:
+			return -1
+		}
+	}
+	return -1
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+type mathBlockParser struct{}
+
+func (b *mathBlockParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (b *mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || !bytes.HasPrefix(line[pos:], []byte("$$")) {
+		return nil, parser.NoChildren
+	}
+
+	node := &mathBlockNode{}
+	rest := util.TrimRightSpace(line[pos+2:])
+	switch {
+	case len(rest) == 0:
+	case len(rest) > 2 && bytes.HasSuffix(rest, []byte("$$")):
+		// the whole block is on a single line
+		start := segment.Start + pos + 2
+		node.Lines().Append(text.NewSegment(start, start+len(rest)-2))
+		node.closed = true
+	default:
+		return nil, parser.NoChildren
+	}
+
+	reader.Advance(segment.Len() - 1)
+	return node, parser.NoChildren
+}
+
+func (b *mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	if node.(*mathBlockNode).closed {
+		return parser.Close
+	}
+
+	line, segment := reader.PeekLine()
+	if bytes.Equal(util.TrimRightSpace(util.TrimLeftSpace(line)), []byte("$$")) {
+		reader.Advance(segment.Len() - 1)
+		return parser.Close
+	}
+
+	node.Lines().Append(segment)
+	reader.Advance(segment.Len() - 1)
+	return parser.Continue | parser.NoChildren
+}
+
+func (b *mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (b *mathBlockParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (b *mathBlockParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+// mathRenderer writes the math with the `\(...\)` and `\[...\]`
+// delimiters that both katex and mathjax look for by default
+type mathRenderer struct{}
+
+func (r *mathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMath, r.renderMath)
+	reg.Register(kindMathBlock, r.renderMathBlock)
+}
+
+func (r *mathRenderer) renderMath(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	node := n.(*mathNode)
+	if node.display {
+		w.WriteString(`<span class="math display">\[`)
+		w.Write(util.EscapeHTML(node.value))
+		w.WriteString(`\]</span>`)
+	} else {
+		w.WriteString(`<span class="math inline">\(`)
+		w.Write(util.EscapeHTML(node.value))
+		w.WriteString(`\)</span>`)
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *mathRenderer) renderMathBlock(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	w.WriteString(`<div class="math display">\[`)
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		segment := lines.At(i)
+		w.Write(util.EscapeHTML(segment.Value(source)))
+	}
+	w.WriteString("\\]</div>\n")
+	return ast.WalkSkipChildren, nil
+}
@@ -0,0 +1,85 @@
+package alvu
+
+import (
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+var kindMermaid = ast.NewNodeKind("Mermaid")
+
+// mermaidNode is a ```mermaid code block
+type mermaidNode struct {
+	ast.BaseBlock
+}
+
+func (n *mermaidNode) Kind() ast.NodeKind {
+	return kindMermaid
+}
+
+func (n *mermaidNode) IsRaw() bool {
+	return true
+}
+
+func (n *mermaidNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// mermaidExtension swaps the ```mermaid code blocks with a node of
+// their own before rendering, so they're never highlighted
+type mermaidExtension struct{}
+
+func (e *mermaidExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithASTTransformers(util.Prioritized(&mermaidTransformer{}, 100)),
+	)
+	m.Renderer().AddOptions(
+		renderer.WithNodeRenderers(util.Prioritized(&mermaidRenderer{}, 500)),
+	)
+}
+
+type mermaidTransformer struct{}
+
+func (t *mermaidTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+
+	codeBlocks := []*ast.FencedCodeBlock{}
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		codeBlock, ok := n.(*ast.FencedCodeBlock)
+		if entering && ok && string(codeBlock.Language(source)) == "mermaid" {
+			codeBlocks = append(codeBlocks, codeBlock)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, codeBlock := range codeBlocks {
+		node := &mermaidNode{}
+		node.SetLines(codeBlock.Lines())
+		parent := codeBlock.Parent()
+		parent.ReplaceChild(parent, codeBlock, node)
+	}
+}
+
+type mermaidRenderer struct{}
+
+func (r *mermaidRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMermaid, r.renderMermaid)
+}
+
+func (r *mermaidRenderer) renderMermaid(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	w.WriteString(`<pre class="mermaid">`)
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		segment := lines.At(i)
+		w.Write(util.EscapeHTML(segment.Value(source)))
+	}
+	w.WriteString("</pre>\n")
+	return ast.WalkSkipChildren, nil
+}
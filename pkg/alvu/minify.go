@@ -0,0 +1,106 @@
+package alvu
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// Minifier takes the contents of a file and returns
+// the minified version of it
+type Minifier func(content []byte) []byte
+
+// minifiers maps the file extension to the minifier that
+// handles it, files without one are left as is
+var minifiers = map[string]Minifier{
+	".html": minifyHTML,
+	".css":  minifyCSS,
+}
+
+func minifyFile(filePath string) error {
+	minifier, ok := minifiers[filepath.Ext(filePath)]
+	if !ok {
+		return nil
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filePath, minifier(content), 0644)
+}
+
+// minifyCopiedFiles minifies the files that were copied
+// from the source directory into the output directory
+func minifyCopiedFiles(sourceDir string, outDir string, excludes ExcludePatterns) error {
+	return filepath.WalkDir(sourceDir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if excludes.MatchIn(sourceDir, filePath) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(sourceDir, filePath)
+		if err != nil {
+			return err
+		}
+
+		return minifyFile(filepath.Join(outDir, relPath))
+	})
+}
+
+var htmlPreservedPattern = regexp.MustCompile(`(?is)<pre[\s>].*?</pre>|<textarea[\s>].*?</textarea>|<script[\s>].*?</script>|<style[\s>].*?</style>`)
+
+var htmlCommentPattern = regexp.MustCompile(`(?s)<!--.*?-->`)
+
+var whitespacePattern = regexp.MustCompile(`\s+`)
+
+// minifyHTML drops comments (other than conditional comments) and
+// collapses whitespace, the content of pre, textarea, script and
+// style tags is left untouched
+func minifyHTML(content []byte) []byte {
+	var out bytes.Buffer
+	last := 0
+
+	minifySegment := func(segment []byte) {
+		segment = htmlCommentPattern.ReplaceAllFunc(segment, func(comment []byte) []byte {
+			if bytes.HasPrefix(comment, []byte("<!--[if")) || bytes.HasPrefix(comment, []byte("<!--<![endif]")) {
+				return comment
+			}
+			return []byte{}
+		})
+		out.Write(whitespacePattern.ReplaceAll(segment, []byte(" ")))
+	}
+
+	for _, loc := range htmlPreservedPattern.FindAllIndex(content, -1) {
+		minifySegment(content[last:loc[0]])
+		out.Write(content[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	minifySegment(content[last:])
+
+	return bytes.TrimSpace(out.Bytes())
+}
+
+var cssCommentPattern = regexp.MustCompile(`(?s)/\*.*?\*/`)
+
+var cssPunctuationPattern = regexp.MustCompile(`\s*([{};,])\s*`)
+
+func minifyCSS(content []byte) []byte {
+	content = cssCommentPattern.ReplaceAll(content, []byte{})
+	content = whitespacePattern.ReplaceAll(content, []byte(" "))
+	content = cssPunctuationPattern.ReplaceAll(content, []byte("$1"))
+	return bytes.TrimSpace(content)
+}
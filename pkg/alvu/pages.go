@@ -0,0 +1,268 @@
+package alvu
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	luaAlvu "github.com/barelyhuman/alvu/lua/alvu"
+	"gopkg.in/yaml.v3"
+)
+
+// Page is the information about a page that's available
+// to the hooks while the other pages are being built
+type Page struct {
+	Name string                 `json:"name"`
+	URL  string                 `json:"url"`
+	Meta map[string]interface{} `json:"meta"`
+	// Collection is the name of the collection
+	// the page is in, empty if none
+	Collection string `json:"collection,omitempty"`
+}
+
+// CollectPages builds the list of pages from the loaded files,
+// pages that are left out of the build aren't a part of it
+func (al *Alvu) CollectPages() {
+	pages := []Page{}
+	for _, alvuFile := range al.files {
+		if alvuFile.skip || alvuFile.passthrough || alvuFile.isText {
+			continue
+		}
+
+		url, err := al.outputURL(alvuFile.targetFile(alvuFile.defaultTargetName()))
+		if err != nil {
+			continue
+		}
+
+		page := Page{
+			Name: alvuFile.name,
+			URL:  url,
+			Meta: alvuFile.meta,
+		}
+		if alvuFile.collection != nil {
+			page.Collection = alvuFile.collection.Name
+		}
+		pages = append(pages, page)
+	}
+	al.pages = pages
+	al.nav = buildNav(pages, al.config.NavSort, al.config.NavScope)
+	al.generated = al.taxonomyFiles()
+}
+
+const (
+	navScopeDir  = "dir"
+	navScopeSite = "site"
+)
+
+// NavLink is the page before or after the current one,
+// available to the templates as `.Extras.prev` and
+// `.Extras.next`
+type NavLink struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+type navLinks struct {
+	prev *NavLink
+	next *NavLink
+}
+
+// buildNav orders the pages of each group by the sortKey
+// in their meta and links every page to it's neighbours,
+// pages with `nav: false` in their meta are left out
+func buildNav(pages []Page, sortKey string, scope string) map[string]navLinks {
+	groups := map[string][]Page{}
+	groupNames := []string{}
+	for _, page := range pages {
+		if inNav, ok := page.Meta["nav"].(bool); ok && !inNav {
+			continue
+		}
+		group := ""
+		if scope != navScopeSite {
+			group = path.Dir(filepath.ToSlash(page.Name))
+		}
+		if _, ok := groups[group]; !ok {
+			groupNames = append(groupNames, group)
+		}
+		groups[group] = append(groups[group], page)
+	}
+
+	nav := map[string]navLinks{}
+	for _, group := range groupNames {
+		groupPages := groups[group]
+		sort.SliceStable(groupPages, func(i, j int) bool {
+			return navLess(groupPages[i], groupPages[j], sortKey)
+		})
+		for i, page := range groupPages {
+			links := navLinks{}
+			if i > 0 {
+				links.prev = newNavLink(groupPages[i-1])
+			}
+			if i < len(groupPages)-1 {
+				links.next = newNavLink(groupPages[i+1])
+			}
+			nav[page.Name] = links
+		}
+	}
+	return nav
+}
+
+// navLess compares the pages by the sortKey, dates are
+// compared as dates and everything else as text, pages
+// without the key come after the ones with it and fall
+// back to the order of the file names
+func navLess(a, b Page, sortKey string) bool {
+	aValue, aOk := a.Meta[sortKey]
+	bValue, bOk := b.Meta[sortKey]
+	if aOk != bOk {
+		return aOk
+	}
+	if aOk && bOk {
+		aDate, aIsDate := luaAlvu.ParseDate(aValue)
+		bDate, bIsDate := luaAlvu.ParseDate(bValue)
+		if aIsDate && bIsDate {
+			if !aDate.Equal(bDate) {
+				return aDate.Before(bDate)
+			}
+		} else if aText, bText := fmt.Sprint(aValue), fmt.Sprint(bValue); aText != bText {
+			return aText < bText
+		}
+	}
+	return a.Name < b.Name
+}
+
+func newNavLink(page Page) *NavLink {
+	title, ok := page.Meta["title"].(string)
+	if !ok || len(title) == 0 {
+		title = strings.TrimSuffix(filepath.Base(page.Name), filepath.Ext(page.Name))
+	}
+	return &NavLink{
+		Title: title,
+		URL:   page.URL,
+	}
+}
+
+// navExtras returns the prev/next links of the
+// file to be merged into it's extras
+func (al *Alvu) navExtras(name string) map[string]interface{} {
+	links, ok := al.nav[name]
+	if !ok {
+		return nil
+	}
+	extras := map[string]interface{}{}
+	if links.prev != nil {
+		extras["prev"] = links.prev
+	}
+	if links.next != nil {
+		extras["next"] = links.next
+	}
+	return extras
+}
+
+// Collection is a directory in pages with its own defaults,
+// set under `collections` in the config, eg:
+//
+//	collections:
+//	  posts:
+//	    layout: post
+//	    prefix: blog
+type Collection struct {
+	// Name is the directory in pages, relative to it
+	Name string
+	// Layout is the named layout used by the pages
+	// that don't pick one in their meta
+	Layout string
+	// Prefix is the directory the files are written to,
+	// relative to the output, defaults to the Name and
+	// `/` writes them to the root of the output
+	Prefix string
+}
+
+type collectionOptions struct {
+	Layout string `yaml:"layout"`
+	Prefix string `yaml:"prefix"`
+}
+
+// ConfigCollections reads the collections from the config,
+// ordered by their name
+func ConfigCollections(config map[string]interface{}) ([]Collection, error) {
+	collections := []Collection{}
+	value, ok := config["collections"]
+	if !ok || value == nil {
+		return collections, nil
+	}
+
+	// round trip through yaml to check the keys of every collection
+	content, err := yaml.Marshal(value)
+	if err != nil {
+		return collections, fmt.Errorf("invalid collections in the config, error: %v", err)
+	}
+	decoded := map[string]*collectionOptions{}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&decoded); err != nil {
+		return collections, fmt.Errorf("invalid collections in the config, error: %v", err)
+	}
+
+	for name, options := range decoded {
+		collection := Collection{Name: name}
+		if options != nil {
+			collection.Layout = options.Layout
+			collection.Prefix = options.Prefix
+		}
+		collections = append(collections, collection)
+	}
+	sort.Slice(collections, func(i, j int) bool {
+		return collections[i].Name < collections[j].Name
+	})
+	return collections, nil
+}
+
+// Validate checks that the name and prefix stay inside of
+// the pages and the output
+func (c Collection) Validate() error {
+	name := path.Clean(c.Name)
+	if len(c.Name) == 0 || name == "." || path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+		return fmt.Errorf("invalid collection `%v`, use a directory inside of pages", c.Name)
+	}
+	prefix := path.Clean(strings.TrimPrefix(c.Prefix, "/"))
+	if prefix == ".." || strings.HasPrefix(prefix, "../") {
+		return fmt.Errorf("invalid prefix `%v` for the collection `%v`", c.Prefix, c.Name)
+	}
+	return nil
+}
+
+// Contains checks if the file, relative to the pages,
+// is in the collection
+func (c Collection) Contains(fileName string) bool {
+	return strings.HasPrefix(filepath.ToSlash(fileName), path.Clean(c.Name)+"/")
+}
+
+// OutputName swaps the collection's directory in the file
+// name, relative to the pages, for the prefix
+func (c Collection) OutputName(fileName string) string {
+	prefix := c.Prefix
+	if len(prefix) == 0 {
+		prefix = c.Name
+	}
+	rel := strings.TrimPrefix(filepath.ToSlash(fileName), path.Clean(c.Name)+"/")
+	return strings.TrimPrefix(path.Join("/", prefix, rel), "/")
+}
+
+// collectionFor picks the collection the file is in, the
+// deepest one wins when collections are nested
+func collectionFor(collections []Collection, fileName string) *Collection {
+	var found *Collection
+	for i := range collections {
+		if !collections[i].Contains(fileName) {
+			continue
+		}
+		if found == nil || len(path.Clean(collections[i].Name)) > len(path.Clean(found.Name)) {
+			found = &collections[i]
+		}
+	}
+	return found
+}
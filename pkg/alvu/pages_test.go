@@ -0,0 +1,71 @@
+package alvu
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrevNextLinksFollowTheDates(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"pages/_layout.html": `{{with .Extras.prev}}prev:{{.Title}}:{{.URL}} {{end}}{{with .Extras.next}}next:{{.Title}}:{{.URL}}{{end}}`,
+		"pages/blog/a.md":    "---\ntitle: Last\ndate: 2023-03-01\n---\n",
+		"pages/blog/b.md":    "---\ntitle: First\ndate: 2023-01-01\n---\n",
+		"pages/blog/c.md":    "---\ntitle: Middle\ndate: 2023-02-01\n---\n",
+	})
+	if err := buildSite(t, dir, Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"blog/b.html": "next:Middle:/blog/c.html",
+		"blog/c.html": "prev:First:/blog/b.html next:Last:/blog/a.html",
+		"blog/a.html": "prev:Middle:/blog/c.html ",
+	}
+	for name, links := range expected {
+		if page := readOutput(t, dir, name); page != links {
+			t.Errorf("expected %v to have %q, got %q", name, links, page)
+		}
+	}
+}
+
+func TestBuildNav(t *testing.T) {
+	pages := []Page{
+		{Name: "b.md", Meta: map[string]interface{}{}},
+		{Name: "a.md", Meta: map[string]interface{}{}},
+		{Name: "dated.md", Meta: map[string]interface{}{"date": "2023-01-01"}},
+		{Name: "hidden.md", Meta: map[string]interface{}{"nav": false}},
+		{Name: "blog/post.md", Meta: map[string]interface{}{}},
+	}
+
+	order := func(nav map[string]navLinks, name string) string {
+		links := []string{}
+		for _, link := range []*NavLink{nav[name].prev, nav[name].next} {
+			if link == nil {
+				links = append(links, "-")
+				continue
+			}
+			links = append(links, link.Title)
+		}
+		return strings.Join(links, " ")
+	}
+	for i := range pages {
+		pages[i].Meta["title"] = pages[i].Name
+	}
+
+	// the pages with a date come first, the rest by their name
+	dirNav := buildNav(pages, "date", navScopeDir)
+	if got := order(dirNav, "a.md"); got != "dated.md b.md" {
+		t.Errorf("expected a.md to be between dated.md and b.md, got %q", got)
+	}
+	if got := order(dirNav, "blog/post.md"); got != "- -" {
+		t.Errorf("expected blog/post.md to be alone in its directory, got %q", got)
+	}
+	if _, ok := dirNav["hidden.md"]; ok {
+		t.Errorf("expected hidden.md to be left out of the nav")
+	}
+
+	siteNav := buildNav(pages, "date", navScopeSite)
+	if got := order(siteNav, "b.md"); got != "a.md blog/post.md" {
+		t.Errorf("expected b.md to be between a.md and blog/post.md, got %q", got)
+	}
+}
@@ -0,0 +1,504 @@
+package alvu
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// render runs the file through the markdown conversion, the
+// layouts and the templates, the whole file is rendered in
+// memory so it can be written to the target just once
+func (af *AlvuFile) render(targetFile string) ([]byte, error) {
+	var output bytes.Buffer
+
+	// the deprecated _head.html and _tail.html only
+	// wrap the pages that don't have a layout
+	writeHeadTail := af.layout == nil && Contains([]string{".md", ".html"}, filepath.Ext(af.sourcePath))
+
+	if writeHeadTail && af.headFile != nil {
+		output.Write(af.headFile.content)
+	}
+
+	renderData := PageRenderData{
+		Meta: SiteMeta{
+			BaseURL: af.alvu.config.BaseURL,
+		},
+		Page:   af.meta,
+		Data:   mergeMapWithCheck(map[string]interface{}{"site": af.alvu.siteData}, af.data),
+		Extras: mergeMapWithCheck(af.alvu.navExtras(af.name), af.extras),
+		Pages:  af.alvu.pages,
+	}
+
+	if af.isText {
+		return af.renderText(renderData)
+	}
+
+	// Run the Markdown file through the conversion
+	// process to be able to use template variables in
+	// the markdown instead of writing them in
+	// raw HTML, unless the page is marked as `raw`
+	// in which case both template passes are skipped
+	isRaw, _ := af.meta["raw"].(bool)
+
+	var preConvertHTML bytes.Buffer
+	var shortcodeOutputs [][]byte
+	if isRaw {
+		preConvertHTML.Write(af.writeableContent)
+	} else {
+		// code blocks are kept out of the template pass
+		// since they might be documenting template syntax
+		protectedContent, codeBlocks := protectCodeBlocks(af.writeableContent)
+		protectedContent, outputs, err := af.alvu.shortcodes.Expand(protectedContent, renderData, af.sourcePath)
+		if err != nil {
+			return nil, err
+		}
+		shortcodeOutputs = outputs
+		preConvertTmpl := af.alvu.partials.Text("temporary_pre_template")
+		if _, err := preConvertTmpl.Parse(string(protectedContent)); err != nil {
+			return nil, templateError("parse", "page", af.sourcePath, err)
+		}
+		if err := preConvertTmpl.Execute(&preConvertHTML, renderData); err != nil {
+			return nil, templateError("render", "page", af.sourcePath, err)
+		}
+		restored := restoreCodeBlocks(preConvertHTML.Bytes(), codeBlocks)
+		preConvertHTML.Reset()
+		preConvertHTML.Write(restored)
+	}
+
+	var toHtml bytes.Buffer
+	if !af.isHTML {
+		toc, title, duplicateIDs, err := af.alvu.convertMarkdown(preConvertHTML.Bytes(), &toHtml)
+		if err != nil {
+			return nil, err
+		}
+		if af.alvu.config.WarnDuplicateIDs {
+			for _, duplicate := range duplicateIDs {
+				af.warn(fmt.Sprintf("heading `%v` in %v has the same id as an earlier one, using `#%v` instead of `#%v`", duplicate.Text, af.sourcePath, duplicate.ID, duplicate.Base))
+			}
+		}
+		// the content is sanitized before the layouts and the
+		// shortcodes are added since they are part of the site
+		if af.alvu.config.Sanitize {
+			sanitized := af.alvu.config.SanitizePolicy.Sanitize(toHtml.Bytes())
+			toHtml.Reset()
+			toHtml.Write(sanitized)
+		}
+		// extras are copied since the same map is
+		// shared with the files fanned out by hooks
+		renderData.Extras = mergeMapWithCheck(renderData.Extras, map[string]interface{}{"toc": toc})
+		// the layouts can then always have a title, the meta
+		// itself is left as is since it's shared with the hooks
+		if _, ok := renderData.Page["title"]; !ok && len(title) > 0 && !af.alvu.config.NoH1Title {
+			renderData.Page = mergeMapWithCheck(renderData.Page, map[string]interface{}{"title": title})
+		}
+		if af.alvu.config.RootRelativeLinks {
+			rewritten := af.alvu.rootRelativeReferences(toHtml.Bytes(), af.name)
+			toHtml.Reset()
+			toHtml.Write(rewritten)
+		}
+		af.assets = relativeReferences(toHtml.Bytes())
+		if !isRaw {
+			// the final template pass runs over the whole file
+			// so the code blocks need their braces escaped
+			escaped := escapeTemplateInCode(toHtml.Bytes())
+			toHtml.Reset()
+			toHtml.Write(escaped)
+		}
+	} else {
+		toHtml = preConvertHTML
+	}
+
+	if len(shortcodeOutputs) > 0 {
+		restored := restoreShortcodes(toHtml.Bytes(), shortcodeOutputs)
+		toHtml.Reset()
+		toHtml.Write(restored)
+	}
+
+	// code blocks aren't read like the rest of the text
+	// so they're left out of the count
+	words := len(strings.Fields(htmlText(preBlockPattern.ReplaceAllString(toHtml.String(), " "))))
+	renderData.Extras = mergeMapWithCheck(renderData.Extras, map[string]interface{}{
+		"word_count":   words,
+		"reading_time": readingTime(words, af.alvu.config.WordsPerMinute),
+	})
+
+	if af.alvu.feed != nil {
+		af.alvu.feed.AddFile(af, targetFile, toHtml.String())
+	}
+
+	if af.alvu.searchIndex != nil {
+		af.alvu.searchIndex.AddFile(af, targetFile, renderData.Page, toHtml.String())
+	}
+
+	if af.alvu.singleFile != nil && af.alvu.singleFile.Includes(af, targetFile) {
+		content, err := af.sectionContent(toHtml.Bytes(), renderData, isRaw)
+		if err != nil {
+			return nil, err
+		}
+		af.alvu.singleFile.AddFile(af, targetFile, content)
+	}
+
+	layoutData := LayoutRenderData{
+		PageRenderData: renderData,
+		Content:        template.HTML(toHtml.Bytes()),
+	}
+
+	// If a layout file was found
+	// write the converted html content into the
+	// layout template file
+
+	layout, err := af.alvu.layouts.Get(af.layout)
+	if err != nil {
+		return nil, templateError("parse", "layout", af.sourcePath, err)
+	}
+
+	toHtml.Reset()
+	if err := layout.tmpl.Execute(&toHtml, layoutData); err != nil {
+		return nil, templateError("render", "layout "+layout.name, af.sourcePath, err)
+	}
+
+	// layouts can be wrapped in a parent layout, so keep rendering
+	// the output into the parent till we reach the top most layout
+	visitedLayouts := []string{}
+	if layoutName, ok := af.meta["layout"].(string); ok && af.layout != af.baseTemplate {
+		visitedLayouts = append(visitedLayouts, layoutName)
+	}
+	for {
+		parentName := layout.parent
+		if len(parentName) == 0 {
+			break
+		}
+
+		if Contains(visitedLayouts, parentName) {
+			return nil, fmt.Errorf("layout cycle found for %v: %v -> %v", af.sourcePath, strings.Join(visitedLayouts, " -> "), parentName)
+		}
+		visitedLayouts = append(visitedLayouts, parentName)
+
+		parentLayout, err := af.alvu.namedLayouts.Get(parentName)
+		if err != nil {
+			return nil, fmt.Errorf("parent layout `%v` not found for %v", parentName, af.sourcePath)
+		}
+
+		layout, err = af.alvu.layouts.Get(parentLayout)
+		if err != nil {
+			return nil, templateError("parse", "layout", af.sourcePath, err)
+		}
+		layoutData.Content = template.HTML(toHtml.String())
+
+		toHtml.Reset()
+		if err := layout.tmpl.Execute(&toHtml, layoutData); err != nil {
+			return nil, templateError("render", "layout "+layout.name, af.sourcePath, err)
+		}
+	}
+
+	output.Write(toHtml.Bytes())
+
+	if writeHeadTail && af.tailFile != nil {
+		output.Write(af.tailFile.content)
+	}
+
+	if isRaw {
+		return af.withClientScripts(output.Bytes()), nil
+	}
+
+	onDebug(func() {
+		debugInfo("template path: %v", af.sourcePath)
+	})
+
+	t, err := af.alvu.partials.HTML(path.Join(af.sourcePath))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := t.Parse(output.String()); err != nil {
+		return nil, templateError("parse", "output", af.sourcePath, err)
+	}
+
+	var rendered bytes.Buffer
+	if err := t.Execute(&rendered, renderData); err != nil {
+		return nil, templateError("render", "output", af.sourcePath, err)
+	}
+
+	return af.withClientScripts(rendered.Bytes()), nil
+}
+
+// sectionContent runs the last template pass over just the
+// content of the page, for its section in the single file
+func (af *AlvuFile) sectionContent(content []byte, renderData PageRenderData, isRaw bool) ([]byte, error) {
+	if isRaw {
+		return content, nil
+	}
+	t, err := af.alvu.partials.HTML(af.sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := t.Parse(string(content)); err != nil {
+		return nil, templateError("parse", "output", af.sourcePath, err)
+	}
+	var rendered bytes.Buffer
+	if err := t.Execute(&rendered, renderData); err != nil {
+		return nil, templateError("render", "output", af.sourcePath, err)
+	}
+	return rendered.Bytes(), nil
+}
+
+// clientScript is a library that alvu can add to the
+// markdown pages that have the marker in them
+type clientScript struct {
+	marker string
+	// loaders are what a page that already loads
+	// the library on its own would have in it
+	loaders []string
+	script  string
+}
+
+// katexScript loads katex from the cdn and renders
+// the math on the page once it's loaded
+var katexScript = clientScript{
+	marker:  `class="math `,
+	loaders: []string{"katex", "mathjax"},
+	script: `<link rel="stylesheet" href="https://cdn.jsdelivr.net/npm/katex@0.16.9/dist/katex.min.css">
+<script defer src="https://cdn.jsdelivr.net/npm/katex@0.16.9/dist/katex.min.js"></script>
+<script defer src="https://cdn.jsdelivr.net/npm/katex@0.16.9/dist/contrib/auto-render.min.js" onload="renderMathInElement(document.body)"></script>
+`,
+}
+
+// mermaidScript loads mermaid from the cdn, which renders
+// every `<pre class="mermaid">` on the page
+var mermaidScript = clientScript{
+	marker:  `<pre class="mermaid">`,
+	loaders: []string{"mermaid.min.js", "mermaid.esm", "mermaid.initialize"},
+	script: `<script type="module">
+import mermaid from "https://cdn.jsdelivr.net/npm/mermaid@10/dist/mermaid.esm.min.mjs";
+mermaid.initialize({ startOnLoad: true });
+</script>
+`,
+}
+
+// withClientScripts adds the enabled client scripts to the
+// markdown pages that need them, unless the page already
+// loads the library on its own
+func (af *AlvuFile) withClientScripts(content []byte) []byte {
+	if af.isHTML {
+		return content
+	}
+	return af.alvu.withClientScripts(content)
+}
+
+// withClientScripts adds the client scripts turned on in
+// the config to the content that has their markers
+func (al *Alvu) withClientScripts(content []byte) []byte {
+	scripts := []clientScript{}
+	if al.config.MathScript {
+		scripts = append(scripts, katexScript)
+	}
+	if al.config.MermaidScript {
+		scripts = append(scripts, mermaidScript)
+	}
+
+	for _, script := range scripts {
+		content = script.inject(content)
+	}
+	return content
+}
+
+// inject adds the script before the closing head tag, or the
+// closing body tag for pages without a head
+func (cs clientScript) inject(content []byte) []byte {
+	if !bytes.Contains(content, []byte(cs.marker)) {
+		return content
+	}
+
+	lowerContent := bytes.ToLower(content)
+	for _, loader := range cs.loaders {
+		if bytes.Contains(lowerContent, []byte(loader)) {
+			return content
+		}
+	}
+
+	ind := bytes.Index(lowerContent, []byte("</head>"))
+	if ind == -1 {
+		ind = bytes.LastIndex(lowerContent, []byte("</body>"))
+	}
+	if ind == -1 {
+		return append(content, cs.script...)
+	}
+
+	injected := make([]byte, 0, len(content)+len(cs.script))
+	injected = append(injected, content[:ind]...)
+	injected = append(injected, cs.script...)
+	injected = append(injected, content[ind:]...)
+	return injected
+}
+
+// renderText renders the plain text files (.txt, .xml)
+// with a single template pass and nothing else
+func (af *AlvuFile) renderText(renderData PageRenderData) ([]byte, error) {
+	if isRaw, _ := af.meta["raw"].(bool); isRaw {
+		return af.writeableContent, nil
+	}
+
+	t, err := af.alvu.partials.Text(af.sourcePath).Parse(string(af.writeableContent))
+	if err != nil {
+		return nil, templateError("parse", "page", af.sourcePath, err)
+	}
+	var rendered bytes.Buffer
+	if err := t.Execute(&rendered, renderData); err != nil {
+		return nil, templateError("render", "page", af.sourcePath, err)
+	}
+	return rendered.Bytes(), nil
+}
+
+// templateError adds the file and the template (page,
+// layout or output) that failed to the error
+func templateError(action string, templateName string, sourcePath string, err error) error {
+	return fmt.Errorf("failed to %v the %v template of %v, error: %v", action, templateName, sourcePath, err)
+}
+
+// writeOutput runs the changes that need to be made on the
+// final output of the file and writes it to the target
+func (al *Alvu) writeOutput(targetFile string, content []byte) error {
+	if al.assetManifest != nil && filepath.Ext(targetFile) == ".html" {
+		content = al.assetManifest.RewriteReferences(content)
+	}
+
+	if al.config.Minify {
+		if minifier, ok := minifiers[filepath.Ext(targetFile)]; ok {
+			content = minifier(content)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(targetFile), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(targetFile, content, 0644)
+}
+
+// readingTime is the number of minutes it takes to read the
+// words, rounded up so a page with any text takes a minute
+func readingTime(words int, wordsPerMinute int) int {
+	if words == 0 || wordsPerMinute <= 0 {
+		return 0
+	}
+	return (words + wordsPerMinute - 1) / wordsPerMinute
+}
+
+// htmlText strips the tags from the html and collapses
+// the whitespace, the contents of scripts and styles
+// are left out
+func htmlText(content string) string {
+	tokenizer := xhtml.NewTokenizer(strings.NewReader(content))
+	text := &strings.Builder{}
+	skipping := ""
+	for {
+		switch tokenizer.Next() {
+		case xhtml.ErrorToken:
+			return strings.Join(strings.Fields(text.String()), " ")
+		case xhtml.StartTagToken:
+			name, _ := tokenizer.TagName()
+			if tag := string(name); len(skipping) == 0 && (tag == "script" || tag == "style") {
+				skipping = tag
+			}
+			// tags separate the words, `<p>a</p><p>b</p>` is `a b`
+			text.WriteString(" ")
+		case xhtml.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if string(name) == skipping {
+				skipping = ""
+			}
+			text.WriteString(" ")
+		case xhtml.SelfClosingTagToken:
+			text.WriteString(" ")
+		case xhtml.TextToken:
+			if len(skipping) == 0 {
+				text.Write(tokenizer.Text())
+			}
+		}
+	}
+}
+
+var fencePattern = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})")
+
+var preBlockPattern = regexp.MustCompile(`(?s)<pre[\s>].*?</pre>`)
+
+// templateDelimPattern matches the whole run of braces around a
+// delimiter, so that a `{` right before `}}` isn't left behind
+// to form a new `{{` with the escaped delimiter
+var templateDelimPattern = regexp.MustCompile(`[{}]*(\{\{|\}\})[{}]*`)
+
+// escapedDelimPattern matches the delimiters escaped
+// by escapeTemplateDelims
+var escapedDelimPattern = regexp.MustCompile(`\{\{"([{}]+)"\}\}`)
+
+func codeBlockPlaceholder(index int) []byte {
+	return []byte(fmt.Sprintf("\x00alvu:code:%d\x00\n", index))
+}
+
+// protectCodeBlocks swaps the content of the fenced code blocks
+// with placeholders, returns the replaced content and the blocks
+// to be used with restoreCodeBlocks
+func protectCodeBlocks(content []byte) ([]byte, [][]byte) {
+	var out bytes.Buffer
+	var block bytes.Buffer
+	var fence []byte
+	blocks := [][]byte{}
+
+	for _, line := range bytes.SplitAfter(content, []byte("\n")) {
+		if fence == nil {
+			out.Write(line)
+			if match := fencePattern.FindSubmatch(line); match != nil {
+				fence = match[1]
+				block.Reset()
+			}
+			continue
+		}
+
+		trimmed := bytes.TrimSpace(line)
+		isClosing := bytes.HasPrefix(trimmed, fence) && len(bytes.Trim(trimmed, string(fence[0]))) == 0
+		if !isClosing {
+			block.Write(line)
+			continue
+		}
+
+		out.Write(codeBlockPlaceholder(len(blocks)))
+		blocks = append(blocks, append([]byte{}, block.Bytes()...))
+		out.Write(line)
+		fence = nil
+	}
+
+	// an unclosed fence runs till the end of the document
+	// and is left as is
+	if fence != nil {
+		out.Write(block.Bytes())
+	}
+
+	return out.Bytes(), blocks
+}
+
+func restoreCodeBlocks(content []byte, blocks [][]byte) []byte {
+	for index, block := range blocks {
+		content = bytes.Replace(content, codeBlockPlaceholder(index), block, 1)
+	}
+	return content
+}
+
+// escapeTemplateInCode replaces the template delimiters in the
+// converted code blocks with actions that print them as is
+func escapeTemplateInCode(content []byte) []byte {
+	return preBlockPattern.ReplaceAllFunc(content, escapeTemplateDelims)
+}
+
+// escapeTemplateDelims replaces the template delimiters
+// with actions that print them as is
+func escapeTemplateDelims(content []byte) []byte {
+	return templateDelimPattern.ReplaceAllFunc(content, func(delim []byte) []byte {
+		return []byte(`{{"` + string(delim) + `"}}`)
+	})
+}
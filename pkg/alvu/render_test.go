@@ -0,0 +1,211 @@
+package alvu
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTemplateInCodeBlocksIsLeftAsIs(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"pages/post.md": "# {{.Meta.BaseURL}}\n\n```go\n{{ template \"x\" }}\n{{ .Foo }}\n```\n",
+	})
+	if err := buildSite(t, dir, Config{BaseURL: "/docs/"}); err != nil {
+		t.Fatal(err)
+	}
+
+	post := readOutput(t, dir, "post.html")
+	for _, expected := range []string{
+		`<h1 id="docs">/docs/</h1>`,
+		"<code class=\"language-go\">{{ template &quot;x&quot; }}\n{{ .Foo }}\n</code>",
+	} {
+		if !strings.Contains(post, expected) {
+			t.Errorf("expected %q in the page, got %q", expected, post)
+		}
+	}
+}
+
+func TestProtectCodeBlocksRoundTrips(t *testing.T) {
+	content := "{{.A}}\n```\n{{ template \"x\" }}\n```\n\n~~~~\n{{.B}}\n```\n~~~~\n\n```\n{{.Unclosed}}\n"
+	protected, blocks := protectCodeBlocks([]byte(content))
+
+	if len(blocks) != 2 {
+		t.Fatalf("expected 2 code blocks, got %v", len(blocks))
+	}
+	for _, action := range []string{`{{ template "x" }}`, "{{.B}}"} {
+		if strings.Contains(string(protected), action) {
+			t.Errorf("expected %q to be protected, got %q", action, protected)
+		}
+	}
+	if restored := string(restoreCodeBlocks(protected, blocks)); restored != content {
+		t.Errorf("expected the content back, got %q", restored)
+	}
+}
+
+func TestTextTemplatesSkipMarkdownAndLayouts(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"pages/_layout.html": "<main>{{.Content}}</main>",
+		"pages/_head.html":   "<head></head>",
+		"pages/robots.txt":   "# robots\nSitemap: {{.Meta.BaseURL}}sitemap.xml\n",
+		"pages/manifest.xml": `<manifest start="{{.Meta.BaseURL}}">*not emphasis*</manifest>`,
+	})
+	if err := buildSite(t, dir, Config{BaseURL: "https://example.com/"}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"robots.txt":   "# robots\nSitemap: https://example.com/sitemap.xml\n",
+		"manifest.xml": `<manifest start="https://example.com/">*not emphasis*</manifest>`,
+	}
+	for name, content := range expected {
+		if got := readOutput(t, dir, name); got != content {
+			t.Errorf("expected %v to be %q, got %q", name, content, got)
+		}
+	}
+}
+
+func TestTemplateErrorsNameTheFile(t *testing.T) {
+	tests := []struct {
+		files    map[string]string
+		expected []string
+	}{
+		{
+			files:    map[string]string{"pages/broken.md": "# Title\n\n{{ .Meta"},
+			expected: []string{"parse the page template", filepath.Join("pages", "broken.md")},
+		},
+		{
+			files:    map[string]string{"pages/broken.html": "{{ .Missing.Field }}"},
+			expected: []string{"render the page template", filepath.Join("pages", "broken.html")},
+		},
+		{
+			files: map[string]string{
+				"pages/_layout.html": "<main>{{ .Content </main>",
+				"pages/index.md":     "# Home",
+			},
+			expected: []string{"parse the layout template", filepath.Join("pages", "index.md")},
+		},
+	}
+	for _, test := range tests {
+		dir := writeSite(t, test.files)
+		err := buildSite(t, dir, Config{})
+		if err == nil {
+			t.Errorf("expected the build of %v to fail", test.files)
+			continue
+		}
+		for _, expected := range test.expected {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("expected %q in the error, got %v", expected, err)
+			}
+		}
+	}
+}
+
+func TestWordCountAndReadingTime(t *testing.T) {
+	document := strings.Join([]string{
+		"---",
+		"title: a title that isn't counted",
+		"---",
+		"# " + strings.Repeat("word ", 10),
+		"",
+		strings.Repeat("word ", 240),
+		"",
+		"```",
+		strings.Repeat("code ", 500),
+		"```",
+	}, "\n")
+	dir := writeSite(t, map[string]string{
+		"pages/_layout.html": `{{.Extras.word_count}} {{.Extras.reading_time}}`,
+		"pages/post.md":      document,
+		"pages/empty.md":     "",
+	})
+	if err := buildSite(t, dir, Config{WordsPerMinute: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readOutput(t, dir, "post.html"); got != "250 3" {
+		t.Errorf("post.html = %q, want %q", got, "250 3")
+	}
+	if got := readOutput(t, dir, "empty.html"); got != "0 0" {
+		t.Errorf("empty.html = %q, want %q", got, "0 0")
+	}
+}
+
+func TestReadingTime(t *testing.T) {
+	tests := []struct {
+		words          int
+		wordsPerMinute int
+		want           int
+	}{
+		{0, 200, 0},
+		{1, 200, 1},
+		{200, 200, 1},
+		{201, 200, 2},
+		{100, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := readingTime(tt.words, tt.wordsPerMinute); got != tt.want {
+			t.Errorf("readingTime(%v, %v) = %v, want %v", tt.words, tt.wordsPerMinute, got, tt.want)
+		}
+	}
+}
+
+func TestHeadTailByExtension(t *testing.T) {
+	files := map[string]string{
+		"pages/_head.html": "<head>",
+		"pages/_tail.html": "</tail>",
+		"pages/doc.md":     "markdown",
+		"pages/page.html":  "html",
+		"pages/page.txt":   "text",
+	}
+
+	tests := []struct {
+		name string
+		cfg  Config
+		want map[string]string
+	}{
+		{"legacy", Config{}, map[string]string{
+			"doc.html":  "<head><body><p>markdown</p>\n</body></tail>",
+			"page.html": "<head><body>html</body></tail>",
+			"page.txt":  "text",
+		}},
+		{"no legacy", Config{NoLegacyHeadTail: true}, map[string]string{
+			"doc.html":  "<body><p>markdown</p>\n</body>",
+			"page.html": "<body>html</body>",
+			"page.txt":  "text",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeSite(t, files)
+			if err := buildSite(t, dir, tt.cfg); err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range tt.want {
+				if got := readOutput(t, dir, name); got != want {
+					t.Errorf("%v = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHTMLPagesWithoutALayoutGetHeadAndTail(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"pages/_head.html":        "<head>",
+		"pages/_tail.html":        "</tail>",
+		"pages/page.html":         "page",
+		"pages/blog/_layout.html": "blog|{{.Content}}",
+		"pages/blog/post.html":    "post",
+	})
+	if err := buildSite(t, dir, Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := readOutput(t, dir, "page.html"), "<head><body>page</body></tail>"; got != want {
+		t.Errorf("page.html = %q, want %q", got, want)
+	}
+	// a page with a layout isn't wrapped in the head and tail
+	if got, want := readOutput(t, dir, "blog/post.html"), "blog|post"; got != want {
+		t.Errorf("blog/post.html = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,238 @@
+package alvu
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/barelyhuman/go/color"
+)
+
+// Timings keeps how long every phase of the build took along
+// with the time of each file, printed at the end with `-timing`.
+// The methods do nothing on a nil Timings so the phases can
+// be recorded without checking if the timing is on
+type Timings struct {
+	lock      *sync.Mutex
+	started   time.Time
+	phases    []timing
+	files     []timing
+	steps     map[string]time.Duration
+	stepNames []string
+}
+
+type timing struct {
+	name     string
+	duration time.Duration
+}
+
+func NewTimings() *Timings {
+	return &Timings{
+		lock:    &sync.Mutex{},
+		started: time.Now(),
+		steps:   map[string]time.Duration{},
+	}
+}
+
+// Phase records the time since started for the phase
+func (t *Timings) Phase(name string, started time.Time) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.phases = append(t.phases, timing{name: name, duration: time.Since(started)})
+}
+
+// File records the time it took to build a single file
+func (t *Timings) File(name string, duration time.Duration) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.files = append(t.files, timing{name: name, duration: duration})
+}
+
+// Step adds to the total time of a step of building the files
+// (hooks, render), which are summed across the workers
+func (t *Timings) Step(name string, duration time.Duration) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, ok := t.steps[name]; !ok {
+		t.stepNames = append(t.stepNames, name)
+	}
+	t.steps[name] += duration
+}
+
+func (t *Timings) Print(w io.Writer) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	cs := &color.ColorString{}
+	fmt.Fprintln(w, cs.Blue(logPrefix).Green("Timing").String())
+
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "PHASE\tTIME")
+	for _, phase := range t.phases {
+		fmt.Fprintf(table, "%v\t%v\n", phase.name, roundDuration(phase.duration))
+	}
+	fmt.Fprintf(table, "total\t%v\n", roundDuration(time.Since(t.started)))
+	table.Flush()
+
+	if len(t.files) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w)
+	var total time.Duration
+	fastest, slowest := t.files[0], t.files[0]
+	for _, file := range t.files {
+		total += file.duration
+		if file.duration < fastest.duration {
+			fastest = file
+		}
+		if file.duration > slowest.duration {
+			slowest = file
+		}
+	}
+
+	table = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(table, "files\t%v\n", len(t.files))
+	fmt.Fprintf(table, "min\t%v\t%v\n", roundDuration(fastest.duration), fastest.name)
+	fmt.Fprintf(table, "max\t%v\t%v\n", roundDuration(slowest.duration), slowest.name)
+	fmt.Fprintf(table, "avg\t%v\n", roundDuration(total/time.Duration(len(t.files))))
+	for _, name := range t.stepNames {
+		fmt.Fprintf(table, "%v (all files)\t%v\n", name, roundDuration(t.steps[name]))
+	}
+	table.Flush()
+}
+
+// roundDuration keeps the durations readable
+func roundDuration(duration time.Duration) time.Duration {
+	if duration > time.Second {
+		return duration.Round(time.Millisecond)
+	}
+	return duration.Round(10 * time.Microsecond)
+}
+
+// BuildReport collects what happened to every file during
+// the build, written as json with `-report`
+type BuildReport struct {
+	lock  *sync.Mutex
+	files map[string]FileReport
+}
+
+type buildReportData struct {
+	DurationMs float64      `json:"duration_ms"`
+	Files      []FileReport `json:"files"`
+}
+
+// FileReport is a single source file of the build report, the
+// status is one of built, cached, copied, skipped or failed
+type FileReport struct {
+	Source     string         `json:"source"`
+	Status     string         `json:"status"`
+	Reason     string         `json:"reason,omitempty"`
+	Outputs    []OutputReport `json:"outputs"`
+	Hooked     bool           `json:"hooked"`
+	DurationMs float64        `json:"duration_ms"`
+	Warnings   []string       `json:"warnings"`
+}
+
+type OutputReport struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+func NewBuildReport() *BuildReport {
+	return &BuildReport{
+		lock:  &sync.Mutex{},
+		files: map[string]FileReport{},
+	}
+}
+
+// Add records the file once it's been built
+func (br *BuildReport) Add(af *AlvuFile, cached bool, err error, duration time.Duration) {
+	fileReport := FileReport{
+		Source:     af.sourcePath,
+		Outputs:    []OutputReport{},
+		Hooked:     af.hooked,
+		DurationMs: float64(duration.Microseconds()) / 1000,
+		Warnings:   af.warnings,
+	}
+	if fileReport.Warnings == nil {
+		fileReport.Warnings = []string{}
+	}
+
+	outputs := []string{}
+	switch {
+	case err != nil:
+		fileReport.Status = "failed"
+		fileReport.Reason = err.Error()
+	case af.skip:
+		fileReport.Status = "skipped"
+		fileReport.Reason = af.skipReason
+	case af.passthrough:
+		fileReport.Status = "copied"
+		outputs = append(outputs, af.destPath)
+	default:
+		fileReport.Status = "built"
+		if cached {
+			fileReport.Status = "cached"
+		}
+		for _, file := range af.builtFiles() {
+			outputs = append(outputs, file.targetFile(string(file.targetName)))
+			outputs = append(outputs, file.aliases...)
+		}
+	}
+
+	for _, output := range outputs {
+		outputReport := OutputReport{Path: output}
+		if info, err := os.Stat(output); err == nil {
+			outputReport.Size = info.Size()
+		}
+		fileReport.Outputs = append(fileReport.Outputs, outputReport)
+	}
+
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	br.files[af.sourcePath] = fileReport
+}
+
+// Write writes the report in the same order as the files, the
+// ones that weren't built since the build stopped are left out
+func (br *BuildReport) Write(reportPath string, files []*AlvuFile, duration time.Duration) error {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+
+	data := buildReportData{
+		DurationMs: float64(duration.Microseconds()) / 1000,
+		Files:      []FileReport{},
+	}
+	for _, af := range files {
+		if fileReport, ok := br.files[af.sourcePath]; ok {
+			data.Files = append(data.Files, fileReport)
+		}
+	}
+
+	content, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(reportPath), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(reportPath, content, 0644)
+}
@@ -0,0 +1,215 @@
+package alvu
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/yuin/goldmark/renderer/html"
+	xhtml "golang.org/x/net/html"
+	"gopkg.in/yaml.v3"
+)
+
+// SanitizePolicy is the allowlist of the sanitizer, set under
+// `sanitize-policy` in the config, eg:
+//
+//	sanitize-policy:
+//	  tags:
+//	    p: []
+//	    video: [src, controls]
+//	  attributes: [id, class]
+//
+// the tags and attributes that are set replace the ones
+// of the DefaultSanitizePolicy
+type SanitizePolicy struct {
+	// Tags are the tags that are kept, with the attributes
+	// that are kept on them
+	Tags map[string][]string `yaml:"tags"`
+	// Attributes are kept on all of the Tags
+	Attributes []string `yaml:"attributes"`
+}
+
+// DefaultSanitizePolicy allows the common formatting tags,
+// the ones goldmark writes included
+func DefaultSanitizePolicy() *SanitizePolicy {
+	return &SanitizePolicy{
+		Tags: map[string][]string{
+			"a":          {"href", "name", "rel"},
+			"abbr":       {},
+			"b":          {},
+			"blockquote": {"cite"},
+			"br":         {},
+			"caption":    {},
+			"cite":       {},
+			"code":       {},
+			"col":        {"span"},
+			"colgroup":   {"span"},
+			"dd":         {},
+			"del":        {"cite", "datetime"},
+			"details":    {"open"},
+			"dfn":        {},
+			"div":        {},
+			"dl":         {},
+			"dt":         {},
+			"em":         {},
+			"figcaption": {},
+			"figure":     {},
+			"h1":         {},
+			"h2":         {},
+			"h3":         {},
+			"h4":         {},
+			"h5":         {},
+			"h6":         {},
+			"hr":         {},
+			"i":          {},
+			"img":        {"src", "alt", "width", "height", "loading"},
+			"input":      {"type", "checked", "disabled"},
+			"ins":        {"cite", "datetime"},
+			"kbd":        {},
+			"li":         {"value"},
+			"mark":       {},
+			"ol":         {"start", "reversed", "type"},
+			"p":          {},
+			"pre":        {},
+			"q":          {"cite"},
+			"s":          {},
+			"samp":       {},
+			"small":      {},
+			"span":       {},
+			"strong":     {},
+			"sub":        {},
+			"summary":    {},
+			"sup":        {},
+			"table":      {},
+			"tbody":      {},
+			"td":         {"colspan", "rowspan", "align"},
+			"tfoot":      {},
+			"th":         {"colspan", "rowspan", "align", "scope"},
+			"thead":      {},
+			"time":       {"datetime"},
+			"tr":         {},
+			"u":          {},
+			"ul":         {},
+			"var":        {},
+		},
+		Attributes: []string{"id", "class", "title", "lang", "dir", "role"},
+	}
+}
+
+// ConfigSanitizePolicy reads the sanitize policy from the
+// config, returns the DefaultSanitizePolicy if it isn't set
+func ConfigSanitizePolicy(config map[string]interface{}) (*SanitizePolicy, error) {
+	policy := DefaultSanitizePolicy()
+	value, ok := config["sanitize-policy"]
+	if !ok || value == nil {
+		return policy, nil
+	}
+
+	content, err := yaml.Marshal(value)
+	if err != nil {
+		return policy, fmt.Errorf("invalid sanitize-policy in the config, error: %v", err)
+	}
+	decoded := SanitizePolicy{}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&decoded); err != nil {
+		return policy, fmt.Errorf("invalid sanitize-policy in the config, error: %v", err)
+	}
+
+	// the tokenizer lower cases the names of
+	// the tags and the attributes it reads
+	if decoded.Tags != nil {
+		policy.Tags = map[string][]string{}
+		for tag, attrs := range decoded.Tags {
+			lowered := []string{}
+			for _, attr := range attrs {
+				lowered = append(lowered, strings.ToLower(attr))
+			}
+			policy.Tags[strings.ToLower(tag)] = lowered
+		}
+	}
+	if decoded.Attributes != nil {
+		policy.Attributes = []string{}
+		for _, attr := range decoded.Attributes {
+			policy.Attributes = append(policy.Attributes, strings.ToLower(attr))
+		}
+	}
+	return policy, nil
+}
+
+// sanitizeDropContent are the tags that are left out along
+// with everything in them, unless the policy allows them
+var sanitizeDropContent = []string{"script", "style", "iframe", "object", "embed", "template", "textarea", "noscript"}
+
+// sanitizeURLAttrs are the attributes with urls, the
+// ones that could run scripts are left out
+var sanitizeURLAttrs = []string{"href", "src", "cite", "poster"}
+
+// Sanitize keeps the tags and attributes of the html that are
+// in the policy, the rest of the tags are left out with their
+// text kept unless they're one of the sanitizeDropContent tags.
+// The text is kept as is, so the template actions in it still
+// work and the shortcode placeholders are the only comments kept
+func (p *SanitizePolicy) Sanitize(content []byte) []byte {
+	var out bytes.Buffer
+	tokenizer := xhtml.NewTokenizer(bytes.NewReader(content))
+	dropping := ""
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == xhtml.ErrorToken {
+			return out.Bytes()
+		}
+		// Token unescapes the text in place so the
+		// raw text is copied before reading it
+		raw := append([]byte{}, tokenizer.Raw()...)
+		token := tokenizer.Token()
+
+		if len(dropping) > 0 {
+			if tokenType == xhtml.EndTagToken && token.Data == dropping {
+				dropping = ""
+			}
+			continue
+		}
+
+		switch tokenType {
+		case xhtml.TextToken:
+			out.Write(raw)
+		case xhtml.CommentToken:
+			if shortcodePlaceholderPattern.Match(raw) {
+				out.Write(raw)
+			}
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken, xhtml.EndTagToken:
+			allowedAttrs, ok := p.Tags[token.Data]
+			if !ok {
+				if tokenType == xhtml.StartTagToken && Contains(sanitizeDropContent, token.Data) {
+					dropping = token.Data
+				}
+				continue
+			}
+			attrs := []xhtml.Attribute{}
+			for _, attr := range token.Attr {
+				if !Contains(allowedAttrs, attr.Key) && !Contains(p.Attributes, attr.Key) {
+					continue
+				}
+				if Contains(sanitizeURLAttrs, attr.Key) && isDangerousURL(attr.Val) {
+					continue
+				}
+				attrs = append(attrs, attr)
+			}
+			token.Attr = attrs
+			out.WriteString(token.String())
+		}
+	}
+}
+
+// isDangerousURL checks the url the way a browser reads it, the
+// scheme isn't case sensitive and the tabs and newlines in it,
+// along with the spaces and control characters before it, are
+// left out
+func isDangerousURL(url string) bool {
+	url = strings.TrimLeftFunc(url, func(r rune) bool {
+		return r <= ' '
+	})
+	url = strings.NewReplacer("\t", "", "\n", "", "\r", "").Replace(url)
+	return html.IsDangerousURL([]byte(strings.ToLower(url)))
+}
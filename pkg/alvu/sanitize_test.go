@@ -0,0 +1,89 @@
+package alvu
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"allowed", `<p class="intro" id="top">text <em>here</em></p>`, `<p class="intro" id="top">text <em>here</em></p>`},
+		{"text", `a &lt; b {{.Page.title}}`, `a &lt; b {{.Page.title}}`},
+		{"tag outside the allowlist", `<font color="red">red</font> text`, `red text`},
+		{"dropped with its content", `<script>alert(1)</script><style>p{}</style><iframe src="x">frame</iframe>ok`, `ok`},
+		{"attribute outside the allowlist", `<p onclick="alert(1)" style="color:red">a</p>`, `<p>a</p>`},
+		{"event handler", `<img src="a.png" onerror="alert(1)">`, `<img src="a.png">`},
+		{"comment", `<!-- note -->a`, `a`},
+		{"nested", `<div><span><b>x</b></span></div>`, `<div><span><b>x</b></span></div>`},
+		{"nested in dropped", `<p><script><b>x</b></script>y</p>`, `<p>y</p>`},
+		{"nested outside the allowlist", `<section><p>a<font><b>b</b></font></p></section>`, `<p>a<b>b</b></p>`},
+		{"unclosed", `<p><b>bold`, `<p><b>bold`},
+		{"unclosed dropped", `a<script>alert(1)`, `a`},
+		{"unclosed tag", `a<img src="x.png" onerror="alert(1)"`, `a`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(DefaultSanitizePolicy().Sanitize([]byte(tt.content))); got != tt.want {
+				t.Errorf("Sanitize(%q) = %q, want %q", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeURLs(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{`<a href="https://example.com">a</a>`, `<a href="https://example.com">a</a>`},
+		{`<a href="/blog/post">a</a>`, `<a href="/blog/post">a</a>`},
+		{`<a href="#top">a</a>`, `<a href="#top">a</a>`},
+		{`<a href="mailto:me@example.com">a</a>`, `<a href="mailto:me@example.com">a</a>`},
+		{`<a href="javascript:alert(1)">a</a>`, `<a>a</a>`},
+		{`<a href=" JavaScript:alert(1)">a</a>`, `<a>a</a>`},
+		{"<a href=\"java\tscript:alert(1)\">a</a>", `<a>a</a>`},
+		{"<a href=\"\x01javascript:alert(1)\">a</a>", `<a>a</a>`},
+		{`<a href="&#106;avascript:alert(1)">a</a>`, `<a>a</a>`},
+		{`<a href="vbscript:msgbox(1)">a</a>`, `<a>a</a>`},
+		{`<a href="data:text/html,<script>alert(1)</script>">a</a>`, `<a>a</a>`},
+		{`<img src="data:image/png;base64,AAAA">`, `<img src="data:image/png;base64,AAAA">`},
+		{`<blockquote cite="javascript:alert(1)">q</blockquote>`, `<blockquote>q</blockquote>`},
+	}
+	for _, tt := range tests {
+		if got := string(DefaultSanitizePolicy().Sanitize([]byte(tt.content))); got != tt.want {
+			t.Errorf("Sanitize(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestConfigSanitizePolicy(t *testing.T) {
+	policy, err := ConfigSanitizePolicy(map[string]interface{}{
+		"sanitize-policy": map[string]interface{}{
+			"tags":       map[string]interface{}{"VIDEO": []interface{}{"SRC", "controls"}, "p": []interface{}{}},
+			"attributes": []interface{}{"Class"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &SanitizePolicy{
+		Tags:       map[string][]string{"video": {"src", "controls"}, "p": {}},
+		Attributes: []string{"class"},
+	}
+	if !reflect.DeepEqual(policy, want) {
+		t.Errorf("ConfigSanitizePolicy() = %v, want %v", policy, want)
+	}
+
+	content := `<p class="a" id="b"><video src="v.mp4" controls autoplay></video><em>x</em></p>`
+	if got, want := string(policy.Sanitize([]byte(content))), `<p class="a"><video src="v.mp4" controls=""></video>x</p>`; got != want {
+		t.Errorf("Sanitize(%q) = %q, want %q", content, got, want)
+	}
+
+	if _, err := ConfigSanitizePolicy(map[string]interface{}{"sanitize-policy": map[string]interface{}{"tag": nil}}); err == nil {
+		t.Errorf("ConfigSanitizePolicy() with an unknown field, want an error")
+	}
+}
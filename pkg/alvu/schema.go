@@ -0,0 +1,179 @@
+package alvu
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	luaAlvu "github.com/barelyhuman/alvu/lua/alvu"
+	"gopkg.in/yaml.v3"
+)
+
+var schemaFiles = []string{"alvu.schema.yaml", "alvu.schema.yml", "alvu.schema.json"}
+
+// schemaTypes are the types a field of the MetaSchema can have
+var schemaTypes = []string{"string", "number", "bool", "date", "list", "map"}
+
+// MetaSchema describes the front matter the pages should
+// have, it's read from `alvu.schema.yaml` in the base path
+//
+//	paths:
+//	  - blog
+//	fields:
+//	  title:
+//	    type: string
+//	    required: true
+//	  date:
+//	    type: date
+type MetaSchema struct {
+	// Paths are globs relative to the pages, with the same
+	// syntax as the ExcludePatterns, the markdown and html
+	// pages are checked when there's none
+	Paths  []string               `yaml:"paths"`
+	Fields map[string]SchemaField `yaml:"fields"`
+}
+
+// SchemaField is the type of a front matter key, one of
+// the schemaTypes, a field without a type can be anything
+type SchemaField struct {
+	Type     string `yaml:"type"`
+	Required bool   `yaml:"required"`
+}
+
+// LoadMetaSchema reads the schema from the base path,
+// the schema is nil if the site doesn't have one
+func LoadMetaSchema(basePath string) (*MetaSchema, error) {
+	for _, schemaFile := range schemaFiles {
+		schemaPath := path.Join(basePath, schemaFile)
+		content, err := os.ReadFile(schemaPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading schema, error: %v", err)
+		}
+
+		schema := &MetaSchema{}
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(schema); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("invalid schema in %v, error: %v", schemaPath, err)
+		}
+		if err := schema.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid schema in %v, error: %v", schemaPath, err)
+		}
+
+		onDebug(func() {
+			debugInfo("Loaded schema from %v", schemaPath)
+		})
+		return schema, nil
+	}
+	return nil, nil
+}
+
+// Validate checks the paths and the types of the fields
+func (ms *MetaSchema) Validate() error {
+	for _, schemaPath := range ms.Paths {
+		for _, segment := range strings.Split(schemaPath, "/") {
+			if _, err := path.Match(segment, ""); err != nil {
+				return fmt.Errorf("invalid path `%v`, error: %v", schemaPath, err)
+			}
+		}
+	}
+	for name, field := range ms.Fields {
+		if len(field.Type) > 0 && !Contains(schemaTypes, field.Type) {
+			return fmt.Errorf("invalid type `%v` for `%v`, use one of %v", field.Type, name, strings.Join(schemaTypes, ", "))
+		}
+	}
+	return nil
+}
+
+// Applies checks if the file, relative to the pages,
+// should be checked against the schema
+func (ms *MetaSchema) Applies(fileName string) bool {
+	if len(ms.Paths) == 0 {
+		return Contains([]string{".md", ".html"}, filepath.Ext(fileName))
+	}
+	return ExcludePatterns(ms.Paths).MatchNested(filepath.ToSlash(fileName))
+}
+
+// Check lists the fields of the meta that are missing or
+// don't have the type from the schema, in order of their name
+func (ms *MetaSchema) Check(meta map[string]interface{}) []string {
+	names := make([]string, 0, len(ms.Fields))
+	for name := range ms.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	violations := []string{}
+	for _, name := range names {
+		field := ms.Fields[name]
+		value, ok := meta[name]
+		if !ok || value == nil {
+			if field.Required {
+				violations = append(violations, "missing required field `"+name+"`")
+			}
+			continue
+		}
+		if !field.Matches(value) {
+			violations = append(violations, fmt.Sprintf("field `%v` should be a %v, got `%v`", name, field.Type, value))
+		}
+	}
+	return violations
+}
+
+// Matches checks if the value has the type of the field
+func (sf SchemaField) Matches(value interface{}) bool {
+	switch sf.Type {
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		switch value.(type) {
+		case int, int64, uint64, float64:
+			return true
+		}
+		return false
+	case "bool":
+		_, ok := value.(bool)
+		return ok
+	case "date":
+		_, ok := luaAlvu.ParseDate(value)
+		return ok
+	case "list":
+		_, ok := value.([]interface{})
+		return ok
+	case "map":
+		_, ok := value.(map[string]interface{})
+		return ok
+	}
+	return true
+}
+
+// checkSchema warns about the front matter that doesn't match
+// the schema, or fails with all of it when building with Strict
+func (af *AlvuFile) checkSchema() error {
+	schema := af.alvu.schema
+	if schema == nil || !schema.Applies(af.name) {
+		return nil
+	}
+	violations := schema.Check(af.meta)
+	if len(violations) == 0 {
+		return nil
+	}
+	if af.alvu.config.Strict {
+		return fmt.Errorf("invalid front matter in %v: %v", af.sourcePath, strings.Join(violations, ", "))
+	}
+	for _, violation := range violations {
+		af.warn(violation + " in " + af.sourcePath)
+	}
+	return nil
+}
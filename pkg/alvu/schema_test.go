@@ -0,0 +1,107 @@
+package alvu
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSchemaCheck(t *testing.T) {
+	schema := &MetaSchema{Fields: map[string]SchemaField{
+		"title": {Type: "string", Required: true},
+		"date":  {Type: "date", Required: true},
+		"tags":  {Type: "list"},
+		"extra": {},
+	}}
+
+	tests := []struct {
+		name string
+		meta map[string]interface{}
+		want []string
+	}{
+		{"valid", map[string]interface{}{
+			"title": "post",
+			"date":  "2024-01-02",
+			"tags":  []interface{}{"go"},
+			"extra": 1,
+		}, []string{}},
+		{"missing required", map[string]interface{}{
+			"tags": []interface{}{"go"},
+		}, []string{
+			"missing required field `date`",
+			"missing required field `title`",
+		}},
+		{"wrong type", map[string]interface{}{
+			"title": 1,
+			"date":  "someday",
+			"tags":  "go",
+		}, []string{
+			"field `date` should be a date, got `someday`",
+			"field `tags` should be a list, got `go`",
+			"field `title` should be a string, got `1`",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := schema.Check(tt.meta); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchemaWarnsOrFailsWithStrict(t *testing.T) {
+	files := map[string]string{
+		"alvu.schema.yaml": "fields:\n  title:\n    type: string\n    required: true\n  rating:\n    type: number\n",
+		"pages/good.md":    "---\ntitle: good\nrating: 5\n---\ngood",
+		"pages/bad.md":     "---\nrating: high\n---\nbad",
+	}
+
+	t.Run("warn", func(t *testing.T) {
+		dir := writeSite(t, files)
+		reportPath := filepath.Join(dir, "report.json")
+		if err := buildSite(t, dir, Config{Report: reportPath}); err != nil {
+			t.Fatal(err)
+		}
+
+		content, err := os.ReadFile(reportPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var report buildReportData
+		if err := json.Unmarshal(content, &report); err != nil {
+			t.Fatal(err)
+		}
+		warnings := map[string][]string{}
+		for _, file := range report.Files {
+			warnings[filepath.Base(file.Source)] = file.Warnings
+		}
+		if len(warnings["good.md"]) != 0 {
+			t.Errorf("good.md warnings = %q, want none", warnings["good.md"])
+		}
+		if len(warnings["bad.md"]) != 2 {
+			t.Fatalf("bad.md warnings = %q, want 2", warnings["bad.md"])
+		}
+		for _, warning := range warnings["bad.md"] {
+			if !strings.HasSuffix(warning, filepath.Join("pages", "bad.md")) {
+				t.Errorf("warning %q doesn't name the file", warning)
+			}
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		dir := writeSite(t, files)
+		err := buildSite(t, dir, Config{Strict: true})
+		if err == nil {
+			t.Fatal("Build() with Strict, want an error")
+		}
+		for _, want := range []string{"bad.md", "`title`", "`rating`"} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("Build() = %v, want it to contain %v", err, want)
+			}
+		}
+	})
+}
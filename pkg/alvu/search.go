@@ -0,0 +1,101 @@
+package alvu
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// SearchIndex collects the title, url and text of the pages
+// for client side search libraries, pages with `noindex: true`
+// in their meta are left out
+type SearchIndex struct {
+	lock    *sync.Mutex
+	entries map[string]SearchEntry
+}
+
+type SearchEntry struct {
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Content string `json:"content"`
+}
+
+const searchIndexFile = "search-index.json"
+
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{
+		lock:    &sync.Mutex{},
+		entries: map[string]SearchEntry{},
+	}
+}
+
+// AddFile adds the page with the text of the converted content,
+// the page is the meta it's rendered with so the title from the
+// first heading is used if the meta has none
+func (si *SearchIndex) AddFile(af *AlvuFile, targetFile string, page map[string]interface{}, contentHTML string) {
+	if filepath.Ext(targetFile) != ".html" || af.name == "404.html" || af.generated {
+		return
+	}
+	if isTruthy(page["noindex"]) {
+		return
+	}
+
+	url, err := af.alvu.outputURL(targetFile)
+	if err != nil {
+		return
+	}
+
+	entry := SearchEntry{
+		URL:     url,
+		Content: htmlText(escapedDelimPattern.ReplaceAllString(contentHTML, "$1")),
+	}
+	// the title from the first heading is escaped html
+	if title, ok := page["title"]; ok && title != nil {
+		entry.Title = xhtml.UnescapeString(fmt.Sprint(title))
+	} else {
+		entry.Title = string(af.targetName)
+	}
+
+	si.add(targetFile, entry)
+}
+
+func (si *SearchIndex) add(targetFile string, entry SearchEntry) {
+	si.lock.Lock()
+	defer si.lock.Unlock()
+	si.entries[targetFile] = entry
+}
+
+func (si *SearchIndex) get(targetFile string) (SearchEntry, bool) {
+	si.lock.Lock()
+	defer si.lock.Unlock()
+	entry, ok := si.entries[targetFile]
+	return entry, ok
+}
+
+// Write writes the entries as a json array, ordered by the url
+func (si *SearchIndex) Write(outPath string) error {
+	si.lock.Lock()
+	defer si.lock.Unlock()
+
+	entries := []SearchEntry{}
+	for _, entry := range si.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].URL < entries[j].URL
+	})
+
+	content := &bytes.Buffer{}
+	encoder := json.NewEncoder(content)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(entries); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(outPath, searchIndexFile), content.Bytes(), 0644)
+}
@@ -0,0 +1,624 @@
+package alvu
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/barelyhuman/go/color"
+	"golang.org/x/net/websocket"
+)
+
+func (al *Alvu) runServer(ctx context.Context, port string) error {
+	normalizedPort := port
+
+	if !strings.HasPrefix(normalizedPort, ":") {
+		normalizedPort = ":" + normalizedPort
+	}
+
+	tlsConfig, err := al.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+
+	// a failing live reload server stops the main one as well
+	serverCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var reloadErrs chan error
+
+	mux := http.NewServeMux()
+	handler := al.ServeFS(os.DirFS(al.outPath))
+	if al.config.NoCompress {
+		mux.Handle("/", handler)
+	} else {
+		mux.Handle("/", compressHandler(handler))
+	}
+
+	if al.liveReload {
+		reloadPort := al.config.ReloadPort
+		if len(reloadPort) == 0 || strings.TrimPrefix(reloadPort, ":") == strings.TrimPrefix(port, ":") {
+			al.AddWebsocketHandler(mux)
+		} else {
+			reloadErrs = make(chan error, 1)
+			go func() {
+				err := al.runReloadServer(serverCtx, reloadPort, tlsConfig)
+				if err != nil {
+					cancel()
+				}
+				reloadErrs <- err
+			}()
+		}
+	}
+
+	// the url is printed once listening since
+	// `-port 0` picks any port that's free
+	onListen := func(addr net.Addr) {
+		_, listenPort, _ := net.SplitHostPort(addr.String())
+		serverURL := scheme + "://localhost:" + listenPort
+
+		cs := &color.ColorString{}
+		cs.Blue(logPrefix).Green("Serving on").Reset(" ").Cyan(serverURL)
+		fmt.Println(cs.String())
+
+		if !al.config.Open {
+			return
+		}
+		openURL := joinURL(serverURL, baseURLPath(al.config.BaseURL))
+		if err := openBrowser(openURL); err != nil {
+			warning := &color.ColorString{}
+			warning.Yellow(logPrefix).Yellow("[WARN] failed to open the browser, error: " + err.Error())
+			fmt.Println(warning.String())
+		}
+	}
+
+	err = listenAndServe(serverCtx, normalizedPort, mux, tlsConfig, onListen)
+	if ctx.Err() != nil {
+		cs := &color.ColorString{}
+		cs.Blue(logPrefix).Yellow("Shutting down")
+		fmt.Println(cs.String())
+	}
+	if reloadErrs != nil {
+		cancel()
+		if reloadErr := <-reloadErrs; reloadErr != nil {
+			return reloadErr
+		}
+	}
+	return serverError(err, "`-port`")
+}
+
+// serverError converts the error from the server into something
+// more readable, a server that was closed isn't an error
+func serverError(err error, portFlag string) error {
+	if err == nil || errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	if errors.Is(err, syscall.EADDRINUSE) || strings.Contains(err.Error(), "address already in use") {
+		return fmt.Errorf("port already in use, use another port with the %v flag instead", portFlag)
+	}
+	return fmt.Errorf("failed to start the server, error: %v", err)
+}
+
+// runReloadServer serves the live reload socket on it's own
+// port when `-reload-port` differs from the server's port
+func (al *Alvu) runReloadServer(ctx context.Context, port string, tlsConfig *tls.Config) error {
+	mux := http.NewServeMux()
+	al.AddWebsocketHandler(mux)
+	err := listenAndServe(ctx, ":"+strings.TrimPrefix(port, ":"), mux, tlsConfig, nil)
+	if err := serverError(err, "`-reload-port`"); err != nil {
+		return fmt.Errorf("live reload: %v", err)
+	}
+	return nil
+}
+
+// shutdownTimeout is how long the server waits for
+// the requests in flight when it's shutting down
+const shutdownTimeout = 5 * time.Second
+
+// listenAndServe serves over https when there's a tls config
+// and http otherwise, till the context is cancelled
+func listenAndServe(ctx context.Context, addr string, handler http.Handler, tlsConfig *tls.Config, onListen func(net.Addr)) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	if onListen != nil {
+		onListen(listener.Addr())
+	}
+
+	server := &http.Server{
+		Addr:      addr,
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+	}
+
+	shutdown := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		shutdown <- server.Shutdown(shutdownCtx)
+	}()
+
+	if tlsConfig == nil {
+		err = server.Serve(listener)
+	} else {
+		err = server.ServeTLS(listener, "", "")
+	}
+	// serving stops as soon as the shutdown starts,
+	// wait for it to be done with the open requests
+	if errors.Is(err, http.ErrServerClosed) {
+		return <-shutdown
+	}
+	return err
+}
+
+// openBrowser opens the url with the default browser of the OS
+func openBrowser(openURL string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", openURL)
+	case "windows":
+		cmd = exec.Command("cmd", "/c", "start", "", openURL)
+	default:
+		cmd = exec.Command("xdg-open", openURL)
+	}
+	return cmd.Start()
+}
+
+// tlsConfig loads the certificate from the config or generates
+// a self signed one for localhost, returns nil if the server
+// doesn't need to use tls
+func (al *Alvu) tlsConfig() (*tls.Config, error) {
+	hasCert := len(al.config.TLSCert) > 0 || len(al.config.TLSKey) > 0
+	if !al.config.TLS && !hasCert {
+		return nil, nil
+	}
+
+	if hasCert {
+		if len(al.config.TLSCert) == 0 || len(al.config.TLSKey) == 0 {
+			return nil, errors.New("both `-cert` and `-key` are needed to use a certificate")
+		}
+		cert, err := tls.LoadX509KeyPair(al.config.TLSCert, al.config.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the certificate, error: %v", err)
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+	}
+
+	cert, err := selfSignedCert()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate a certificate, error: %v", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// selfSignedCert generates a certificate for localhost that's
+// only kept in memory for as long as the server is running
+func selfSignedCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	certTemplate := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"alvu"}},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &certTemplate, &certTemplate, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}, nil
+}
+
+// ServeHandler serves the compiled files from the output directory
+func (al *Alvu) ServeHandler(rw http.ResponseWriter, req *http.Request) {
+	al.ServeFS(os.DirFS(al.outPath)).ServeHTTP(rw, req)
+}
+
+// ServeFS serves the compiled files from the filesystem, which is
+// the output directory when serving, with the clean urls and the
+// 404.html of the site
+func (al *Alvu) ServeFS(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		requestPath, ok := sanitizeRequestPath(req.URL.Path)
+		if !ok {
+			http.Error(rw, "403, Forbidden", http.StatusForbidden)
+			return
+		}
+
+		// the names in a fs.FS don't start with a slash
+		// and the root directory is `.`
+		name := strings.TrimPrefix(requestPath, "/")
+		if len(name) == 0 {
+			name = "."
+		}
+		if !fs.ValidPath(name) {
+			http.Error(rw, "403, Forbidden", http.StatusForbidden)
+			return
+		}
+
+		// check if the requested file already exists
+		info, err := fs.Stat(fsys, name)
+
+		// if it's a directory, we look for a index.html
+		// inside the directory to return instead, the
+		// directories themselves are never listed
+		if err == nil {
+			if info.IsDir() {
+				name = path.Join(name, "index.html")
+				if _, err := fs.Stat(fsys, name); err != nil {
+					http.Error(rw, "403, Forbidden", http.StatusForbidden)
+					return
+				}
+			}
+
+			al.serveFile(rw, req, fsys, name)
+			return
+		}
+
+		// if neither a directory or file was found
+		// try a secondary case where the file might be missing
+		// a `.html` extension for cleaner url so append a .html
+		// to look for the file.
+		name = normalizeFilePath(name)
+		if _, err := fs.Stat(fsys, name); err != nil {
+			al.notFoundHandler(rw, req, fsys)
+			return
+		}
+
+		al.serveFile(rw, req, fsys, name)
+	})
+}
+
+// compressibleTypes are the content types that are worth
+// compressing, images, archives, etc are already compressed
+var compressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/rss+xml",
+	"application/feed+json",
+	"image/svg+xml",
+}
+
+// compressHandler compresses the text responses with gzip
+// or deflate, based on what the client accepts
+func compressHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		encoding := acceptedEncoding(req.Header.Get("Accept-Encoding"))
+		if len(encoding) == 0 || req.Method == http.MethodHead {
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		// ranges don't line up with the compressed
+		// content so the whole file is always sent
+		req.Header.Del("Range")
+		rw.Header().Add("Vary", "Accept-Encoding")
+
+		crw := &compressResponseWriter{ResponseWriter: rw, encoding: encoding}
+		defer crw.Close()
+		next.ServeHTTP(crw, req)
+	})
+}
+
+// acceptedEncoding picks gzip over deflate if the
+// client accepts both, returns an empty string if
+// it accepts neither
+func acceptedEncoding(acceptEncoding string) string {
+	accepted := []string{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		encoding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.ReplaceAll(strings.TrimSpace(params), " ", "") == "q=0" {
+			continue
+		}
+		accepted = append(accepted, strings.ToLower(strings.TrimSpace(encoding)))
+	}
+	for _, encoding := range []string{"gzip", "deflate"} {
+		if Contains(accepted, encoding) {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// compressResponseWriter decides if the response needs to be
+// compressed once the status and content type are known
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding    string
+	compressor  io.WriteCloser
+	wroteHeader bool
+}
+
+func (crw *compressResponseWriter) WriteHeader(status int) {
+	if crw.wroteHeader {
+		return
+	}
+	crw.wroteHeader = true
+
+	header := crw.Header()
+	if shouldCompress(status, header) {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", crw.encoding)
+		if crw.encoding == "gzip" {
+			crw.compressor = gzip.NewWriter(crw.ResponseWriter)
+		} else {
+			crw.compressor, _ = flate.NewWriter(crw.ResponseWriter, flate.DefaultCompression)
+		}
+	}
+	crw.ResponseWriter.WriteHeader(status)
+}
+
+func (crw *compressResponseWriter) Write(content []byte) (int, error) {
+	if !crw.wroteHeader {
+		if len(crw.Header().Get("Content-Type")) == 0 {
+			crw.Header().Set("Content-Type", http.DetectContentType(content))
+		}
+		crw.WriteHeader(http.StatusOK)
+	}
+	if crw.compressor != nil {
+		return crw.compressor.Write(content)
+	}
+	return crw.ResponseWriter.Write(content)
+}
+
+func (crw *compressResponseWriter) Close() error {
+	if crw.compressor == nil {
+		return nil
+	}
+	return crw.compressor.Close()
+}
+
+func shouldCompress(status int, header http.Header) bool {
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		return false
+	}
+	if len(header.Get("Content-Encoding")) > 0 {
+		return false
+	}
+	contentType := header.Get("Content-Type")
+	for _, compressible := range compressibleTypes {
+		if strings.HasPrefix(contentType, compressible) {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeRequestPath cleans the path of the request so it
+// can be safely joined with the output path, paths trying
+// to go up a directory with `..` are rejected
+func sanitizeRequestPath(requestPath string) (string, bool) {
+	requestPath = strings.ReplaceAll(requestPath, "\\", "/")
+	for _, segment := range strings.Split(requestPath, "/") {
+		if segment == ".." {
+			return "", false
+		}
+	}
+	if strings.ContainsRune(requestPath, 0) {
+		return "", false
+	}
+	return path.Clean("/" + requestPath), true
+}
+
+// serveFile serves the file as is unless live reload is enabled,
+// in which case html files get the reload script injected. The
+// script is only added here so the built files on disk stay clean
+func (al *Alvu) serveFile(rw http.ResponseWriter, req *http.Request, fsys fs.FS, name string) {
+	liveReload := al.liveReload && path.Ext(name) == ".html"
+
+	// the index.html is served from the directory
+	// instead, same as with http.ServeFile
+	if !liveReload && strings.HasSuffix(req.URL.Path, "/index.html") {
+		target := "./"
+		if len(req.URL.RawQuery) > 0 {
+			target += "?" + req.URL.RawQuery
+		}
+		rw.Header().Set("Location", target)
+		rw.WriteHeader(http.StatusMovedPermanently)
+		return
+	}
+
+	file, err := fsys.Open(name)
+	if err != nil {
+		al.notFoundHandler(rw, req, fsys)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		al.notFoundHandler(rw, req, fsys)
+		return
+	}
+
+	// files that can seek are streamed so the
+	// large ones aren't read into memory
+	size := info.Size()
+	content, ok := file.(io.ReadSeeker)
+	if !ok || liveReload {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			al.notFoundHandler(rw, req, fsys)
+			return
+		}
+		if liveReload {
+			data = al._injectLiveReload(data)
+		}
+		size = int64(len(data))
+		content = bytes.NewReader(data)
+	}
+
+	// ServeContent answers the If-None-Match and If-Modified-Since
+	// with a 304, the etag is weak since the compressed response
+	// isn't the same bytes as the file
+	rw.Header().Set("ETag", fmt.Sprintf(`W/"%x-%x"`, info.ModTime().UnixNano(), size))
+	http.ServeContent(rw, req, info.Name(), info.ModTime(), content)
+}
+
+// _webSocketHandler Internal function to setup a listener loop
+// for the live reload setup
+func (al *Alvu) _webSocketHandler(ws *websocket.Conn) {
+	reload := make(chan bool, 1)
+	al.reloadLock.Lock()
+	al.reloadCh = append(al.reloadCh, reload)
+	al.reloadLock.Unlock()
+
+	defer ws.Close()
+
+	for range reload {
+		err := websocket.Message.Send(ws, "reload")
+		if err != nil {
+			// For debug only
+			// log.Printf("Error sending message: %s", err.Error())
+			break
+		}
+		onDebug(func() {
+			debugInfo("Reload message sent")
+		})
+	}
+
+}
+
+func (al *Alvu) AddWebsocketHandler(mux *http.ServeMux) {
+	wsHandler := websocket.Handler(al._webSocketHandler)
+
+	// Use a custom HTTP handler function to upgrade the HTTP request to WebSocket
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		// Check the request's 'Upgrade' header to see if it's a WebSocket request
+		if r.Header.Get("Upgrade") != "websocket" {
+			http.Error(w, "Not a WebSocket handshake request", http.StatusBadRequest)
+			return
+		}
+
+		// Upgrade the HTTP connection to a WebSocket connection
+		wsHandler.ServeHTTP(w, r)
+	})
+
+}
+
+// _clientNotifyReload Internal function to
+// report changes to all possible reload channels
+func (al *Alvu) _clientNotifyReload() {
+	al.reloadLock.Lock()
+	defer al.reloadLock.Unlock()
+	for ind := range al.reloadCh {
+		al.reloadCh[ind] <- true
+	}
+	al.reloadCh = []chan bool{}
+}
+
+func normalizeFilePath(path string) string {
+	if strings.HasSuffix(path, ".html") {
+		return path
+	}
+	return path + ".html"
+}
+
+// notFoundHandler serves the compiled 404.html, the file is
+// read on every request so the changes made while watching
+// show up, falls back to a plain text response
+func (al *Alvu) notFoundHandler(w http.ResponseWriter, r *http.Request, fsys fs.FS) {
+	notFoundFile, err := fs.ReadFile(fsys, "404.html")
+	if err != nil {
+		http.Error(w, "404, Page not found....", http.StatusNotFound)
+		return
+	}
+	if al.liveReload {
+		notFoundFile = al._injectLiveReload(notFoundFile)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	w.Write(notFoundFile)
+}
+
+const liveReloadMarker = "data-alvu-live-reload"
+
+// _injectLiveReload Internal function to add the live reload
+// script right before the closing body tag of the page
+func (al *Alvu) _injectLiveReload(content []byte) []byte {
+	if bytes.Contains(content, []byte(liveReloadMarker)) {
+		return content
+	}
+
+	host := `location.host`
+	if reloadPort := al.config.ReloadPort; len(reloadPort) > 0 {
+		host = `location.hostname + ":` + strings.TrimPrefix(reloadPort, ":") + `"`
+	}
+
+	script := []byte(`<script ` + liveReloadMarker + `>
+	  const socket = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + ` + host + ` + "/ws");
+
+	  // Connection opened
+	  socket.addEventListener("open", (event) => {
+		socket.send("Hello Server!");
+	  });
+
+	  // Listen for messages
+	  socket.addEventListener("message", (event) => {
+		if (event.data == "reload") {
+		  socket.close();
+		  window.location.reload();
+		}
+	  });
+	</script>`)
+
+	closingBody := []byte("</body>")
+	ind := bytes.LastIndex(content, closingBody)
+	if ind == -1 {
+		return append(content, script...)
+	}
+
+	injected := make([]byte, 0, len(content)+len(script))
+	injected = append(injected, content[:ind]...)
+	injected = append(injected, script...)
+	injected = append(injected, content[ind:]...)
+	return injected
+}
@@ -0,0 +1,285 @@
+package alvu
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// takenPort listens on a free port for the rest of the test
+func takenPort(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return port
+}
+
+func TestRunServerReturnsReloadServerError(t *testing.T) {
+	al := &Alvu{
+		config:     Config{Port: "0", ReloadPort: takenPort(t)},
+		outPath:    t.TempDir(),
+		liveReload: true,
+		reloadLock: &sync.Mutex{},
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- al.runServer(context.Background(), "0")
+	}()
+
+	select {
+	case err := <-errs:
+		if err == nil || !strings.Contains(err.Error(), "live reload") {
+			t.Fatalf("expected the live reload error, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the server kept running after the live reload server failed")
+	}
+}
+
+func TestBuildReturnsWhenThePortIsTaken(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"pages/index.md": "# Home",
+	})
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- buildSite(t, dir, Config{Serve: true, Watch: true, Port: takenPort(t), NoCache: true})
+	}()
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("expected an error for the taken port")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the build kept watching after the server failed")
+	}
+}
+
+// serve requests the path from the handler
+func serve(handler http.Handler, requestPath string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost"+requestPath, nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestLiveReloadIsOnlyInTheServedPages(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"pages/index.html": "<p>Home</p>",
+	})
+	al := buildAlvu(t, dir, Config{Serve: true, Watch: true, NoCache: true})
+
+	if index := readOutput(t, dir, "index.html"); strings.Contains(index, liveReloadMarker) {
+		t.Errorf("expected the built file to not have the live reload script, got %q", index)
+	}
+
+	rec := serve(al.ServeFS(os.DirFS(al.outPath)), "/", nil)
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || strings.Count(body, liveReloadMarker) != 1 {
+		t.Fatalf("expected the served page to have the live reload script once, got %v %q", rec.Code, body)
+	}
+	if !strings.HasSuffix(body, "</script></body>") {
+		t.Errorf("expected the script right before the closing body tag, got %q", body)
+	}
+}
+
+func TestInjectLiveReload(t *testing.T) {
+	al := &Alvu{config: Config{ReloadPort: "4000"}}
+
+	once := al._injectLiveReload([]byte("<body></body>"))
+	twice := al._injectLiveReload(once)
+	if !bytes.Equal(once, twice) {
+		t.Errorf("expected the script to be added once, got %q", twice)
+	}
+	if !bytes.Contains(once, []byte(`location.hostname + ":4000"`)) {
+		t.Errorf("expected the socket to use the reload port, got %q", once)
+	}
+}
+
+func TestNotFoundServesThe404Page(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": {Data: []byte("<p>Home</p>")},
+		"404.html":   {Data: []byte("<p>Custom not found</p>")},
+	}
+	al := &Alvu{}
+
+	rec := serve(al.ServeFS(fsys), "/missing", nil)
+	if rec.Code != http.StatusNotFound || rec.Body.String() != "<p>Custom not found</p>" {
+		t.Errorf("expected the custom 404 page, got %v %q", rec.Code, rec.Body.String())
+	}
+	if contentType := rec.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/html") {
+		t.Errorf("expected the 404 page to be html, got %q", contentType)
+	}
+
+	// the page is read on every request
+	fsys["404.html"] = &fstest.MapFile{Data: []byte("<p>Edited</p>")}
+	if rec := serve(al.ServeFS(fsys), "/missing", nil); rec.Body.String() != "<p>Edited</p>" {
+		t.Errorf("expected the edited 404 page, got %q", rec.Body.String())
+	}
+
+	delete(fsys, "404.html")
+	rec = serve(al.ServeFS(fsys), "/missing", nil)
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "404, Page not found") {
+		t.Errorf("expected the plain text fallback, got %v %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestSanitizeRequestPath(t *testing.T) {
+	tests := []struct {
+		requestPath string
+		expected    string
+		ok          bool
+	}{
+		{"/", "/", true},
+		{"/blog/post.html", "/blog/post.html", true},
+		{"/blog//./post", "/blog/post", true},
+		{"blog", "/blog", true},
+		{"/..", "", false},
+		{"/../../etc/passwd", "", false},
+		{"/blog/../../etc/passwd", "", false},
+		{"/blog/../index.html", "", false},
+		{"/..\\..\\etc\\passwd", "", false},
+		{"/blog\\..\\..\\secret", "", false},
+		{"/index.html\x00.png", "", false},
+		{"/..file", "/..file", true},
+	}
+	for _, test := range tests {
+		got, ok := sanitizeRequestPath(test.requestPath)
+		if got != test.expected || ok != test.ok {
+			t.Errorf("sanitizeRequestPath(%q) = %q, %v, expected %q, %v", test.requestPath, got, ok, test.expected, test.ok)
+		}
+	}
+}
+
+func TestServeFSRejectsTraversal(t *testing.T) {
+	dir := t.TempDir()
+	outPath := filepath.Join(dir, "dist")
+	if err := os.MkdirAll(filepath.Join(outPath, "assets"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outPath, "index.html"), []byte("home"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	handler := (&Alvu{}).ServeFS(os.DirFS(outPath))
+
+	for _, requestPath := range []string{
+		"/../secret.txt",
+		"/assets/../../secret.txt",
+		"/%2e%2e/secret.txt",
+		"/%2E%2E%2Fsecret.txt",
+		"/assets/%2e%2e/%2e%2e/secret.txt",
+		"/..%5csecret.txt",
+	} {
+		rec := serve(handler, requestPath, nil)
+		if rec.Code != http.StatusForbidden || strings.Contains(rec.Body.String(), "secret") {
+			t.Errorf("expected %v to be forbidden, got %v %q", requestPath, rec.Code, rec.Body.String())
+		}
+	}
+
+	// directories without an index.html aren't listed
+	if rec := serve(handler, "/assets/", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("expected the directory listing to be forbidden, got %v", rec.Code)
+	}
+	if rec := serve(handler, "/", nil); rec.Code != http.StatusOK || rec.Body.String() != "home" {
+		t.Errorf("expected the index to be served, got %v %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestServeFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":      {Data: []byte("home")},
+		"about.html":      {Data: []byte("about")},
+		"blog/index.html": {Data: []byte("blog")},
+		"style.css":       {Data: []byte("body{}")},
+	}
+	handler := (&Alvu{}).ServeFS(fsys)
+
+	tests := []struct {
+		requestPath string
+		code        int
+		body        string
+		contentType string
+	}{
+		{"/", http.StatusOK, "home", "text/html"},
+		{"/about", http.StatusOK, "about", "text/html"},
+		{"/about.html", http.StatusOK, "about", "text/html"},
+		{"/blog", http.StatusOK, "blog", "text/html"},
+		{"/blog/", http.StatusOK, "blog", "text/html"},
+		{"/style.css", http.StatusOK, "body{}", "text/css"},
+		{"/missing", http.StatusNotFound, "404, Page not found", "text/plain"},
+	}
+	for _, test := range tests {
+		rec := serve(handler, test.requestPath, nil)
+		if rec.Code != test.code || !strings.Contains(rec.Body.String(), test.body) {
+			t.Errorf("expected %v to be %v %q, got %v %q", test.requestPath, test.code, test.body, rec.Code, rec.Body.String())
+		}
+		if contentType := rec.Header().Get("Content-Type"); !strings.HasPrefix(contentType, test.contentType) {
+			t.Errorf("expected %v to be %v, got %q", test.requestPath, test.contentType, contentType)
+		}
+	}
+
+	rec := serve(handler, "/blog/index.html?page=2", nil)
+	if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "./?page=2" {
+		t.Errorf("expected the index.html to redirect to the directory, got %v %q", rec.Code, rec.Header().Get("Location"))
+	}
+}
+
+func TestConditionalRequestsAreNotModified(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"index.html":      {Data: []byte("home"), ModTime: modTime},
+		"about.html":      {Data: []byte("about"), ModTime: modTime},
+		"blog/index.html": {Data: []byte("blog"), ModTime: modTime},
+		"style.css":       {Data: []byte("body{}"), ModTime: modTime},
+	}
+
+	for _, liveReload := range []bool{false, true} {
+		handler := (&Alvu{liveReload: liveReload}).ServeFS(fsys)
+
+		// the file itself, the directory index and the clean url
+		for _, requestPath := range []string{"/style.css", "/blog/", "/about"} {
+			rec := serve(handler, requestPath, nil)
+			etag := rec.Header().Get("ETag")
+			lastModified := rec.Header().Get("Last-Modified")
+			if rec.Code != http.StatusOK || len(etag) == 0 || lastModified != modTime.Format(http.TimeFormat) {
+				t.Fatalf("expected %v to have an ETag and Last-Modified, got %v %q %q", requestPath, rec.Code, etag, lastModified)
+			}
+
+			for _, header := range []http.Header{
+				{"If-None-Match": {etag}},
+				{"If-Modified-Since": {lastModified}},
+			} {
+				rec := serve(handler, requestPath, header)
+				if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+					t.Errorf("expected %v with %v to be 304, got %v %q", requestPath, header, rec.Code, rec.Body.String())
+				}
+			}
+
+			rec = serve(handler, requestPath, http.Header{"If-None-Match": {`W/"stale"`}})
+			if rec.Code != http.StatusOK {
+				t.Errorf("expected %v with a stale etag to be 200, got %v", requestPath, rec.Code)
+			}
+		}
+	}
+}
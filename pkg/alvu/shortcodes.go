@@ -0,0 +1,265 @@
+package alvu
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Shortcodes are the templates from the shortcodes
+// directory, keyed by the name of the file
+type Shortcodes struct {
+	templates map[string]*template.Template
+}
+
+// ShortcodeData is what the shortcode templates
+// are rendered with
+type ShortcodeData struct {
+	Name string
+	// Args are the positional arguments and Params
+	// are the ones passed as `key="value"`
+	Args   []string
+	Params map[string]string
+	// Inner is what's between the opening and the
+	// closing tag of a paired shortcode
+	Inner template.HTML
+	Page  PageRenderData
+}
+
+// Get returns the positional argument at the index or the
+// param with the name, or an empty string if there's none
+func (sd ShortcodeData) Get(key interface{}) string {
+	switch key := key.(type) {
+	case int:
+		if key >= 0 && key < len(sd.Args) {
+			return sd.Args[key]
+		}
+	case string:
+		return sd.Params[key]
+	}
+	return ""
+}
+
+// LoadShortcodes parses the html files in the directory,
+// a project without the directory has no shortcodes
+func LoadShortcodes(dirPath string, partials *Partials) (*Shortcodes, error) {
+	shortcodes := &Shortcodes{
+		templates: map[string]*template.Template{},
+	}
+
+	entries, err := os.ReadDir(dirPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return shortcodes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".html" {
+			continue
+		}
+
+		filePath := path.Join(dirPath, entry.Name())
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+
+		name := strings.TrimSuffix(entry.Name(), ".html")
+		tmpl, err := partials.HTML(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tmpl.Parse(string(content)); err != nil {
+			return nil, templateError("parse", "shortcode", filePath, err)
+		}
+		shortcodes.templates[name] = tmpl
+	}
+
+	return shortcodes, nil
+}
+
+// shortcodeTagPattern matches `{{< name args >}}`, `{{% name args %}}`,
+// their closing tags `{{< /name >}}` and the explicitly self closing
+// `{{< name />}}`
+var shortcodeTagPattern = regexp.MustCompile(`\{\{([<%])\s*(/)?\s*([\w-]+)((?:"[^"]*"|[^"])*?)\s*(/)?([>%])\}\}`)
+
+// shortcodeArgPattern matches a `key="value"`, `key=value`,
+// `"quoted value"` or a plain value
+var shortcodeArgPattern = regexp.MustCompile(`([\w-]+)=(?:"([^"]*)"|(\S+))|"([^"]*)"|(\S+)`)
+
+type shortcodeTag struct {
+	start int
+	end   int
+	name  string
+	args  string
+	// markdown is set for the `{{% %}}` tags
+	markdown    bool
+	closing     bool
+	selfClosing bool
+}
+
+func findShortcodeTags(content []byte) []shortcodeTag {
+	tags := []shortcodeTag{}
+	for _, match := range shortcodeTagPattern.FindAllSubmatchIndex(content, -1) {
+		opening, closing := content[match[2]], content[match[12]]
+		if (opening == '<') != (closing == '>') {
+			continue
+		}
+		tags = append(tags, shortcodeTag{
+			start:       match[0],
+			end:         match[1],
+			name:        string(content[match[6]:match[7]]),
+			args:        string(content[match[8]:match[9]]),
+			markdown:    opening == '%',
+			closing:     match[4] != -1,
+			selfClosing: match[10] != -1,
+		})
+	}
+	return tags
+}
+
+// closingShortcodeTag returns the index of the tag that closes
+// the one at the given index, or -1 if it's self closing
+func closingShortcodeTag(tags []shortcodeTag, index int) int {
+	if tags[index].selfClosing {
+		return -1
+	}
+
+	depth := 0
+	for i := index + 1; i < len(tags); i++ {
+		if tags[i].name != tags[index].name || tags[i].selfClosing {
+			continue
+		}
+		if !tags[i].closing {
+			depth++
+			continue
+		}
+		if depth == 0 {
+			return i
+		}
+		depth--
+	}
+	return -1
+}
+
+// Expand renders the shortcodes in the content. The output of the
+// `{{% %}}` ones is left in the content as markdown, while the output
+// of the `{{< >}}` ones is swapped with a placeholder so that the
+// markdown conversion doesn't touch it, see restoreShortcodes
+func (sc *Shortcodes) Expand(content []byte, page PageRenderData, sourcePath string) ([]byte, [][]byte, error) {
+	outputs := [][]byte{}
+	if sc == nil || len(sc.templates) == 0 {
+		return content, outputs, nil
+	}
+	expanded, err := sc.expand(content, page, sourcePath, &outputs)
+	return expanded, outputs, err
+}
+
+func (sc *Shortcodes) expand(content []byte, page PageRenderData, sourcePath string, outputs *[][]byte) ([]byte, error) {
+	tags := findShortcodeTags(content)
+	if len(tags) == 0 {
+		return content, nil
+	}
+
+	var expanded bytes.Buffer
+	last := 0
+	for i := 0; i < len(tags); i++ {
+		tag := tags[i]
+		if tag.closing {
+			return nil, fmt.Errorf("closing shortcode `%v` without an opening one in %v", tag.name, sourcePath)
+		}
+
+		expanded.Write(content[last:tag.start])
+		last = tag.end
+
+		var inner []byte
+		if closingIndex := closingShortcodeTag(tags, i); closingIndex != -1 {
+			// nested shortcodes are expanded first
+			nestedInner, err := sc.expand(content[tag.end:tags[closingIndex].start], page, sourcePath, outputs)
+			if err != nil {
+				return nil, err
+			}
+			inner = nestedInner
+			last = tags[closingIndex].end
+			i = closingIndex
+		}
+
+		rendered, err := sc.render(tag, inner, page, sourcePath)
+		if err != nil {
+			return nil, err
+		}
+
+		if tag.markdown {
+			expanded.Write(rendered)
+			continue
+		}
+		// the output is final, so it's kept out
+		// of the template pass over the whole page
+		*outputs = append(*outputs, escapeTemplateDelims(rendered))
+		expanded.WriteString(shortcodePlaceholder(len(*outputs) - 1))
+	}
+	expanded.Write(content[last:])
+
+	return expanded.Bytes(), nil
+}
+
+func (sc *Shortcodes) render(tag shortcodeTag, inner []byte, page PageRenderData, sourcePath string) ([]byte, error) {
+	tmpl, ok := sc.templates[tag.name]
+	if !ok {
+		return nil, fmt.Errorf("unknown shortcode `%v` in %v", tag.name, sourcePath)
+	}
+
+	data := ShortcodeData{
+		Name:   tag.name,
+		Args:   []string{},
+		Params: map[string]string{},
+		Inner:  template.HTML(inner),
+		Page:   page,
+	}
+	for _, arg := range shortcodeArgPattern.FindAllStringSubmatch(tag.args, -1) {
+		switch {
+		case len(arg[1]) > 0:
+			data.Params[arg[1]] = arg[2] + arg[3]
+		case len(arg[5]) > 0:
+			data.Args = append(data.Args, arg[5])
+		default:
+			data.Args = append(data.Args, arg[4])
+		}
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return nil, templateError("render", "shortcode "+tag.name, sourcePath, err)
+	}
+	// the newline at the end of the file would otherwise
+	// break the line when the shortcode is used inline
+	return bytes.TrimRight(rendered.Bytes(), "\n"), nil
+}
+
+// shortcodePlaceholder is an html comment so that
+// the markdown conversion leaves it as is
+func shortcodePlaceholder(index int) string {
+	return fmt.Sprintf("<!--alvu:shortcode:%d-->", index)
+}
+
+// shortcodePlaceholderPattern matches the shortcodePlaceholder
+var shortcodePlaceholderPattern = regexp.MustCompile(`<!--alvu:shortcode:\d+-->`)
+
+// restoreShortcodes swaps the placeholders with the outputs, the
+// nested shortcodes are expanded before the ones they're in so
+// they're restored in reverse
+func restoreShortcodes(content []byte, outputs [][]byte) []byte {
+	for i := len(outputs) - 1; i >= 0; i-- {
+		content = bytes.Replace(content, []byte(shortcodePlaceholder(i)), outputs[i], 1)
+	}
+	return content
+}
@@ -0,0 +1,296 @@
+package alvu
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SingleFile collects the content of the html pages to join
+// them into one document, for a printable version of the site
+type SingleFile struct {
+	lock *sync.Mutex
+	// manifest are the names of the pages, relative to
+	// pages, in the order they're joined in
+	manifest []string
+	sections map[string]singleFileSection
+}
+
+type singleFileSection struct {
+	name    string
+	url     string
+	anchor  string
+	content []byte
+}
+
+// NewSingleFile reads the manifest, a page path relative
+// to pages on every line, empty lines and the ones starting
+// with `#` are skipped. Without a manifest all of the pages
+// are joined in the order of their path
+func NewSingleFile(manifestPath string) (*SingleFile, error) {
+	sf := &SingleFile{
+		lock:     &sync.Mutex{},
+		sections: map[string]singleFileSection{},
+	}
+	if len(manifestPath) == 0 {
+		return sf, nil
+	}
+
+	content, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the single file manifest, error: %v", err)
+	}
+	sf.manifest = []string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sf.manifest = append(sf.manifest, path.Clean(filepath.ToSlash(line)))
+	}
+	return sf, nil
+}
+
+// Includes checks if the page is a part of the single file,
+// the generated pages and the 404 page are left out
+func (sf *SingleFile) Includes(af *AlvuFile, targetFile string) bool {
+	if filepath.Ext(targetFile) != ".html" || af.name == "404.html" || af.generated {
+		return false
+	}
+	return sf.manifest == nil || Contains(sf.manifest, filepath.ToSlash(af.name))
+}
+
+// AddFile adds the content of the page, rendered
+// without the layouts, as a section of the single file
+func (sf *SingleFile) AddFile(af *AlvuFile, targetFile string, content []byte) {
+	url, err := af.alvu.outputURL(targetFile)
+	if err != nil {
+		return
+	}
+	relPath, err := filepath.Rel(af.alvu.outPath, targetFile)
+	if err != nil {
+		return
+	}
+
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
+	sf.sections[targetFile] = singleFileSection{
+		name:    filepath.ToSlash(af.name),
+		url:     url,
+		anchor:  singleFileAnchor(relPath),
+		content: content,
+	}
+}
+
+// Sections returns the sections in the order of the manifest,
+// or ordered by the path of the pages with the index of a
+// directory before the rest of it
+func (sf *SingleFile) Sections() ([]singleFileSection, error) {
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
+
+	sections := []singleFileSection{}
+	for _, section := range sf.sections {
+		sections = append(sections, section)
+	}
+
+	if sf.manifest == nil {
+		sortKey := func(name string) string {
+			if strings.TrimSuffix(path.Base(name), path.Ext(name)) == "index" {
+				return path.Dir(name) + "/"
+			}
+			return path.Dir(name) + "/" + path.Base(name)
+		}
+		sort.SliceStable(sections, func(i, j int) bool {
+			if sortKey(sections[i].name) == sortKey(sections[j].name) {
+				return sections[i].url < sections[j].url
+			}
+			return sortKey(sections[i].name) < sortKey(sections[j].name)
+		})
+		return sections, nil
+	}
+
+	ordered := []singleFileSection{}
+	for _, name := range sf.manifest {
+		found := false
+		for _, section := range sections {
+			if section.name == name {
+				ordered = append(ordered, section)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("page %v from the single file manifest wasn't found", name)
+		}
+	}
+	return ordered, nil
+}
+
+// singleFileAnchor is the id of the section of the page
+// in the single file, `blog/post.html` => `page-blog-post`
+func singleFileAnchor(relPath string) string {
+	relPath = filepath.ToSlash(relPath)
+	relPath = strings.TrimSuffix(relPath, path.Ext(relPath))
+	return "page-" + strings.ReplaceAll(relPath, "/", "-")
+}
+
+// writeSingleFile joins the sections with a rule in between
+// and writes them with the default layout, the links between
+// the pages point to their sections instead, returns the
+// path the single file was written to
+func (al *Alvu) writeSingleFile() (string, error) {
+	sections, err := al.singleFile.Sections()
+	if err != nil {
+		return "", err
+	}
+
+	anchors := map[string]string{}
+	for _, section := range sections {
+		for _, pageURL := range singleFileURLs(section.url) {
+			anchors[pageURL] = section.anchor
+		}
+	}
+
+	var content bytes.Buffer
+	for i, section := range sections {
+		if i > 0 {
+			content.WriteString("<hr />\n")
+		}
+		fmt.Fprintf(&content, "<section id=\"%v\">\n", section.anchor)
+		content.Write(singleFileLinks(section.content, section.url, anchors))
+		content.WriteString("\n</section>\n")
+	}
+
+	layout, err := al.layouts.Get(al.baseTemplate)
+	if err != nil {
+		return "", templateError("parse", "layout", "the single file", err)
+	}
+	layoutData := LayoutRenderData{
+		PageRenderData: PageRenderData{
+			Meta: SiteMeta{
+				BaseURL: al.config.BaseURL,
+			},
+			Page:   map[string]interface{}{},
+			Data:   map[string]interface{}{"site": al.siteData},
+			Extras: map[string]interface{}{},
+			Pages:  al.pages,
+		},
+		Content: template.HTML(content.Bytes()),
+	}
+	var rendered bytes.Buffer
+	if err := layout.tmpl.Execute(&rendered, layoutData); err != nil {
+		return "", templateError("render", "layout "+layout.name, "the single file", err)
+	}
+
+	targetFile := al.outputFile(al.config.SingleFile)
+	if err := al.claimOutput(targetFile, "-single-file"); err != nil {
+		return "", err
+	}
+	return targetFile, al.writeOutput(targetFile, al.withClientScripts(rendered.Bytes()))
+}
+
+// outputFile is the path of a file given relative to the output
+func (al *Alvu) outputFile(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(al.outPath, name)
+}
+
+const defaultPDFCommand = "wkhtmltopdf {input} {output}"
+
+// writePDF runs the pdf command over the single file, the
+// command is split on spaces and run without a shell
+func (al *Alvu) writePDF(singleFilePath string) error {
+	input, err := filepath.Abs(singleFilePath)
+	if err != nil {
+		return err
+	}
+	output, err := filepath.Abs(al.outputFile(al.config.PDF))
+	if err != nil {
+		return err
+	}
+	if err := al.claimOutput(output, "-pdf"); err != nil {
+		return err
+	}
+
+	args := strings.Fields(al.config.PDFCommand)
+	if len(args) == 0 {
+		return fmt.Errorf("the pdf command is empty")
+	}
+	for i, arg := range args {
+		arg = strings.ReplaceAll(arg, "{input}", input)
+		args[i] = strings.ReplaceAll(arg, "{output}", output)
+	}
+
+	onDebug(func() {
+		debugInfo("Writing the pdf with: %v", strings.Join(args, " "))
+	})
+	if out, err := exec.Command(args[0], args[1:]...).CombinedOutput(); err != nil {
+		if out = bytes.TrimSpace(out); len(out) > 0 {
+			return fmt.Errorf("error running the pdf command `%v`, error: %v\n%s", args[0], err, out)
+		}
+		return fmt.Errorf("error running the pdf command `%v`, error: %v", args[0], err)
+	}
+	return nil
+}
+
+// singleFileURLs are the urls a page can be linked
+// to with, with and without the extension or index.html
+func singleFileURLs(pageURL string) []string {
+	urls := []string{pageURL}
+	switch {
+	case strings.HasSuffix(pageURL, "/index.html"):
+		urls = append(urls, strings.TrimSuffix(pageURL, "index.html"), strings.TrimSuffix(pageURL, "/index.html"))
+	case strings.HasSuffix(pageURL, ".html"):
+		urls = append(urls, strings.TrimSuffix(pageURL, ".html"))
+	case strings.HasSuffix(pageURL, "/"):
+		urls = append(urls, strings.TrimSuffix(pageURL, "/"), pageURL+"index.html")
+	}
+	return urls
+}
+
+// singleFileLinks points the links to the pages in the single
+// file to their section, or to the fragment if they have one
+func singleFileLinks(content []byte, pageURL string, anchors map[string]string) []byte {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return content
+	}
+	return assetReferencePattern.ReplaceAllFunc(content, func(attr []byte) []byte {
+		match := assetReferencePattern.FindSubmatch(attr)
+		if !strings.EqualFold(string(match[1]), "href") {
+			return attr
+		}
+		quote := match[2][:1]
+		value := string(match[2][1 : len(match[2])-1])
+		if len(value) == 0 || strings.HasPrefix(value, "#") {
+			return attr
+		}
+
+		ref, err := url.Parse(strings.ReplaceAll(value, "&amp;", "&"))
+		if err != nil {
+			return attr
+		}
+		target := base.ResolveReference(ref)
+		fragment := target.Fragment
+		target.Fragment = ""
+		target.RawQuery = ""
+		anchor, ok := anchors[target.String()]
+		if !ok {
+			return attr
+		}
+		if len(fragment) > 0 {
+			anchor = fragment
+		}
+		return []byte(string(match[1]) + "=" + string(quote) + "#" + anchor + string(quote))
+	})
+}
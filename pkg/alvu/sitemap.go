@@ -0,0 +1,95 @@
+package alvu
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// Sitemap collects the urls of the compiled pages while they
+// are being flushed, keyed by the output file so rebuilds don't
+// add duplicates
+type Sitemap struct {
+	lock *sync.Mutex
+	urls map[string]sitemapURL
+}
+
+func NewSitemap() *Sitemap {
+	return &Sitemap{
+		lock: &sync.Mutex{},
+		urls: map[string]sitemapURL{},
+	}
+}
+
+func (sm *Sitemap) AddFile(af *AlvuFile, targetFile string) {
+	if filepath.Ext(targetFile) != ".html" || af.name == "404.html" {
+		return
+	}
+
+	if include, ok := af.meta["sitemap"].(bool); ok && !include {
+		return
+	}
+
+	loc, err := af.alvu.outputURL(targetFile)
+	if err != nil {
+		return
+	}
+
+	url := sitemapURL{
+		Loc: loc,
+	}
+	if info, err := os.Stat(af.sourcePath); err == nil {
+		url.LastMod = info.ModTime().UTC().Format("2006-01-02")
+	}
+
+	sm.add(targetFile, url)
+}
+
+func (sm *Sitemap) add(targetFile string, url sitemapURL) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	sm.urls[targetFile] = url
+}
+
+func (sm *Sitemap) get(targetFile string) (sitemapURL, bool) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	url, ok := sm.urls[targetFile]
+	return url, ok
+}
+
+func (sm *Sitemap) Write(outPath string) error {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	urlSet := sitemapURLSet{
+		Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9",
+	}
+	for _, url := range sm.urls {
+		urlSet.URLs = append(urlSet.URLs, url)
+	}
+	sort.Slice(urlSet.URLs, func(i, j int) bool {
+		return urlSet.URLs[i].Loc < urlSet.URLs[j].Loc
+	})
+
+	content, err := xml.MarshalIndent(urlSet, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	content = append([]byte(xml.Header), content...)
+	return os.WriteFile(filepath.Join(outPath, "sitemap.xml"), content, 0644)
+}
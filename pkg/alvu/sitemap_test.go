@@ -0,0 +1,37 @@
+package alvu
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestSitemapLeavesOutExcludedPages(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"pages/index.md":        "# Home",
+		"pages/blog/post.md":    "# Post",
+		"pages/draft.md":        "---\nsitemap: false\n---\n\n# Hidden",
+		"pages/404.html":        "<p>Not found</p>",
+		"pages/robots.txt":      "User-agent: *",
+		"public/css/styles.css": "body {}",
+	})
+	if err := buildSite(t, dir, Config{BaseURL: "https://example.com/", Sitemap: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	var urlSet sitemapURLSet
+	if err := xml.Unmarshal([]byte(readOutput(t, dir, "sitemap.xml")), &urlSet); err != nil {
+		t.Fatal(err)
+	}
+	locs := []string{}
+	for _, url := range urlSet.URLs {
+		locs = append(locs, url.Loc)
+		if len(url.LastMod) == 0 {
+			t.Errorf("expected %v to have a lastmod", url.Loc)
+		}
+	}
+
+	expected := []string{"https://example.com/blog/post.html", "https://example.com/index.html"}
+	if len(locs) != len(expected) || locs[0] != expected[0] || locs[1] != expected[1] {
+		t.Errorf("expected the sitemap to have %v, got %v", expected, locs)
+	}
+}
@@ -0,0 +1,208 @@
+package alvu
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	luaAlvu "github.com/barelyhuman/alvu/lua/alvu"
+	"gopkg.in/yaml.v3"
+)
+
+// Taxonomy groups the pages by the values of a key in their
+// front matter, set under `taxonomies` in the config, eg:
+//
+//	taxonomies:
+//	  tags:
+//	    layout: tag
+//
+// every value (term) gets a page that lists the pages with it
+type Taxonomy struct {
+	// Name is the key in the front matter
+	Name string
+	// Layout is the named layout the term pages are
+	// rendered with, the default layout if empty
+	Layout string
+	// Prefix is the directory the term pages are written
+	// to, relative to the output, defaults to the Name
+	Prefix string
+}
+
+type taxonomyOptions struct {
+	Layout string `yaml:"layout"`
+	Prefix string `yaml:"prefix"`
+}
+
+// ConfigTaxonomies reads the taxonomies from the config,
+// ordered by their name
+func ConfigTaxonomies(config map[string]interface{}) ([]Taxonomy, error) {
+	taxonomies := []Taxonomy{}
+	value, ok := config["taxonomies"]
+	if !ok || value == nil {
+		return taxonomies, nil
+	}
+
+	content, err := yaml.Marshal(value)
+	if err != nil {
+		return taxonomies, fmt.Errorf("invalid taxonomies in the config, error: %v", err)
+	}
+	decoded := map[string]*taxonomyOptions{}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&decoded); err != nil {
+		return taxonomies, fmt.Errorf("invalid taxonomies in the config, error: %v", err)
+	}
+
+	for name, options := range decoded {
+		taxonomy := Taxonomy{Name: name}
+		if options != nil {
+			taxonomy.Layout = options.Layout
+			taxonomy.Prefix = options.Prefix
+		}
+		taxonomies = append(taxonomies, taxonomy)
+	}
+	sort.Slice(taxonomies, func(i, j int) bool {
+		return taxonomies[i].Name < taxonomies[j].Name
+	})
+	return taxonomies, nil
+}
+
+// Validate checks that the prefix stays inside of the output
+func (t Taxonomy) Validate() error {
+	if len(strings.TrimSpace(t.Name)) == 0 {
+		return fmt.Errorf("invalid taxonomy, the name can't be empty")
+	}
+	prefix := path.Clean(strings.TrimPrefix(t.Prefix, "/"))
+	if prefix == ".." || strings.HasPrefix(prefix, "../") {
+		return fmt.Errorf("invalid prefix `%v` for the taxonomy `%v`", t.Prefix, t.Name)
+	}
+	return nil
+}
+
+// TermName is the path, relative to the output, that the
+// page of the term is written to
+func (t Taxonomy) TermName(slug string) string {
+	prefix := t.Prefix
+	if len(prefix) == 0 {
+		prefix = t.Name
+	}
+	return strings.TrimPrefix(path.Join("/", prefix, slug, "index.html"), "/")
+}
+
+// TaxonomyTerm is a value of a taxonomy along with the pages
+// that have it, newest first, available to the templates
+// of the term's page as `.Extras.term`
+type TaxonomyTerm struct {
+	Taxonomy string `json:"taxonomy"`
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	URL      string `json:"url"`
+	Pages    []Page `json:"pages"`
+}
+
+// taxonomyTermContent is the content of the term pages,
+// the layout can use it as `.Content` or list the pages
+// on its own
+const taxonomyTermContent = `<h1>{{.Page.title}}</h1>
+<ul>
+{{range .Extras.term.Pages}}<li><a href="{{.URL}}">{{or .Meta.title .Name}}</a></li>
+{{end}}</ul>
+`
+
+// taxonomyTerms collects the terms of the taxonomy from the
+// pages, terms with the same slug are the same term and the
+// name from the first page that has it is used
+func taxonomyTerms(taxonomy Taxonomy, pages []Page) []*TaxonomyTerm {
+	terms := map[string]*TaxonomyTerm{}
+	slugs := []string{}
+	for _, page := range pages {
+		var values []interface{}
+		switch value := page.Meta[taxonomy.Name].(type) {
+		case nil:
+			continue
+		case []interface{}:
+			values = value
+		default:
+			values = []interface{}{value}
+		}
+
+		for _, value := range values {
+			name := strings.TrimSpace(fmt.Sprint(value))
+			slug := luaAlvu.Slugify(name)
+			if len(slug) == 0 {
+				continue
+			}
+			term, ok := terms[slug]
+			if !ok {
+				term = &TaxonomyTerm{Taxonomy: taxonomy.Name, Name: name, Slug: slug}
+				terms[slug] = term
+				slugs = append(slugs, slug)
+			}
+			// the same term can be listed twice on a page
+			if len(term.Pages) > 0 && term.Pages[len(term.Pages)-1].Name == page.Name {
+				continue
+			}
+			term.Pages = append(term.Pages, page)
+		}
+	}
+
+	sort.Strings(slugs)
+	sorted := make([]*TaxonomyTerm, 0, len(slugs))
+	for _, slug := range slugs {
+		term := terms[slug]
+		sort.SliceStable(term.Pages, func(i, j int) bool {
+			left, leftOk := luaAlvu.ParseDate(term.Pages[i].Meta["date"])
+			right, rightOk := luaAlvu.ParseDate(term.Pages[j].Meta["date"])
+			if leftOk != rightOk {
+				return leftOk
+			}
+			return leftOk && left.After(right)
+		})
+		sorted = append(sorted, term)
+	}
+	return sorted
+}
+
+// taxonomyFiles creates a page for every term of the
+// taxonomies, from the pages that were collected
+func (al *Alvu) taxonomyFiles() []*AlvuFile {
+	files := []*AlvuFile{}
+	for _, taxonomy := range al.config.Taxonomies {
+		for _, term := range taxonomyTerms(taxonomy, al.pages) {
+			name := taxonomy.TermName(term.Slug)
+			destPath := path.Join(al.outPath, name)
+			if url, err := al.outputURL(destPath); err == nil {
+				term.URL = url
+			}
+
+			meta := map[string]interface{}{
+				"title":    term.Name,
+				"taxonomy": taxonomy.Name,
+				"term":     term.Name,
+			}
+			if len(taxonomy.Layout) > 0 {
+				meta["layout"] = taxonomy.Layout
+			}
+
+			files = append(files, &AlvuFile{
+				lock:             &sync.Mutex{},
+				alvu:             al,
+				sourcePath:       taxonomy.Name + ":" + term.Slug,
+				destPath:         destPath,
+				name:             name,
+				isHTML:           true,
+				generated:        true,
+				baseTemplate:     al.baseTemplate,
+				content:          []byte(taxonomyTermContent),
+				writeableContent: []byte(taxonomyTermContent),
+				meta:             meta,
+				data:             map[string]interface{}{},
+				extras:           map[string]interface{}{"term": term},
+			})
+		}
+	}
+	return files
+}
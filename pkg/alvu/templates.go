@@ -0,0 +1,375 @@
+package alvu
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	textTmpl "text/template"
+
+	luaAlvu "github.com/barelyhuman/alvu/lua/alvu"
+)
+
+// loadTemplates parses the partials and the shortcodes
+// and starts a new cache for the layouts
+func (al *Alvu) loadTemplates() error {
+	partials, err := LoadPartials(path.Join(al.basePath, partialsDir), al.templateFuncs())
+	if err != nil {
+		return err
+	}
+	al.partials = partials
+	al.layouts = NewLayoutCache(partials)
+
+	shortcodes, err := LoadShortcodes(path.Join(al.basePath, shortcodesDir), partials)
+	if err != nil {
+		return err
+	}
+	al.shortcodes = shortcodes
+	return nil
+}
+
+// reloadTemplateFiles reads the layouts and the _head.html/_tail.html
+// of the pages again, since they're only read once when starting
+func (al *Alvu) reloadTemplateFiles() error {
+	if al.pageTemplates != nil {
+		if err := al.pageTemplates.reload(); err != nil {
+			return err
+		}
+	}
+	return al.namedLayouts.reload()
+}
+
+// templateFiles reads the `_head.html`, `_layout.html` and
+// `_tail.html` of the pages, the one in the directory of the
+// page wins, then the one in its parent and so on up to the
+// one at the root of the pages
+type templateFiles struct {
+	pagesPath string
+	// files are the read files by their path,
+	// nil if the file doesn't exist
+	files           map[string]*templateFile
+	headTailWarning string
+	// noHeadTail skips the _head.html and _tail.html
+	noHeadTail bool
+}
+
+func (tf *templateFiles) nearest(dir string, name string) (*templateFile, error) {
+	if tf.noHeadTail && name != "_layout.html" {
+		return nil, nil
+	}
+	for {
+		filePath := path.Join(dir, name)
+		file, ok := tf.files[filePath]
+		if !ok {
+			var err error
+			file, err = readTemplateFile(filePath)
+			if err != nil {
+				if !errors.Is(err, fs.ErrNotExist) {
+					return nil, err
+				}
+				file = nil
+			}
+			tf.files[filePath] = file
+			if file != nil && name != "_layout.html" {
+				fmt.Println(tf.headTailWarning)
+			}
+		}
+		if file != nil {
+			return file, nil
+		}
+		if dir == tf.pagesPath || path.Dir(dir) == dir {
+			return nil, nil
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// reload reads the files again, the pages hold on to the
+// same *templateFile so they see the new contents, a removed
+// file keeps the contents it had till alvu is restarted
+func (tf *templateFiles) reload() error {
+	for _, file := range tf.files {
+		if file == nil {
+			continue
+		}
+		if err := file.reload(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// templateFile is a layout or the _head.html/_tail.html
+// read into memory
+type templateFile struct {
+	name    string
+	content []byte
+}
+
+func readTemplateFile(filePath string) (*templateFile, error) {
+	file := &templateFile{name: filePath}
+	if err := file.reload(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (tf *templateFile) reload() error {
+	content, err := os.ReadFile(tf.name)
+	if err != nil {
+		return err
+	}
+	tf.content = content
+	return nil
+}
+
+var layoutParentPattern = regexp.MustCompile(`<!--\s*alvu:parent\s+([\w\-/]+)\s*-->`)
+
+// layoutParent returns the name of the parent layout if the
+// layout has a `<!-- alvu:parent name -->` directive
+func layoutParent(layoutTemplateData string) string {
+	match := layoutParentPattern.FindStringSubmatch(layoutTemplateData)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// Partials are the templates from the partials directory, they are
+// parsed once per build and cloned into the templates of every file
+// so that they can be included with `{{template "partials/nav" .}}`
+type Partials struct {
+	html *template.Template
+	text *textTmpl.Template
+}
+
+// LoadPartials parses the html files in the directory (and the ones
+// nested in it) as `partials/<path without the extension>`, a
+// project without the directory has no partials
+func LoadPartials(dirPath string, funcs map[string]interface{}) (*Partials, error) {
+	partials := &Partials{
+		html: template.New(partialsDir).Funcs(template.FuncMap(funcs)),
+		text: textTmpl.New(partialsDir).Funcs(textTmpl.FuncMap(funcs)),
+	}
+
+	if _, err := os.Stat(dirPath); errors.Is(err, fs.ErrNotExist) {
+		return partials, nil
+	}
+
+	err := filepath.WalkDir(dirPath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || filepath.Ext(filePath) != ".html" {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dirPath, filePath)
+		if err != nil {
+			return err
+		}
+		name := path.Join(partialsDir, strings.TrimSuffix(filepath.ToSlash(relPath), ".html"))
+
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		if _, err := partials.html.New(name).Parse(string(content)); err != nil {
+			return templateError("parse", "partial", filePath, err)
+		}
+		if _, err := partials.text.New(name).Parse(string(content)); err != nil {
+			return templateError("parse", "partial", filePath, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return partials, nil
+}
+
+// HTML returns a new html template with the partials available
+// to it, the partials themselves are never executed so they
+// can be cloned for every file
+func (p *Partials) HTML(name string) (*template.Template, error) {
+	clone, err := p.html.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return clone.New(name), nil
+}
+
+// Text returns a new text template with the partials available
+// to it, used for the template pass over the markdown and the
+// text files
+func (p *Partials) Text(name string) *textTmpl.Template {
+	return textTmpl.Must(p.text.Clone()).New(name)
+}
+
+// LayoutCache keeps the parsed layout templates so
+// they're parsed once per build instead of once per file
+type LayoutCache struct {
+	lock      *sync.Mutex
+	partials  *Partials
+	templates map[*templateFile]*cachedLayout
+}
+
+type cachedLayout struct {
+	name string
+	tmpl *template.Template
+	// parent is the name of the layout this
+	// one is wrapped in, if any
+	parent string
+}
+
+// defaultLayout is used when there's no _layout.html
+const defaultLayout = `<body>{{.Content}}</body>`
+
+func NewLayoutCache(partials *Partials) *LayoutCache {
+	return &LayoutCache{
+		lock:      &sync.Mutex{},
+		partials:  partials,
+		templates: map[*templateFile]*cachedLayout{},
+	}
+}
+
+// Get returns the parsed layout of the file, a nil
+// file returns the default layout
+func (lc *LayoutCache) Get(file *templateFile) (*cachedLayout, error) {
+	lc.lock.Lock()
+	defer lc.lock.Unlock()
+
+	if layout, ok := lc.templates[file]; ok {
+		return layout, nil
+	}
+
+	name := "default"
+	layoutTemplateData := defaultLayout
+	if file != nil {
+		name = file.name
+		layoutTemplateData = string(file.content)
+	}
+
+	tmpl, err := lc.partials.HTML("layout")
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", name, err)
+	}
+	if _, err := tmpl.Parse(layoutParentPattern.ReplaceAllString(layoutTemplateData, "")); err != nil {
+		return nil, fmt.Errorf("%v: %v", name, err)
+	}
+
+	layout := &cachedLayout{
+		name:   name,
+		tmpl:   tmpl,
+		parent: layoutParent(layoutTemplateData),
+	}
+	lc.templates[file] = layout
+	return layout, nil
+}
+
+// NamedLayouts reads the layouts from the layouts directory
+// into memory the first time a page uses them, the pages using
+// the same layout share the same *templateFile so reload can
+// update all of them when the watcher rebuilds
+type NamedLayouts struct {
+	lock        *sync.Mutex
+	layoutsPath string
+	layouts     map[string]*templateFile
+}
+
+func NewNamedLayouts(layoutsPath string) *NamedLayouts {
+	return &NamedLayouts{
+		lock:        &sync.Mutex{},
+		layoutsPath: layoutsPath,
+		layouts:     map[string]*templateFile{},
+	}
+}
+
+func (nl *NamedLayouts) Get(name string) (*templateFile, error) {
+	nl.lock.Lock()
+	defer nl.lock.Unlock()
+
+	if layout, ok := nl.layouts[name]; ok {
+		return layout, nil
+	}
+
+	layout, err := readTemplateFile(path.Join(nl.layoutsPath, name+".html"))
+	if err != nil {
+		return nil, err
+	}
+
+	nl.layouts[name] = layout
+	return layout, nil
+}
+
+// reload reads the layouts again, the ones that were
+// removed are dropped and read again when they're used
+func (nl *NamedLayouts) reload() error {
+	nl.lock.Lock()
+	defer nl.lock.Unlock()
+
+	for name, layout := range nl.layouts {
+		if err := layout.reload(); err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			delete(nl.layouts, name)
+		}
+	}
+	return nil
+}
+
+// templateFuncs are the helpers available to all
+// the templates
+func (al *Alvu) templateFuncs() map[string]interface{} {
+	funcs := al.builtinTemplateFuncs()
+	for name, fn := range al.hookFuncs {
+		funcs[name] = fn
+	}
+	return funcs
+}
+
+func (al *Alvu) builtinTemplateFuncs() map[string]interface{} {
+	return map[string]interface{}{
+		"asset":    al.assetURL,
+		"date":     formatDate,
+		"link":     al.linkURL,
+		"safeHTML": safeHTML,
+		"slugify":  luaAlvu.Slugify,
+	}
+}
+
+// linkURL joins the baseurl with the given path so the links keep
+// working when the site is deployed under a sub path, absolute
+// urls are returned as is
+func (al *Alvu) linkURL(linkPath string) string {
+	if strings.Contains(linkPath, "://") || strings.HasPrefix(linkPath, "//") {
+		return linkPath
+	}
+	hasTrailingSlash := strings.HasSuffix(linkPath, "/")
+	linkPath = path.Clean("/" + linkPath)
+	if hasTrailingSlash && linkPath != "/" {
+		linkPath += "/"
+	}
+	return joinURL(al.config.BaseURL, linkPath)
+}
+
+// formatDate formats the date in the given go layout,
+// shared with the hooks as `alvu.date`
+func formatDate(format string, value interface{}) string {
+	formatted, _ := luaAlvu.FormatDate(format, value)
+	return formatted
+}
+
+// safeHTML marks the given string as html that doesn't
+// need to be escaped
+func safeHTML(content string) template.HTML {
+	return template.HTML(content)
+}
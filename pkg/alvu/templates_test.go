@@ -0,0 +1,158 @@
+package alvu
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLinkUsesTheBaseURL(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"pages/_layout.html": `<nav><a href="{{link "/blog/"}}">Blog</a></nav>{{.Content}}`,
+		"pages/index.md":     `[About]({{link "about"}})`,
+	})
+	if err := buildSite(t, dir, Config{BaseURL: "/docs/"}); err != nil {
+		t.Fatal(err)
+	}
+
+	index := readOutput(t, dir, "index.html")
+	for _, expected := range []string{`<a href="/docs/blog/">Blog</a>`, `<a href="/docs/about">About</a>`} {
+		if !strings.Contains(index, expected) {
+			t.Errorf("expected %q in the page, got %q", expected, index)
+		}
+	}
+}
+
+func TestLinkURL(t *testing.T) {
+	tests := []struct {
+		baseURL  string
+		link     string
+		expected string
+	}{
+		{"/", "/blog/", "/blog/"},
+		{"/docs/", "/blog/", "/docs/blog/"},
+		{"/docs", "blog", "/docs/blog"},
+		{"/docs/", "//blog//post", "//blog//post"},
+		{"/docs/", "a/../b/./c.html", "/docs/b/c.html"},
+		{"/docs/", "/", "/docs/"},
+		{"https://example.com/docs/", "/blog/", "https://example.com/docs/blog/"},
+		{"/docs/", "https://other.com/x", "https://other.com/x"},
+	}
+	for _, test := range tests {
+		al := &Alvu{config: Config{BaseURL: test.baseURL}}
+		if got := al.linkURL(test.link); got != test.expected {
+			t.Errorf("link %q with the baseurl %q = %q, expected %q", test.link, test.baseURL, got, test.expected)
+		}
+	}
+}
+
+func TestSlugifyAndDateInTemplates(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"pages/post.html": "---\ntitle: Héllo, World!\ndate: 2024-03-05\nupdated: soon\n---\n" +
+			`{{slugify .Page.title}}|{{date "Jan 2, 2006" .Page.date}}|{{date "Jan 2, 2006" .Page.updated}}`,
+	})
+	if err := buildSite(t, dir, Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "hello-world|Mar 5, 2024|soon"
+	if post := readOutput(t, dir, "post.html"); !strings.Contains(post, expected) {
+		t.Errorf("expected %q in the page, got %q", expected, post)
+	}
+}
+
+func TestPartialsGetThePageData(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"partials/nav.html":       `<nav>{{.Page.title}} at {{.Meta.BaseURL}}</nav>`,
+		"partials/blog/card.html": `<article>{{.title}}</article>`,
+		"pages/_layout.html":      `{{template "partials/nav" .}}{{.Content}}`,
+		"pages/post.md":           "---\ntitle: Post\n---\n\n{{template \"partials/blog/card\" .Page}}",
+	})
+	if err := buildSite(t, dir, Config{BaseURL: "/docs/"}); err != nil {
+		t.Fatal(err)
+	}
+
+	post := readOutput(t, dir, "post.html")
+	if !strings.HasPrefix(post, "<nav>Post at /docs/</nav>") {
+		t.Errorf("expected the nav partial with the page data in the layout, got %q", post)
+	}
+	if !strings.Contains(post, "<article>Post</article>") {
+		t.Errorf("expected the card partial with the data it was given, got %q", post)
+	}
+}
+
+func TestNearestLayoutWins(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"pages/_layout.html":             `root|{{.Content}}`,
+		"pages/blog/_layout.html":        `blog|{{.Content}}`,
+		"pages/blog/notes/_layout.html":  `notes|{{.Content}}`,
+		"pages/index.md":                 "index",
+		"pages/blog/post.md":             "post",
+		"pages/blog/2024/old.md":         "old",
+		"pages/blog/notes/note.md":       "note",
+		"pages/blog/notes/deep/later.md": "later",
+	})
+	if err := buildSite(t, dir, Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"index.html", "root|"},
+		{"blog/post.html", "blog|"},
+		{"blog/2024/old.html", "blog|"},
+		{"blog/notes/note.html", "notes|"},
+		{"blog/notes/deep/later.html", "notes|"},
+	}
+	for _, tt := range tests {
+		if got := readOutput(t, dir, tt.name); !strings.HasPrefix(got, tt.want) {
+			t.Errorf("%v = %q, want it to start with %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUnreadableLayoutIsAnError(t *testing.T) {
+	t.Run("directory", func(t *testing.T) {
+		dir := writeSite(t, map[string]string{
+			"pages/_layout.html/keep": "",
+			"pages/index.md":          "index",
+		})
+		err := buildSite(t, dir, Config{})
+		if err == nil {
+			t.Fatal("Build() with a directory for the layout, want an error")
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Build() = %v, want an error other than fs.ErrNotExist", err)
+		}
+	})
+
+	t.Run("permission", func(t *testing.T) {
+		if os.Geteuid() == 0 {
+			t.Skip("root can read the file anyway")
+		}
+		dir := writeSite(t, map[string]string{
+			"pages/_layout.html": "{{.Content}}",
+			"pages/index.md":     "index",
+		})
+		if err := os.Chmod(filepath.Join(dir, "pages", "_layout.html"), 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := buildSite(t, dir, Config{}); !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("Build() = %v, want fs.ErrPermission", err)
+		}
+	})
+}
+
+func TestMissingLayoutIsSkipped(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"pages/index.md": "index",
+	})
+	if err := buildSite(t, dir, Config{}); err != nil {
+		t.Fatalf("Build() without a layout = %v, want no error", err)
+	}
+}
@@ -0,0 +1,279 @@
+package alvu
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/barelyhuman/go/color"
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watcher rebuilds the site on changes to the directories
+// added with AddDir, it's built on fsnotify so the nested
+// directories are watched one by one
+type Watcher struct {
+	alvu *Alvu
+	// interval is the milliseconds to wait for more
+	// changes before rebuilding
+	interval int
+	dirs     []string
+	// done is closed once the watcher has stopped
+	done chan struct{}
+}
+
+func NewWatcher(alvu *Alvu, interval int) *Watcher {
+	watcher := &Watcher{
+		alvu:     alvu,
+		interval: interval,
+	}
+
+	return watcher
+}
+
+// AddDir adds the directory, with the ones nested in
+// it, to the directories watched by StartWatching
+func (w *Watcher) AddDir(dirPath string) {
+
+	for _, pth := range w.dirs {
+		if pth == dirPath {
+			return
+		}
+	}
+
+	// directories that don't exist are left out
+	// (eg: a project without hooks)
+	if _, err := os.Stat(dirPath); err != nil {
+		return
+	}
+
+	w.dirs = append(w.dirs, dirPath)
+}
+
+// watchTree adds the directory and every directory in it
+// to the watcher, fsnotify doesn't watch them recursively
+func watchTree(notify *fsnotify.Watcher, dirPath string) error {
+	return filepath.WalkDir(dirPath, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if err := notify.Add(filePath); err != nil {
+			return fmt.Errorf("failed to watch %v, error: %v", filePath, err)
+		}
+		return nil
+	})
+}
+
+func (w *Watcher) RebuildAlvu() error {
+	onDebug(func() {
+		debugInfo("Rebuild Started")
+	})
+	if err := w.alvu.CopyPublic(); err != nil {
+		return err
+	}
+	if err := w.alvu.LoadSiteData(); err != nil {
+		return err
+	}
+	if err := w.alvu.reloadTemplateFiles(); err != nil {
+		return err
+	}
+	if err := w.alvu.Build(); err != nil {
+		return err
+	}
+	onDebug(func() {
+		debugInfo("Build Completed")
+	})
+	return nil
+}
+
+func (w *Watcher) RebuildFile(filePath string) error {
+	onDebug(func() {
+		debugInfo("RebuildFile Started")
+	})
+	for i, af := range w.alvu.files {
+		if af.sourcePath != filePath {
+			continue
+		}
+
+		if err := w.alvu.files[i].Load(); err != nil {
+			return err
+		}
+		// the other pages have the meta of this one in their
+		// `.Pages`, prev/next links and taxonomy terms, so they
+		// are all rendered again once it changes, as is the
+		// single file since it has every page in it
+		pages := w.alvu.pages
+		w.alvu.CollectPages()
+		if w.alvu.singleFile != nil || !reflect.DeepEqual(pages, w.alvu.pages) {
+			return w.alvu.Render()
+		}
+
+		// the hooks from the last full build might belong to
+		// a worker that's already been shutdown
+		w.alvu.files[i].hooks = w.alvu.hooks
+		if err := w.alvu.files[i].Build(); err != nil {
+			return err
+		}
+		// the feed and search index have the content as well
+		if err := w.alvu.writeIndexes(); err != nil {
+			return err
+		}
+		break
+	}
+	onDebug(func() {
+		debugInfo("RebuildFile Completed")
+	})
+	return nil
+}
+
+// StartWatching rebuilds on changes till the context is cancelled
+func (w *Watcher) StartWatching(ctx context.Context) error {
+	notify, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to start watching for changes, error: %v", err)
+	}
+	for _, dirPath := range w.dirs {
+		if err := watchTree(notify, dirPath); err != nil {
+			notify.Close()
+			return err
+		}
+	}
+
+	w.done = make(chan struct{})
+	go func() {
+		defer close(w.done)
+		defer notify.Close()
+
+		// editors tend to write more than once on save (or write
+		// to a new file and rename it) so hold the changes for
+		// the interval before rebuilding
+		changed := map[string]bool{}
+		var debounce <-chan time.Time
+
+		for {
+			select {
+			case evt := <-notify.Events:
+				onDebug(func() {
+					debugInfo("Events registered")
+				})
+				if !evt.Has(fsnotify.Write) && !evt.Has(fsnotify.Create) {
+					continue
+				}
+
+				// Do nothing if the file doesn't exit, just continue
+				info, err := os.Stat(evt.Name)
+				if err != nil {
+					continue
+				}
+
+				// new directories are watched as well, the files
+				// that made it in before that are picked up on the
+				// next write
+				if info.IsDir() {
+					if err := watchTree(notify, evt.Name); err != nil {
+						logError(err)
+					}
+					continue
+				}
+
+				changed[evt.Name] = true
+				debounce = time.After(time.Duration(w.interval) * time.Millisecond)
+				continue
+
+			case <-debounce:
+				debounce = nil
+				// a broken page shouldn't stop the server, the
+				// error is logged and the next save rebuilds again
+				if err := w.rebuildChanged(changed); err != nil {
+					logError(err)
+				}
+				changed = map[string]bool{}
+
+			case err := <-notify.Errors:
+				// fsnotify keeps watching after an error
+				logError(fmt.Errorf("watching for changes: %v", err))
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Wait blocks till the watcher has stopped, after
+// the rebuild that was running, if any, is done
+func (w *Watcher) Wait() {
+	<-w.done
+}
+
+func (w *Watcher) rebuildChanged(changed map[string]bool) error {
+	recompiledText := &color.ColorString{}
+	recompiledText.Blue(logPrefix).Green("Recompiled!").Reset(" ")
+
+	hooksChanged := false
+	rebuildAll := false
+	publicChanged := []string{}
+	for changedPath := range changed {
+		if strings.HasPrefix(changedPath, w.alvu.hooksPath+"/") {
+			hooksChanged = true
+		}
+		// files from public are just copied again, unless
+		// they're fingerprinted since the pages would then
+		// need to point to the new name
+		if strings.HasPrefix(changedPath, w.alvu.publicPath+"/") && w.alvu.assetManifest == nil {
+			publicChanged = append(publicChanged, changedPath)
+			continue
+		}
+		if !w.alvu.IsAlvuFile(changedPath) {
+			rebuildAll = true
+		}
+	}
+
+	// If alvu file then just build the file, else
+	// just rebuilt the whole folder since it could
+	// be a hook or the _layout file
+	if rebuildAll {
+		recompilingText := &color.ColorString{}
+		recompilingText.Blue(logPrefix).Cyan("Recompiling: ").Gray("All").Reset(" ")
+		fmt.Println(recompilingText.String())
+		if hooksChanged {
+			if err := w.alvu.ReloadHooks(); err != nil {
+				return err
+			}
+		}
+		if err := w.RebuildAlvu(); err != nil {
+			return err
+		}
+	} else {
+		for _, changedPath := range publicChanged {
+			copyingText := &color.ColorString{}
+			copyingText.Blue(logPrefix).Cyan("Copying: ").Gray(changedPath).Reset(" ")
+			fmt.Println(copyingText.String())
+			if err := w.alvu.CopyPublicFile(changedPath); err != nil {
+				return err
+			}
+			delete(changed, changedPath)
+		}
+		for changedPath := range changed {
+			recompilingText := &color.ColorString{}
+			recompilingText.Blue(logPrefix).Cyan("Recompiling: ").Gray(changedPath).Reset(" ")
+			fmt.Println(recompilingText.String())
+			if err := w.RebuildFile(changedPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	w.alvu._clientNotifyReload()
+	fmt.Println(recompiledText.String())
+	return nil
+}
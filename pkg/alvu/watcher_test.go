@@ -0,0 +1,133 @@
+package alvu
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRebuildFileRendersDependentsOnMetaChange(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"pages/index.html": `{{range .Pages}}[{{.Meta.title}}]{{end}}`,
+		"pages/post.md":    "---\ntitle: First\n---\n\nBody",
+	})
+	watcher := NewWatcher(buildAlvu(t, dir, Config{NoCache: true}), 0)
+	if index := readOutput(t, dir, "index.html"); !strings.Contains(index, "[First]") {
+		t.Fatalf("expected the title in the index, got %q", index)
+	}
+
+	postPath := filepath.Join(dir, "pages", "post.md")
+	if err := os.WriteFile(postPath, []byte("---\ntitle: Second\n---\n\nBody"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := watcher.RebuildFile(postPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if index := readOutput(t, dir, "index.html"); !strings.Contains(index, "[Second]") {
+		t.Errorf("expected the index to have the new title, got %q", index)
+	}
+}
+
+func TestRebuildFileOnlyBuildsThePageOnContentChange(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"pages/index.html": `{{range .Pages}}[{{.Meta.title}}]{{end}}`,
+		"pages/post.md":    "---\ntitle: First\n---\n\nBody",
+	})
+	watcher := NewWatcher(buildAlvu(t, dir, Config{NoCache: true}), 0)
+
+	// the index isn't rendered again so this stays
+	indexPath := filepath.Join(dir, "dist", "index.html")
+	if err := os.WriteFile(indexPath, []byte("untouched"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	postPath := filepath.Join(dir, "pages", "post.md")
+	if err := os.WriteFile(postPath, []byte("---\ntitle: First\n---\n\nNew body"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := watcher.RebuildFile(postPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if post := readOutput(t, dir, "post.html"); !strings.Contains(post, "New body") {
+		t.Errorf("expected the post to be built again, got %q", post)
+	}
+	if index := readOutput(t, dir, "index.html"); index != "untouched" {
+		t.Errorf("expected the index to be left alone, got %q", index)
+	}
+}
+
+func TestRebuildFileWithHookTemplateFuncs(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"hooks/funcs.lua":    templateFuncsHook,
+		"pages/_layout.html": `{{shout "layout"}}|{{.Content}}`,
+		"pages/post.md":      "---\ntitle: First\n---\n\n{{shout \"body\"}}",
+	})
+	watcher := NewWatcher(buildAlvu(t, dir, Config{NoCache: true}), 0)
+
+	postPath := filepath.Join(dir, "pages", "post.md")
+	if err := os.WriteFile(postPath, []byte("---\ntitle: First\n---\n\n{{shout \"new body\"}}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := watcher.RebuildFile(postPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if post, want := readOutput(t, dir, "post.html"), "LAYOUT!|<p>NEW BODY!</p>\n"; post != want {
+		t.Errorf("expected %q after the rebuild, got %q", want, post)
+	}
+}
+
+func TestWatcherRebuildsNestedPages(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"pages/blog/2024/post.md": "First",
+	})
+	al := buildAlvu(t, dir, Config{NoCache: true})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watcher := NewWatcher(al, 10)
+	watcher.AddDir(al.pagesPath)
+	if err := watcher.StartWatching(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cancel()
+		watcher.Wait()
+	}()
+
+	postPath := filepath.Join(dir, "pages", "blog", "2024", "post.md")
+	if err := os.WriteFile(postPath, []byte("Second"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !strings.Contains(readOutput(t, dir, "blog/2024/post.html"), "Second") {
+		if time.Now().After(deadline) {
+			t.Fatal("the page wasn't rebuilt after the change")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRebuildFileClearsRemovedFrontMatter(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"pages/index.html": `{{range .Pages}}[{{.Meta.title}}]{{end}}`,
+		"pages/post.md":    "---\ntitle: First\n---\n\nBody",
+	})
+	watcher := NewWatcher(buildAlvu(t, dir, Config{NoCache: true}), 0)
+
+	postPath := filepath.Join(dir, "pages", "post.md")
+	if err := os.WriteFile(postPath, []byte("Body"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := watcher.RebuildFile(postPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if index := readOutput(t, dir, "index.html"); strings.Contains(index, "First") {
+		t.Errorf("expected the removed title to be gone from the index, got %q", index)
+	}
+}